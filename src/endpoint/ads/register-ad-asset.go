@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/ads"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/ingest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"os"
+)
+
+var (
+	redisClient *redis.Client
+	locker      *redlock.Redlock
+	adStore     ads.Store
+)
+
+func HandleRegisterAdAsset(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	headers := map[string]string{
+		"Access-Control-Allow-Headers": "Content-Type",
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "OPTIONS,POST",
+	}
+
+	playlistId, err := uuid.Parse(event.PathParameters["playlistId"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    headers,
+			Body:       err.Error(),
+		}, nil
+	}
+	asset := &model.AdAsset{}
+	err = json.Unmarshal([]byte(event.Body), asset)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    headers,
+			Body:       fmt.Errorf("%w: %v", ingest.ErrInvalidAdAsset, err).Error(),
+		}, nil
+	}
+
+	ingester := ingest.NewIngester(repository.NewStreamRepository(redisClient), locker)
+	ingester.Ads = adStore
+	err = ingester.RegisterAdAsset(ctx, playlistId, asset)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Headers:    headers,
+			Body:       err.Error(),
+		}, nil
+	}
+
+	body, err := json.Marshal(asset)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    headers,
+			Body:       err.Error(),
+		}, err
+	}
+
+	headers["Content-Type"] = "application/json"
+	return events.APIGatewayProxyResponse{
+		StatusCode: 201,
+		Headers:    headers,
+		Body:       string(body),
+	}, nil
+}
+
+func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	locker = redlock.New(redisClient)
+	if addresses := os.Getenv("REDLOCK_ADDRESSES"); addresses != "" {
+		locker = redlock.NewFromAddresses(addresses)
+	}
+	if bucket := os.Getenv("AD_BUCKET"); bucket != "" {
+		adStore = ads.NewS3Store(s3.New(session.Must(session.NewSession())), bucket)
+	} else if directory := os.Getenv("AD_DIRECTORY"); directory != "" {
+		adStore = ads.NewFileStore(directory)
+	}
+	lambda.Start(HandleRegisterAdAsset)
+}
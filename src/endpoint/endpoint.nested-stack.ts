@@ -3,9 +3,10 @@ import { HttpApi, HttpMethod } from "@aws-cdk/aws-apigatewayv2-alpha";
 import { AddRoutesOptions } from "@aws-cdk/aws-apigatewayv2-alpha/lib/http/api";
 import { HttpLambdaIntegration } from "@aws-cdk/aws-apigatewayv2-integrations-alpha";
 import { GoFunction } from "@aws-cdk/aws-lambda-go-alpha";
-import { NestedStack, NestedStackProps } from "aws-cdk-lib";
+import { Duration, NestedStack, NestedStackProps } from "aws-cdk-lib";
 import { Vpc } from "aws-cdk-lib/aws-ec2";
 import { CfnCacheCluster, CfnSubnetGroup } from "aws-cdk-lib/aws-elasticache";
+import { BlockPublicAccess, Bucket } from "aws-cdk-lib/aws-s3";
 import { Construct } from "constructs";
 
 export interface EndpointNestedStackProps extends NestedStackProps {
@@ -27,6 +28,11 @@ export class EndpointNestedStack extends NestedStack {
     cacheSubnetGroupName: this.redisSubnetGroup.cacheSubnetGroupName,
   });
 
+  // media of the ad assets spliced into ad breaks, read by the segment ingest
+  adBucket = new Bucket(this, "AdBucket", {
+    blockPublicAccess: BlockPublicAccess.BLOCK_ALL,
+  });
+
   queryAdminEventsLambda = new GoFunction(this, "QueryAdminEventsLambda", {
     entry: join(__dirname, "adminevents", "query-admin-events.go"),
     vpc: this.props.vpc,
@@ -46,6 +52,93 @@ export class EndpointNestedStack extends NestedStack {
     },
   });
 
+  queryStreamStatsLambda = new GoFunction(this, "QueryStreamStatsLambda", {
+    entry: join(__dirname, "stats", "query-stream-stats.go"),
+    vpc: this.props.vpc,
+    environment: {
+      REDIS_ADDRESS: this.redisCluster.attrRedisEndpointAddress,
+    },
+  });
+
+  queryMultivariantPlaylistLambda = new GoFunction(
+    this,
+    "QueryMultivariantPlaylistLambda",
+    {
+      entry: join(__dirname, "playlist", "query-multivariant-playlist.go"),
+      vpc: this.props.vpc,
+      environment: {
+        REDIS_ADDRESS: this.redisCluster.attrRedisEndpointAddress,
+      },
+    }
+  );
+
+  queryMediaPlaylistLambda = new GoFunction(this, "QueryMediaPlaylistLambda", {
+    entry: join(__dirname, "playlist", "query-media-playlist.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.seconds(30),
+    environment: {
+      REDIS_ADDRESS: this.redisCluster.attrRedisEndpointAddress,
+    },
+  });
+
+  queryMediaLambda = new GoFunction(this, "QueryMediaLambda", {
+    entry: join(__dirname, "playlist", "query-media.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.seconds(30),
+    environment: {
+      REDIS_ADDRESS: this.redisCluster.attrRedisEndpointAddress,
+    },
+  });
+
+  queryAssetListLambda = new GoFunction(this, "QueryAssetListLambda", {
+    entry: join(__dirname, "playlist", "query-asset-list.go"),
+    vpc: this.props.vpc,
+    environment: {
+      REDIS_ADDRESS: this.redisCluster.attrRedisEndpointAddress,
+    },
+  });
+
+  scheduleInterstitialLambda = new GoFunction(
+    this,
+    "ScheduleInterstitialLambda",
+    {
+      entry: join(__dirname, "interstitials", "schedule-interstitial.go"),
+      vpc: this.props.vpc,
+      timeout: Duration.seconds(10),
+      environment: {
+        REDIS_ADDRESS: this.redisCluster.attrRedisEndpointAddress,
+      },
+    }
+  );
+
+  cancelInterstitialLambda = new GoFunction(this, "CancelInterstitialLambda", {
+    entry: join(__dirname, "interstitials", "cancel-interstitial.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.seconds(10),
+    environment: {
+      REDIS_ADDRESS: this.redisCluster.attrRedisEndpointAddress,
+    },
+  });
+
+  registerAdAssetLambda = new GoFunction(this, "RegisterAdAssetLambda", {
+    entry: join(__dirname, "ads", "register-ad-asset.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.seconds(10),
+    environment: {
+      REDIS_ADDRESS: this.redisCluster.attrRedisEndpointAddress,
+      AD_BUCKET: this.adBucket.bucketName,
+    },
+  });
+
+  removeAdAssetLambda = new GoFunction(this, "RemoveAdAssetLambda", {
+    entry: join(__dirname, "ads", "remove-ad-asset.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.seconds(10),
+    environment: {
+      REDIS_ADDRESS: this.redisCluster.attrRedisEndpointAddress,
+    },
+  });
+
   queryRoomParticipantsLambda = new GoFunction(this, "roomLambda", {
     entry: join(__dirname, "room", "query-participants.go"),
     vpc: this.props.vpc,
@@ -59,6 +152,7 @@ export class EndpointNestedStack extends NestedStack {
     super(scope, id, props);
 
     this.redisCluster.addDependency(this.redisSubnetGroup);
+    this.adBucket.grantRead(this.registerAdAssetLambda);
 
     [
       {
@@ -85,6 +179,78 @@ export class EndpointNestedStack extends NestedStack {
           this.queryMunitStatsLambda
         ),
       },
+      {
+        path: "/v1/streamStats/{playlistId}",
+        methods: [HttpMethod.GET],
+        integration: new HttpLambdaIntegration(
+          "queryStreamStats",
+          this.queryStreamStatsLambda
+        ),
+      },
+      {
+        path: "/live/{playlistId}/master.m3u8",
+        methods: [HttpMethod.GET],
+        integration: new HttpLambdaIntegration(
+          "queryMultivariantPlaylist",
+          this.queryMultivariantPlaylistLambda
+        ),
+      },
+      {
+        path: "/live/{playlistId}/{mediaId}/playlist.m3u8",
+        methods: [HttpMethod.GET],
+        integration: new HttpLambdaIntegration(
+          "queryMediaPlaylist",
+          this.queryMediaPlaylistLambda
+        ),
+      },
+      {
+        path: "/live/{playlistId}/{mediaId}/{file}",
+        methods: [HttpMethod.GET],
+        integration: new HttpLambdaIntegration(
+          "queryMedia",
+          this.queryMediaLambda
+        ),
+      },
+      {
+        path: "/live/{playlistId}/interstitials/{interstitialId}/assets.json",
+        methods: [HttpMethod.GET],
+        integration: new HttpLambdaIntegration(
+          "queryAssetList",
+          this.queryAssetListLambda
+        ),
+      },
+      {
+        path: "/v1/interstitials/{playlistId}",
+        methods: [HttpMethod.POST],
+        integration: new HttpLambdaIntegration(
+          "scheduleInterstitial",
+          this.scheduleInterstitialLambda
+        ),
+      },
+      {
+        path: "/v1/interstitials/{playlistId}/{interstitialId}",
+        methods: [HttpMethod.DELETE],
+        integration: new HttpLambdaIntegration(
+          "cancelInterstitial",
+          this.cancelInterstitialLambda
+        ),
+      },
+      {
+        path: "/v1/ads/{playlistId}",
+        methods: [HttpMethod.POST],
+        integration: new HttpLambdaIntegration(
+          "registerAdAsset",
+          this.registerAdAssetLambda
+        ),
+      },
+      {
+        path: "/v1/ads/{playlistId}/{assetId}",
+        methods: [HttpMethod.DELETE],
+        integration: new HttpLambdaIntegration(
+          "removeAdAsset",
+          this.removeAdAssetLambda
+        ),
+      },
       {
         path: "/v1/participants/{roomId}",
         methods: [HttpMethod.GET],
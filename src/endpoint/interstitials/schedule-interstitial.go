@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/ingest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"os"
+)
+
+var (
+	redisClient *redis.Client
+	locker      *redlock.Redlock
+)
+
+func HandleScheduleInterstitial(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	headers := map[string]string{
+		"Access-Control-Allow-Headers": "Content-Type",
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "OPTIONS,POST",
+	}
+
+	playlistId, err := uuid.Parse(event.PathParameters["playlistId"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    headers,
+			Body:       err.Error(),
+		}, nil
+	}
+	interstitial := &model.Interstitial{}
+	err = json.Unmarshal([]byte(event.Body), interstitial)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Headers:    headers,
+			Body:       fmt.Errorf("%w: %v", ingest.ErrInvalidInterstitial, err).Error(),
+		}, nil
+	}
+
+	ingester := ingest.NewIngester(repository.NewStreamRepository(redisClient), locker)
+	err = ingester.ScheduleInterstitial(ctx, playlistId, interstitial)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Headers:    headers,
+			Body:       err.Error(),
+		}, nil
+	}
+
+	body, err := json.Marshal(interstitial)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    headers,
+			Body:       err.Error(),
+		}, err
+	}
+
+	headers["Content-Type"] = "application/json"
+	return events.APIGatewayProxyResponse{
+		StatusCode: 201,
+		Headers:    headers,
+		Body:       string(body),
+	}, nil
+}
+
+func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	locker = redlock.New(redisClient)
+	if addresses := os.Getenv("REDLOCK_ADDRESSES"); addresses != "" {
+		locker = redlock.NewFromAddresses(addresses)
+	}
+	lambda.Start(HandleScheduleInterstitial)
+}
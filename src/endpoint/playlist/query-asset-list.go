@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"os"
+)
+
+var redisClient *redis.Client
+
+func HandleQueryAssetList(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	repo := repository.NewStreamRepository(redisClient)
+	interstitial, err := repo.GetInterstitial(ctx, event.PathParameters["playlistId"], event.PathParameters["interstitialId"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			Body: err.Error(),
+		}, nil
+	}
+
+	body, err := json.Marshal(interstitial.AssetList())
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			Body: err.Error(),
+		}, err
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+			"Cache-Control":               "max-age=60",
+			"Content-Type":                "application/json",
+		},
+		Body: string(body),
+	}, nil
+}
+
+func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	lambda.Start(HandleQueryAssetList)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/playback"
+	"github.com/sehovizko/mobworx-streamer/src/internal/playlist"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"os"
+)
+
+var redisClient *redis.Client
+
+func HandleQueryMediaPlaylist(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	request, err := playback.ParseBlockingRequest(event.QueryStringParameters)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			Body: err.Error(),
+		}, nil
+	}
+
+	skip, err := playlist.ParseSkip(event.QueryStringParameters["_HLS_skip"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			Body: err.Error(),
+		}, nil
+	}
+
+	repo := repository.NewStreamRepository(redisClient)
+	cacheKey := event.PathParameters["playlistId"] + "/" + event.PathParameters["mediaId"]
+	media, err := playback.AwaitMediaPlaylist(ctx, repo, cacheKey, request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			Body: err.Error(),
+		}, nil
+	}
+
+	reports, err := repo.GetRenditionReports(ctx, media.PlaylistId)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			Body: err.Error(),
+		}, nil
+	}
+
+	mediaPlaylist := playlist.NewMedia(media)
+	mediaPlaylist.Skip = skip
+	mediaPlaylist.RenditionReports = reports
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+			"Cache-Control":               "max-age=1",
+			"Content-Type":                playlist.ContentType,
+		},
+		Body: mediaPlaylist.String(),
+	}, nil
+}
+
+func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	lambda.Start(HandleQueryMediaPlaylist)
+}
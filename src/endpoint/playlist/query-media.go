@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/base64"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/playback"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"os"
+)
+
+var redisClient *redis.Client
+
+func HandleQueryMedia(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	data, contentType, err := queryMedia(ctx, event)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			Body: err.Error(),
+		}, nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+			"Cache-Control":               "max-age=3600",
+			"Content-Type":                contentType,
+		},
+		Body:            base64.StdEncoding.EncodeToString(data),
+		IsBase64Encoded: true,
+	}, nil
+}
+
+func queryMedia(ctx aws.Context, event events.APIGatewayProxyRequest) ([]byte, string, error) {
+	request, err := playback.ParseMediaRequest(event.PathParameters["file"])
+	if err != nil {
+		return nil, "", err
+	}
+
+	repo := repository.NewStreamRepository(redisClient)
+	cacheKey := event.PathParameters["playlistId"] + "/" + event.PathParameters["mediaId"]
+	media, err := playback.AwaitMediaPlaylist(ctx, repo, cacheKey, request.BlockingRequest())
+	if err != nil {
+		return nil, "", err
+	}
+
+	mediaCacheKey, err := request.CacheKey(media)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := repo.GetMedia(ctx, mediaCacheKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType := media.MimeType
+	if contentType == "" {
+		contentType = string(signals.MimeTypeVideo)
+	}
+	return data, contentType, nil
+}
+
+func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	lambda.Start(HandleQueryMedia)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/playlist"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"os"
+)
+
+var redisClient *redis.Client
+
+func HandleQueryMultivariantPlaylist(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	repo := repository.NewStreamRepository(redisClient)
+	multivariant, err := repo.GetMultivariantPlaylist(ctx, event.PathParameters["playlistId"])
+	if errors.Is(err, repository.ErrMultivariantPlaylistNotFound) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 404,
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			Body: err.Error(),
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			Body: err.Error(),
+		}, nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+			"Cache-Control":               "max-age=1",
+			"Content-Type":                playlist.ContentType,
+		},
+		Body: playlist.NewMultivariant(multivariant).String(),
+	}, nil
+}
+
+func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	lambda.Start(HandleQueryMultivariantPlaylist)
+}
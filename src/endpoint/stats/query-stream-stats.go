@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"os"
+)
+
+type StreamStats struct {
+	Stats         map[string]int64     `json:"stats"`
+	Discrepancies []*model.Discrepancy `json:"discrepancies"`
+}
+
+var redisClient *redis.Client
+
+func HandleQueryStreamStats(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	headers := map[string]string{
+		"Access-Control-Allow-Headers": "Content-Type",
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "OPTIONS,GET",
+	}
+
+	repo := repository.NewStreamRepository(redisClient)
+	playlistId := event.PathParameters["playlistId"]
+	stats, err := repo.GetStreamStats(ctx, playlistId)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    headers,
+			Body:       err.Error(),
+		}, nil
+	}
+	discrepancies, err := repo.GetDiscrepancies(ctx, playlistId)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    headers,
+			Body:       err.Error(),
+		}, nil
+	}
+
+	body, err := json.Marshal(StreamStats{
+		Stats:         stats,
+		Discrepancies: discrepancies,
+	})
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Headers:    headers,
+			Body:       err.Error(),
+		}, err
+	}
+
+	headers["Content-Type"] = "application/json"
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    headers,
+		Body:       string(body),
+	}, nil
+}
+
+func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	lambda.Start(HandleQueryStreamStats)
+}
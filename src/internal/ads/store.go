@@ -0,0 +1,129 @@
+// Package ads stores the media of pre-conditioned ad assets which are spliced into live playlists.
+package ads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrMediaNotFound = fmt.Errorf("%d: ad media not found", 404)
+	ErrInvalidKey    = fmt.Errorf("%d: invalid ad media key", 400)
+)
+
+// Store reads the initialization sections and segments of ad assets by key.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Exists reports whether the store holds media under the key without reading it.
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// S3Store reads ad media from the objects of a bucket.
+type S3Store struct {
+	Client *s3.S3
+	Bucket string
+}
+
+func NewS3Store(client *s3.S3, bucket string) *S3Store {
+	return &S3Store{
+		Client: client,
+		Bucket: bucket,
+	}
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	err := checkKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, fmt.Errorf("%w: %s", ErrMediaNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = output.Body.Close() }()
+	return io.ReadAll(output.Body)
+}
+
+func (s *S3Store) Exists(ctx context.Context, key string) (bool, error) {
+	err := checkKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	// HEAD responses carry no body, so a missing object surfaces as the bare status text instead of NoSuchKey.
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && (awsErr.Code() == "NotFound" || awsErr.Code() == s3.ErrCodeNoSuchKey) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// FileStore reads ad media from the files below a directory, standing in for S3 on local setups.
+type FileStore struct {
+	Root string
+}
+
+func NewFileStore(root string) *FileStore {
+	return &FileStore{
+		Root: root,
+	}
+}
+
+func (s *FileStore) Get(_ context.Context, key string) ([]byte, error) {
+	err := checkKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.Root, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrMediaNotFound, key)
+	}
+	return data, err
+}
+
+func (s *FileStore) Exists(_ context.Context, key string) (bool, error) {
+	err := checkKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(filepath.Join(s.Root, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+// checkKey rejects keys which do not name a file below the root of the store.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package ads
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "spring", "720p"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "spring", "720p", "init.mp4"), []byte("init"), 0o644))
+	store := NewFileStore(root)
+
+	data, err := store.Get(ctx, "spring/720p/init.mp4")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("init"), data)
+
+	_, err = store.Get(ctx, "spring/720p/segment-0.m4s")
+	assert.ErrorIs(t, err, ErrMediaNotFound)
+
+	exists, err := store.Exists(ctx, "spring/720p/init.mp4")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	for _, key := range []string{"spring/720p/segment-0.m4s", "spring/720p"} {
+		exists, err = store.Exists(ctx, key)
+		require.NoError(t, err)
+		assert.False(t, exists, key)
+	}
+
+	for _, key := range []string{"", "/etc/passwd", "../secret", "spring/../../secret", "spring//init.mp4"} {
+		_, err = store.Get(ctx, key)
+		assert.ErrorIs(t, err, ErrInvalidKey, key)
+		_, err = store.Exists(ctx, key)
+		assert.ErrorIs(t, err, ErrInvalidKey, key)
+	}
+}
@@ -0,0 +1,250 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/playlist"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"log"
+	"path"
+	"time"
+)
+
+// KeyPrefix is the S3 key prefix the finished streams are archived under, laid out like the live endpoints:
+// <playlistId>/master.m3u8 next to a <mediaId>/ folder per variant and rendition.
+const KeyPrefix = "vod/"
+
+// Retention is how long the playlists and media of an archived stream stay cached, so players which still
+// follow the live playlists can play out their window.
+const Retention = 10 * time.Minute
+
+var ErrNotLive = fmt.Errorf("%d: stream is not live", 409)
+
+// Dumper stores archived objects, see helpers.Utils.
+type Dumper interface {
+	DumpToS3(key string, data []byte) (*s3.PutObjectOutput, error)
+}
+
+type Archiver struct {
+	Repository *repository.StreamRepository
+	Redlock    *redlock.Redlock
+	Dumper     Dumper
+}
+
+func NewArchiver(repo *repository.StreamRepository, redlock *redlock.Redlock, dumper Dumper) *Archiver {
+	return &Archiver{
+		Repository: repo,
+		Redlock:    redlock,
+		Dumper:     dumper,
+	}
+}
+
+// Archive writes every segment and initialization section still cached for the stream to S3 along with
+// VOD media playlists and the multivariant playlist, and takes the stream off the live streams.
+// The cached copies of the playlists and their media expire after the retention.
+func (a *Archiver) Archive(ctx context.Context, playlistId string) error {
+	lock, err := a.Redlock.Acquire(ctx, "archive/"+playlistId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := lock.Release(ctx)
+		if err != nil {
+			log.Println("releasing lock failed: ", err)
+		}
+	}()
+
+	live, err := a.Repository.IsLivePlaylist(ctx, playlistId)
+	if err != nil {
+		return err
+	}
+	if !live {
+		return fmt.Errorf("%w: %s", ErrNotLive, playlistId)
+	}
+
+	multivariant, err := a.Repository.GetMultivariantPlaylist(ctx, playlistId)
+	if err != nil {
+		return err
+	}
+
+	expirations := map[string]time.Duration{}
+	for _, cacheKey := range multivariant.MediaCacheKeys() {
+		media, err := a.Repository.GetMediaPlaylist(ctx, cacheKey)
+		if errors.Is(err, repository.ErrMediaPlaylistNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = a.archiveMedia(ctx, media)
+		if err != nil {
+			return err
+		}
+		for _, key := range cachedMedia(media) {
+			expirations[key] = Retention
+		}
+	}
+
+	err = a.dump(path.Join(playlistId, "master.m3u8"), []byte(playlist.NewMultivariant(multivariant).String()))
+	if err != nil {
+		return err
+	}
+	// Expiring before leaving the live streams lets the next attempt retry a failure.
+	err = a.Repository.ExpireMedia(ctx, expirations)
+	if err != nil {
+		return err
+	}
+	err = a.Repository.ExpirePlaylists(ctx, playlistId, multivariant.MediaCacheKeys(), Retention)
+	if err != nil {
+		return err
+	}
+	return a.Repository.RemoveLivePlaylist(ctx, playlistId)
+}
+
+// ArchiveIdle archives the live streams none of whose playlists was updated within the timeout
+// and returns their ids. A stream which fails to archive is logged and left for the next run, so it does not hold
+// back the others. Streams whose master playlist already expired leave the live streams, as there is nothing to archive.
+func (a *Archiver) ArchiveIdle(ctx context.Context, timeout time.Duration) ([]string, error) {
+	playlistIds, err := a.Repository.GetLivePlaylistIds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var archived []string
+	for _, playlistId := range playlistIds {
+		updatedAt, err := a.lastUpdate(ctx, playlistId)
+		if errors.Is(err, repository.ErrMultivariantPlaylistNotFound) {
+			log.Printf("master playlist of %s expired, removing it from the live streams", playlistId)
+			err = a.Repository.RemoveLivePlaylist(ctx, playlistId)
+			if err != nil {
+				log.Printf("removing %s from the live streams failed: %v", playlistId, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("reading the last update of %s failed: %v", playlistId, err)
+			continue
+		}
+		if time.Since(updatedAt) < timeout {
+			continue
+		}
+
+		err = a.Archive(ctx, playlistId)
+		if errors.Is(err, ErrNotLive) || errors.Is(err, redlock.ErrLockNotAcquired) {
+			continue
+		}
+		if err != nil {
+			log.Printf("archiving %s failed: %v", playlistId, err)
+			continue
+		}
+		archived = append(archived, playlistId)
+	}
+	return archived, nil
+}
+
+// cachedMedia returns the keys of the initialization sections, segments and parts the media playlist lists,
+// which nothing evicts once the stream stopped.
+func cachedMedia(media *model.MediaPlaylist) []string {
+	var keys []string
+	if media.InitCacheKey != "" {
+		keys = append(keys, media.InitCacheKey)
+	}
+	for _, segment := range media.Segments {
+		if segment.InitCacheKey != "" {
+			keys = append(keys, segment.InitCacheKey)
+		}
+		if segment.Complete && !segment.Gap {
+			keys = append(keys, segment.CacheKey)
+		}
+		for _, part := range segment.Parts {
+			if !part.Gap {
+				keys = append(keys, part.CacheKey)
+			}
+		}
+	}
+	return keys
+}
+
+// archiveMedia dumps the complete segments whose data is still cached. A segment which already expired
+// is left out of the VOD playlist, which marks the hole with a discontinuity, while gap segments stay listed as gaps.
+func (a *Archiver) archiveMedia(ctx context.Context, media *model.MediaPlaylist) error {
+	folder := path.Join(media.PlaylistId, path.Base(media.CacheKey))
+	inits := map[string]bool{}
+
+	vod := *media
+	vod.Segments = nil
+	discontinuity := false
+	for _, segment := range media.Segments {
+		if !segment.Complete {
+			continue
+		}
+		if !segment.Gap {
+			data, err := a.Repository.GetMedia(ctx, segment.CacheKey)
+			if errors.Is(err, repository.ErrMediaNotFound) {
+				discontinuity = len(vod.Segments) > 0
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			err = a.dump(path.Join(folder, playlist.SegmentURI(segment.Sequence, media.MimeType)), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		if segment.InitCacheKey != "" && !inits[segment.InitCacheKey] {
+			init, err := a.Repository.GetMedia(ctx, segment.InitCacheKey)
+			if err != nil {
+				return err
+			}
+			err = a.dump(path.Join(folder, playlist.InitURI(segment.InitCacheKey)), init)
+			if err != nil {
+				return err
+			}
+			inits[segment.InitCacheKey] = true
+		}
+
+		archived := *segment
+		archived.Parts = nil
+		archived.Discontinuity = segment.Discontinuity || discontinuity
+		discontinuity = false
+		vod.Segments = append(vod.Segments, &archived)
+	}
+
+	rendered := playlist.NewMedia(&vod)
+	rendered.VOD = true
+	return a.dump(path.Join(folder, "playlist.m3u8"), []byte(rendered.String()))
+}
+
+func (a *Archiver) dump(key string, data []byte) error {
+	_, err := a.Dumper.DumpToS3(KeyPrefix+key, data)
+	return err
+}
+
+// lastUpdate returns the latest update of the master playlist and of its media playlists.
+func (a *Archiver) lastUpdate(ctx context.Context, playlistId string) (time.Time, error) {
+	multivariant, err := a.Repository.GetMultivariantPlaylist(ctx, playlistId)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	updatedAt := multivariant.UpdatedAt
+	for _, cacheKey := range multivariant.MediaCacheKeys() {
+		media, err := a.Repository.GetMediaPlaylist(ctx, cacheKey)
+		if errors.Is(err, repository.ErrMediaPlaylistNotFound) {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if media.UpdatedAt.After(updatedAt) {
+			updatedAt = media.UpdatedAt
+		}
+	}
+	return updatedAt, nil
+}
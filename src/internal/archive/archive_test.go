@@ -0,0 +1,156 @@
+package archive
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testPlaylistId = "932ac3aa-b11f-11ed-afa1-0242ac120002"
+	testVariantId  = "a3e4e680-b11f-11ed-afa1-0242ac120002"
+	testMapId      = "c9258c1e-b120-11ed-afa1-0242ac120002"
+)
+
+type testDumper struct {
+	sync.Mutex
+	Objects map[string]string
+}
+
+func (d *testDumper) DumpToS3(key string, data []byte) (*s3.PutObjectOutput, error) {
+	d.Lock()
+	defer d.Unlock()
+	d.Objects[key] = string(data)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func newTestArchiver(t *testing.T, updatedAt time.Time) (*Archiver, *testDumper) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	repo := repository.NewStreamRepository(client)
+
+	variantCacheKey := testPlaylistId + "/" + testVariantId
+	require.NoError(t, repo.SetMultivariantPlaylist(ctx, &model.MultivariantPlaylist{
+		Id:        testPlaylistId,
+		Version:   1,
+		Variants:  []*model.Variant{{Id: testVariantId, CacheKey: variantCacheKey, Codecs: "avc1.4dc00d", Bandwidth: 2048}},
+		UpdatedAt: updatedAt,
+	}))
+
+	media := &model.MediaPlaylist{
+		Id:                 testVariantId,
+		PlaylistId:         testPlaylistId,
+		CacheKey:           variantCacheKey,
+		InitCacheKey:       testPlaylistId + "/" + testMapId,
+		TargetDuration:     4,
+		TargetPartDuration: 1,
+		MediaSequence:      3,
+		UpdatedAt:          updatedAt,
+	}
+	require.NoError(t, repo.SetMedia(ctx, media.InitCacheKey, []byte("init")))
+	for sequence := 3; sequence < 7; sequence++ {
+		segment := &model.Segment{
+			Id:           "segment-" + strconv.Itoa(sequence),
+			Sequence:     sequence,
+			Duration:     4,
+			InitCacheKey: media.InitCacheKey,
+			CacheKey:     testPlaylistId + "/segment-" + strconv.Itoa(sequence),
+			Complete:     sequence < 6,
+			Parts:        []*model.Part{{Id: "part", Duration: 1, CacheKey: testPlaylistId + "/part"}},
+		}
+		media.Segments = append(media.Segments, segment)
+		if sequence != 4 {
+			require.NoError(t, repo.SetMedia(ctx, segment.CacheKey, []byte(segment.Id)))
+		}
+	}
+	require.NoError(t, repo.SetMediaPlaylist(ctx, media))
+
+	dumper := &testDumper{Objects: map[string]string{}}
+	return NewArchiver(repo, redlock.New(client), dumper), dumper
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	archiver, dumper := newTestArchiver(t, time.Now())
+
+	require.NoError(t, archiver.Archive(ctx, testPlaylistId))
+	folder := KeyPrefix + testPlaylistId + "/" + testVariantId + "/"
+	assert.Equal(t, map[string]string{
+		KeyPrefix + testPlaylistId + "/master.m3u8": "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=2048,CODECS=\"avc1.4dc00d\",CLOSED-CAPTIONS=NONE\n" + testVariantId + "/playlist.m3u8\n",
+		folder + testMapId + ".mp4": "init",
+		folder + "segment-3.m4s":    "segment-3",
+		folder + "segment-5.m4s":    "segment-5",
+		folder + "playlist.m3u8": "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-TARGETDURATION:4\n#EXT-X-PLAYLIST-TYPE:VOD\n" +
+			"#EXT-X-MEDIA-SEQUENCE:3\n" +
+			"#EXT-X-MAP:URI=\"" + testMapId + ".mp4\"\n#EXTINF:4.000,\nsegment-3.m4s\n" +
+			"#EXT-X-DISCONTINUITY\n#EXTINF:4.000,\nsegment-5.m4s\n#EXT-X-ENDLIST\n",
+	}, dumper.Objects)
+
+	live, err := archiver.Repository.IsLivePlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	assert.False(t, live)
+	// The cached copies of the stream expire, while the media already missing stays missing.
+	for _, key := range []string{
+		"multivariantplaylist:" + testPlaylistId,
+		"mediaplaylist:" + testPlaylistId + "/" + testVariantId,
+		"renditionreports:" + testPlaylistId,
+		testPlaylistId + "/" + testMapId,
+		testPlaylistId + "/segment-3",
+		testPlaylistId + "/segment-5",
+	} {
+		ttl, err := archiver.Repository.Client.TTL(ctx, key).Result()
+		require.NoError(t, err)
+		assert.Equal(t, Retention, ttl, key)
+	}
+	ttl, err := archiver.Repository.Client.TTL(ctx, testPlaylistId+"/segment-4").Result()
+	require.NoError(t, err)
+	assert.Negative(t, ttl)
+	assert.ErrorIs(t, archiver.Archive(ctx, testPlaylistId), ErrNotLive)
+}
+
+func TestArchiveIdle(t *testing.T) {
+	ctx := context.Background()
+
+	archiver, dumper := newTestArchiver(t, time.Now())
+	archived, err := archiver.ArchiveIdle(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, archived)
+	assert.Empty(t, dumper.Objects)
+
+	archiver, dumper = newTestArchiver(t, time.Now().Add(-2*time.Minute))
+	archived, err = archiver.ArchiveIdle(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testPlaylistId}, archived)
+	assert.Len(t, dumper.Objects, 5)
+}
+
+func TestArchiveIdleRemovesExpiredStreams(t *testing.T) {
+	ctx := context.Background()
+	archiver, dumper := newTestArchiver(t, time.Now().Add(-2*time.Minute))
+	repo := archiver.Repository
+
+	// The master playlist of the other stream expired while it was still listed as live.
+	expired := "5e0c7a7c-b122-11ed-afa1-0242ac120002"
+	require.NoError(t, repo.Client.SAdd(ctx, "liveplaylists", expired).Err())
+
+	archived, err := archiver.ArchiveIdle(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testPlaylistId}, archived)
+	assert.Len(t, dumper.Objects, 5)
+	live, err := repo.GetLivePlaylistIds(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, live)
+}
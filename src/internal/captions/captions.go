@@ -0,0 +1,258 @@
+package captions
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SEI NAL unit types of H.264 and of HEVC, where prefix and suffix SEI have types of their own.
+const (
+	avcNALTypeSEI        = 6
+	hevcNALTypePrefixSEI = 39
+	hevcNALTypeSuffixSEI = 40
+)
+
+// seiUserDataRegistered is the payload type of user_data_registered_itu_t_t35 SEI messages.
+const seiUserDataRegistered = 4
+
+// ATSC A/53 caption data is registered under the US country code with the ATSC provider code and identifier.
+var atscCaptionHeader = []byte{0xb5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03}
+
+// cc_type values of the cc_data construct of CEA-708.
+const (
+	ccTypeField1      = 0
+	ccTypeField2      = 1
+	ccTypeDTVCCData   = 2
+	ccTypeDTVCCHeader = 3
+)
+
+// Detector collects the caption channels and services which the SEI messages of H.264 or HEVC samples carry.
+// CEA-608 channels are reported as CC1 to CC4 and CEA-708 services as SERVICE1 to SERVICE63, the values
+// of the INSTREAM-ID attribute of HLS. A detector keeps the caption packets spanning samples, so it is fed
+// the samples of a track in decode order.
+type Detector struct {
+	// LengthSize is the size of the length prefix of the NAL units of the samples.
+	LengthSize int
+	HEVC       bool
+
+	found  map[string]bool
+	packet []byte
+}
+
+func NewDetector(lengthSize int, hevc bool) *Detector {
+	return &Detector{
+		LengthSize: lengthSize,
+		HEVC:       hevc,
+		found:      map[string]bool{},
+	}
+}
+
+// Scan walks the NAL units of a sample. Malformed units end the scan of the sample without an error,
+// as captions are detected on a best effort basis.
+func (d *Detector) Scan(sample []byte) {
+	if d.LengthSize < 1 || d.LengthSize > 4 {
+		return
+	}
+	for len(sample) >= d.LengthSize {
+		size := 0
+		for _, b := range sample[:d.LengthSize] {
+			size = size<<8 | int(b)
+		}
+		sample = sample[d.LengthSize:]
+		if size > len(sample) {
+			return
+		}
+		d.scanNAL(sample[:size])
+		sample = sample[size:]
+	}
+}
+
+// InstreamIds returns the caption channels found so far, CEA-608 channels first and each kind in numeric order.
+func (d *Detector) InstreamIds() []string {
+	d.flushPacket()
+	ids := make([]string, 0, len(d.found))
+	for id := range d.found {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) < len(ids[j])
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func (d *Detector) scanNAL(nal []byte) {
+	headerSize := 1
+	if d.HEVC {
+		headerSize = 2
+	}
+	if len(nal) <= headerSize {
+		return
+	}
+	if d.HEVC {
+		if nalType := nal[0] >> 1 & 0x3f; nalType != hevcNALTypePrefixSEI && nalType != hevcNALTypeSuffixSEI {
+			return
+		}
+	} else if nal[0]&0x1f != avcNALTypeSEI {
+		return
+	}
+
+	rbsp := unescape(nal[headerSize:])
+	for len(rbsp) > 1 {
+		payloadType, rest, ok := seiValue(rbsp)
+		if !ok {
+			return
+		}
+		payloadSize, rest, ok := seiValue(rest)
+		if !ok || payloadSize > len(rest) {
+			return
+		}
+		if payloadType == seiUserDataRegistered {
+			d.scanUserData(rest[:payloadSize])
+		}
+		rbsp = rest[payloadSize:]
+	}
+}
+
+// scanUserData reads the cc_data of an ATSC A/53 user data payload.
+func (d *Detector) scanUserData(payload []byte) {
+	if !bytes.HasPrefix(payload, atscCaptionHeader) {
+		return
+	}
+	ccData := payload[len(atscCaptionHeader):]
+	if len(ccData) < 2 || ccData[0]&0x40 == 0 {
+		return
+	}
+	count := int(ccData[0] & 0x1f)
+	ccData = ccData[2:]
+
+	for i := 0; i < count && len(ccData) >= 3; i++ {
+		marker, data1, data2 := ccData[0], ccData[1], ccData[2]
+		ccData = ccData[3:]
+		if marker&0x04 == 0 {
+			continue
+		}
+		switch ccType := marker & 0x03; ccType {
+		case ccTypeField1, ccTypeField2:
+			d.scanCEA608(int(ccType), data1, data2)
+		case ccTypeDTVCCHeader:
+			d.flushPacket()
+			d.packet = []byte{data1, data2}
+		case ccTypeDTVCCData:
+			if d.packet != nil {
+				d.packet = append(d.packet, data1, data2)
+			}
+		}
+		if d.packet != nil && len(d.packet) >= packetSize(d.packet[0]) {
+			d.flushPacket()
+		}
+	}
+}
+
+// scanCEA608 attributes a control code pair to its caption channel. The data channel bit of the first byte
+// selects the first or second channel of the field; characters and extended data services are skipped.
+func (d *Detector) scanCEA608(field int, data1, data2 byte) {
+	data1, data2 = data1&0x7f, data2&0x7f
+	if data1 < 0x10 || data1 > 0x1f || data2 < 0x20 {
+		return
+	}
+	channel := 1 + 2*field
+	if data1&0x08 != 0 {
+		channel++
+	}
+	d.found[fmt.Sprintf("CC%d", channel)] = true
+}
+
+// flushPacket reads the service blocks of the pending DTVCC packet and records the services carrying data.
+func (d *Detector) flushPacket() {
+	packet := d.packet
+	d.packet = nil
+	if len(packet) == 0 {
+		return
+	}
+	if size := packetSize(packet[0]); len(packet) > size {
+		packet = packet[:size]
+	}
+
+	blocks := packet[1:]
+	for len(blocks) > 0 {
+		service, size := int(blocks[0]>>5), int(blocks[0]&0x1f)
+		blocks = blocks[1:]
+		if service == 0 {
+			return
+		}
+		if service == 7 && size > 0 {
+			if len(blocks) == 0 {
+				return
+			}
+			service = int(blocks[0] & 0x3f)
+			blocks = blocks[1:]
+		}
+		if size > len(blocks) {
+			return
+		}
+		if size > 0 && service > 0 {
+			d.found[fmt.Sprintf("SERVICE%d", service)] = true
+		}
+		blocks = blocks[size:]
+	}
+}
+
+// packetSize returns the size of a DTVCC packet including its header, as told by its packet_size_code.
+func packetSize(header byte) int {
+	if code := int(header & 0x3f); code > 0 {
+		return 2 * code
+	}
+	return 128
+}
+
+// seiValue reads a payload type or size of an SEI message, coded as a run of 0xff bytes and a final byte.
+func seiValue(data []byte) (int, []byte, bool) {
+	value := 0
+	for len(data) > 0 && data[0] == 0xff {
+		value += 0xff
+		data = data[1:]
+	}
+	if len(data) == 0 {
+		return 0, nil, false
+	}
+	return value + int(data[0]), data[1:], true
+}
+
+// unescape removes the emulation prevention bytes of a NAL unit payload.
+func unescape(data []byte) []byte {
+	if !bytes.Contains(data, []byte{0, 0, 3}) {
+		return data
+	}
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return rbsp
+}
+
+// IsInstreamId tells whether the value names a CEA-608 channel or a CEA-708 service the way INSTREAM-ID does.
+func IsInstreamId(value string) bool {
+	for prefix, last := range map[string]int{"CC": 4, "SERVICE": 63} {
+		if digits, found := strings.CutPrefix(value, prefix); found {
+			number, err := strconv.Atoi(digits)
+			return err == nil && number >= 1 && number <= last && strconv.Itoa(number) == digits
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package captions
+
+import (
+	"github.com/sehovizko/mobworx-streamer/src/internal/captions/captionstest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDetector(t *testing.T) {
+	cases := []struct {
+		Name     string
+		HEVC     bool
+		Samples  [][][3]byte
+		Expected []string
+	}{
+		{
+			Name: "cea608",
+			Samples: [][][3]byte{
+				{{0xfc, 0x94, 0x20}, {0xfc, 0xc8, 0xe9}, {0xfd, 0x15, 0x2c}},
+				{{0xfc, 0x1c, 0x2c}, {0xfa, 0x00, 0x00}, {0xf9, 0x80, 0x80}},
+			},
+			Expected: []string{"CC1", "CC2", "CC3"},
+		},
+		{
+			Name: "cea708",
+			HEVC: true,
+			Samples: [][][3]byte{
+				{{0xff, 0x03, 0xe1}, {0xfe, 0x0a, 0x41}},
+				{{0xfe, 0x00, 0x00}, {0xff, 0x02, 0x21}, {0xfe, 0x41, 0x00}},
+				{{0xff, 0x02, 0x40}, {0xfe, 0x00, 0x00}},
+			},
+			Expected: []string{"SERVICE1", "SERVICE10"},
+		},
+		{
+			Name:    "padding",
+			Samples: [][][3]byte{{{0xfc, 0x80, 0x80}, {0xfa, 0x00, 0x00}, {0xff, 0x02, 0x00}, {0xfe, 0x00, 0x00}}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			detector := NewDetector(4, c.HEVC)
+			for _, triples := range c.Samples {
+				detector.Scan(captionstest.Sample(c.HEVC, triples...))
+			}
+			assert.Equal(t, append([]string{}, c.Expected...), detector.InstreamIds())
+		})
+	}
+}
+
+func TestDetectorSkipsOtherData(t *testing.T) {
+	detector := NewDetector(4, false)
+
+	other := captionstest.Sample(false, [3]byte{0xfc, 0x94, 0x20})
+	other[4+4] = 0x2f
+	detector.Scan(other)
+	// Truncated samples and units without captions are skipped.
+	detector.Scan(captionstest.Sample(false, [3]byte{0xfc, 0x94, 0x20})[:20])
+	detector.Scan([]byte{0, 0, 0, 2, 0x65, 0x06})
+	assert.Empty(t, detector.InstreamIds())
+
+	hevc := NewDetector(4, true)
+	hevc.Scan(captionstest.Sample(false, [3]byte{0xfc, 0x94, 0x20}))
+	assert.Empty(t, hevc.InstreamIds())
+}
+
+func TestIsInstreamId(t *testing.T) {
+	for _, value := range []string{"CC1", "CC4", "SERVICE1", "SERVICE63"} {
+		assert.True(t, IsInstreamId(value), value)
+	}
+	for _, value := range []string{"", "CC0", "CC5", "CC01", "SERVICE64", "SERVICE", "service1", "CC1 "} {
+		assert.False(t, IsInstreamId(value), value)
+	}
+}
@@ -0,0 +1,49 @@
+// Package captionstest builds H.264 and HEVC samples carrying caption data for tests.
+package captionstest
+
+import "encoding/binary"
+
+// Sample wraps the cc_data triples of CEA-708 into an ATSC A/53 SEI message ahead of an IDR slice,
+// as a sample of NAL units with 4 byte length prefixes.
+func Sample(hevc bool, triples ...[3]byte) []byte {
+	payload := []byte{0xb5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03, 0x40 | byte(len(triples)), 0xff}
+	for _, triple := range triples {
+		payload = append(payload, triple[:]...)
+	}
+	payload = append(payload, 0xff)
+
+	sei := []byte{6}
+	slice := []byte{0x65, 0x88, 0x84, 0x00}
+	if hevc {
+		sei = []byte{39 << 1, 1}
+		slice = []byte{19 << 1, 1, 0xaf, 0x00}
+	}
+	rbsp := append([]byte{4, byte(len(payload))}, payload...)
+	sei = append(sei, escape(append(rbsp, 0x80))...)
+
+	var sample []byte
+	for _, nal := range [][]byte{sei, slice} {
+		sample = binary.BigEndian.AppendUint32(sample, uint32(len(nal)))
+		sample = append(sample, nal...)
+	}
+	return sample
+}
+
+// escape inserts the emulation prevention bytes a NAL unit payload needs.
+func escape(rbsp []byte) []byte {
+	var escaped []byte
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 3 {
+			escaped = append(escaped, 3)
+			zeros = 0
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		escaped = append(escaped, b)
+	}
+	return escaped
+}
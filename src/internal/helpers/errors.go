@@ -0,0 +1,23 @@
+package helpers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// StatusCodeOf extracts the status code errors of this project are prefixed with, e.g. "404: not found".
+func StatusCodeOf(err error) int {
+	if err == nil {
+		return 200
+	}
+
+	prefix, _, found := strings.Cut(err.Error(), ":")
+	if !found {
+		return 500
+	}
+	code, err := strconv.Atoi(prefix)
+	if err != nil || code < 400 || code > 599 {
+		return 500
+	}
+	return code
+}
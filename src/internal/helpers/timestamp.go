@@ -23,3 +23,11 @@ func (p *Timestamp) UnmarshalJSON(bytes []byte) error {
 	p.Time = time.Unix(raw, 0)
 	return nil
 }
+
+// MarshalJSON encodes the time.Time object back into an int64 timestamp
+func (p Timestamp) MarshalJSON() ([]byte, error) {
+	if p.IsZero() {
+		return json.Marshal(0)
+	}
+	return json.Marshal(p.Unix())
+}
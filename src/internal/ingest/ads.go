@@ -0,0 +1,443 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/ads"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidAdAsset = fmt.Errorf("%d: invalid ad asset", 400)
+	ErrNoAdStore      = fmt.Errorf("%d: no ad store configured", 503)
+)
+
+// maxAdDrift is how many seconds an ad segment may be longer or shorter than the live segment it replaces,
+// and how far apart the start of an ad break and the start of a segment may be to still line up.
+const maxAdDrift = 0.1
+
+// adAssetId matches the ids of ad assets.
+var adAssetId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RegisterAdAsset registers an ad asset for the ad breaks of a master playlist once the ad store holds all of
+// its media. Registering an asset under a known id replaces the asset and queues it behind the others.
+func (i *Ingester) RegisterAdAsset(ctx context.Context, playlistId uuid.UUID, asset *model.AdAsset) error {
+	if i.Ads == nil {
+		return ErrNoAdStore
+	}
+	err := validateAdAsset(asset)
+	if err != nil {
+		return err
+	}
+	for _, rendition := range asset.Renditions {
+		keys := []string{rendition.InitKey}
+		for _, segment := range rendition.Segments {
+			keys = append(keys, segment.Key)
+		}
+		for _, key := range keys {
+			exists, err := i.Ads.Exists(ctx, key)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("%w: %s", ads.ErrMediaNotFound, key)
+			}
+		}
+	}
+
+	_, err = i.Repository.GetMultivariantPlaylist(ctx, playlistId.String())
+	if err != nil {
+		return err
+	}
+	asset.CreatedAt = time.Now()
+	return i.Repository.SetAdAsset(ctx, playlistId.String(), asset)
+}
+
+// RemoveAdAsset stops splicing an ad asset into the ad breaks of a master playlist.
+func (i *Ingester) RemoveAdAsset(ctx context.Context, playlistId uuid.UUID, id string) error {
+	return i.Repository.DeleteAdAsset(ctx, playlistId.String(), id)
+}
+
+func validateAdAsset(asset *model.AdAsset) error {
+	switch {
+	case !adAssetId.MatchString(asset.Id):
+		return fmt.Errorf("%w: id %q", ErrInvalidAdAsset, asset.Id)
+	case len(asset.Renditions) == 0:
+		return fmt.Errorf("%w: no renditions", ErrInvalidAdAsset)
+	}
+	for index, rendition := range asset.Renditions {
+		switch {
+		case normalizeCodecs(rendition.Codecs) == "":
+			return fmt.Errorf("%w: rendition %d has no codecs", ErrInvalidAdAsset, index)
+		case rendition.Bandwidth <= 0:
+			return fmt.Errorf("%w: rendition %d has no bandwidth", ErrInvalidAdAsset, index)
+		case rendition.InitKey == "":
+			return fmt.Errorf("%w: rendition %d has no initialization section", ErrInvalidAdAsset, index)
+		case len(rendition.Segments) == 0:
+			return fmt.Errorf("%w: rendition %d has no segments", ErrInvalidAdAsset, index)
+		}
+		for _, segment := range rendition.Segments {
+			if segment.Key == "" || segment.Duration <= 0 {
+				return fmt.Errorf("%w: rendition %d has a segment without key or duration", ErrInvalidAdAsset, index)
+			}
+		}
+	}
+	return nil
+}
+
+// adPodSegment is a segment of an ad pod along with the asset and initialization section it belongs to.
+type adPodSegment struct {
+	AssetId string
+	InitKey string
+	Segment *model.AdSegment
+}
+
+// adPodOf returns the segments of the ad assets of the master playlist which match the variant or the audio rendition
+// of the payload, in the order they play. Of each asset, the rendition with the same codecs and the closest bandwidth
+// plays, and assets without such a rendition are left out. Audio renditions declare no bandwidth, so the leanest ad
+// rendition with their codecs plays. The pod is empty without an ad store, and for renditions other than the audio
+// renditions of an audio group a variant plays.
+func (i *Ingester) adPodOf(ctx context.Context, payload *signals.DataGeneralShapePayload) ([]*adPodSegment, error) {
+	if i.Ads == nil {
+		return nil, nil
+	}
+	audio := payload.Variant == nil && payload.Rendition != nil && payload.Rendition.Type == signals.DataRenditionTypeAudio
+	if payload.Variant == nil && !audio {
+		return nil, nil
+	}
+	assets, err := i.Repository.GetAdAssets(ctx, payload.Playlist.Id.String())
+	if err != nil || len(assets) == 0 {
+		return nil, err
+	}
+
+	var codecs string
+	var bandwidth int
+	if audio {
+		codecs = payload.Rendition.Codecs
+	} else {
+		codecs, bandwidth = payload.Variant.Codecs, payload.Variant.Bandwidth
+	}
+	if normalizeCodecs(codecs) == "" || audio {
+		multivariant, err := i.Repository.GetMultivariantPlaylist(ctx, payload.Playlist.Id.String())
+		if errors.Is(err, repository.ErrMultivariantPlaylistNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if audio {
+			codecs = audioCodecsOf(multivariant, payload.Rendition, codecs)
+		} else if variant := multivariant.Variant(payload.Variant.Id.String()); variant != nil {
+			codecs = variant.Codecs
+		}
+	}
+	if normalizeCodecs(codecs) == "" {
+		return nil, nil
+	}
+
+	var pod []*adPodSegment
+	for _, asset := range assets {
+		rendition := matchAdRendition(asset.Renditions, codecs, bandwidth)
+		if rendition == nil {
+			continue
+		}
+		for _, segment := range rendition.Segments {
+			pod = append(pod, &adPodSegment{AssetId: asset.Id, InitKey: rendition.InitKey, Segment: segment})
+		}
+	}
+	return pod, nil
+}
+
+// audioCodecsOf returns the codecs of the audio rendition, or nothing when no variant plays its audio group.
+// The codecs the publisher declares take precedence over those measured on its media.
+func audioCodecsOf(multivariant *model.MultivariantPlaylist, rendition *signals.DataGeneralShapePayloadRendition, declared string) string {
+	groupId := rendition.GroupId.String()
+	played := false
+	for _, variant := range multivariant.Variants {
+		played = played || variant.Audio == groupId
+	}
+	if !played {
+		return ""
+	}
+	if normalizeCodecs(declared) != "" {
+		return declared
+	}
+	if stored := multivariant.Rendition(rendition.Id.String()); stored != nil {
+		return stored.Codecs
+	}
+	return ""
+}
+
+func matchAdRendition(renditions []*model.AdRendition, codecs string, bandwidth int) *model.AdRendition {
+	wanted := strings.Split(normalizeCodecs(codecs), ",")
+	var match *model.AdRendition
+	for _, rendition := range renditions {
+		offered := strings.Split(normalizeCodecs(rendition.Codecs), ",")
+		if len(offered) != len(wanted) {
+			continue
+		}
+		same := true
+		for _, codec := range offered {
+			same = same && containsCodec(wanted, codec)
+		}
+		if !same {
+			continue
+		}
+		if match == nil || abs(rendition.Bandwidth-bandwidth) < abs(match.Bandwidth-bandwidth) {
+			match = rendition
+		}
+	}
+	return match
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// adSplice is the segment of an ad pod which replaces a live segment, and the keys its media is stored under.
+type adSplice struct {
+	BreakId      string
+	Position     int
+	Ad           *adPodSegment
+	Id           string
+	InitCacheKey string
+}
+
+// prepareAd stores the media of the ad segment which is going to replace the segment of an updateSegment message,
+// so spliceAd does not wait on the ad store while it holds the lock of the playlist. The initialization section of
+// an asset is stored once for all of its segments, and expires once no segment played it for a while.
+// It returns nil when the segment stays live.
+func (i *Ingester) prepareAd(ctx context.Context, seed *model.MediaPlaylist, segment *signals.DataGeneralShapePayloadSegment, pod []*adPodSegment) (*adSplice, error) {
+	playlist, err := i.Repository.GetMediaPlaylist(ctx, seed.CacheKey)
+	if errors.Is(err, repository.ErrMediaPlaylistNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The playlist read is a copy, which completes the segment the way storeSegment does to plan the splice.
+	stored := playlist.UpsertSegment(&model.Segment{Sequence: segment.Sequence})
+	stored.Duration = segment.Duration
+	if !segment.ProgramDateTime.IsZero() {
+		stored.ProgramDateTime = segment.ProgramDateTime.Time
+	}
+	stored.Complete = true
+	splice, _ := planAd(playlist, segmentIndex(playlist, stored), pod)
+	if splice == nil {
+		return nil, nil
+	}
+
+	exists, err := i.Repository.HasMedia(ctx, splice.InitCacheKey)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		init, err := i.Ads.Get(ctx, splice.Ad.InitKey)
+		if err != nil {
+			return nil, err
+		}
+		err = i.Repository.SetMedia(ctx, splice.InitCacheKey, init)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = i.Repository.ExpireMedia(ctx, map[string]time.Duration{splice.InitCacheKey: repository.StreamTTL})
+	if err != nil {
+		return nil, err
+	}
+	data, err := i.Ads.Get(ctx, splice.Ad.Segment.Key)
+	if err != nil {
+		return nil, err
+	}
+	return splice, i.Repository.SetMedia(ctx, playlist.PlaylistId+"/"+splice.Id, data)
+}
+
+// planAd returns the segment of the ad pod which replaces the complete segment at the index while it starts within
+// an ad break, or nil when it stays live, along with the time it starts at on the live timeline.
+// Ad breaks are the date ranges with SCTE35-OUT. A segment whose parts players already followed stays live.
+func planAd(playlist *model.MediaPlaylist, index int, pod []*adPodSegment) (*adSplice, time.Time) {
+	adBreak, position, start := adSlot(playlist, index, pod)
+	if adBreak == nil {
+		return nil, start
+	}
+	segment := playlist.Segments[index]
+	if len(segment.PublishedParts()) > 0 || math.Abs(pod[position].Segment.Duration-segment.Duration) > maxAdDrift {
+		return nil, start
+	}
+
+	ad := pod[position]
+	return &adSplice{
+		BreakId:      adBreak.Id,
+		Position:     position,
+		Ad:           ad,
+		Id:           uuid.NewSHA1(uuid.NameSpaceURL, []byte(playlist.CacheKey+"/ad/"+strconv.Itoa(segment.Sequence))).String(),
+		InitCacheKey: playlist.PlaylistId + "/" + uuid.NewSHA1(uuid.NameSpaceURL, []byte(playlist.PlaylistId+"/ad/"+ad.AssetId+"/"+ad.InitKey)).String(),
+	}, start
+}
+
+// holdsParts reports whether the parts of the segment at the index are to be held back from the players, since an ad
+// is going to replace the segment once it completes. It is decided with the first part of the segment, as the parts
+// players followed can not be taken back.
+func holdsParts(playlist *model.MediaPlaylist, index int, pod []*adPodSegment) bool {
+	adBreak, _, _ := adSlot(playlist, index, pod)
+	return adBreak != nil
+}
+
+// adSlot returns the ad break the segment at the index starts within and the position in the pod of the ad segment
+// which plays in its place, along with the time the segment starts at on the live timeline.
+// The break is nil while the segment stays live.
+func adSlot(playlist *model.MediaPlaylist, index int, pod []*adPodSegment) (*model.DateRange, int, time.Time) {
+	start := liveStart(playlist, index)
+	// Without an initialization section of its own, the live media could not be switched back to after the ads.
+	if start.IsZero() || playlist.InitCacheKey == "" {
+		return nil, 0, start
+	}
+	adBreak := adBreakAt(playlist, start)
+	if adBreak == nil {
+		return nil, 0, start
+	}
+	position, available := adPosition(playlist, index, adBreak)
+	if !available || position >= len(pod) {
+		return nil, 0, start
+	}
+	return adBreak, position, start
+}
+
+// spliceAd replaces a complete segment by the ad segment prepareAd stored for it, along with the parts it held back,
+// and returns the time to live of the media it no longer lists. The first ad segment of a break and the live segment which rejoins the live
+// timeline once the break ended or the pod ran out are marked as discontinuities and carry their program date time
+// on the live timeline. A segment stays live when the playlist changed since the ad was prepared.
+func spliceAd(playlist *model.MediaPlaylist, segment *model.Segment, pod []*adPodSegment, prepared *adSplice) map[string]time.Duration {
+	index := segmentIndex(playlist, segment)
+	if index < 0 {
+		return nil
+	}
+	splice, start := planAd(playlist, index, pod)
+
+	duration := playlist.Duration()
+	if splice == nil || prepared == nil || *splice != *prepared {
+		segment.Ad = nil
+		if index > 0 && playlist.Segments[index-1].Ad != nil {
+			segment.Discontinuity = true
+			segment.ProgramDateTime = start
+		}
+		if prepared == nil {
+			return nil
+		}
+		log.Printf("ad break of %s changed while segment %d was prepared, keeping it live", playlist.CacheKey, segment.Sequence)
+		return map[string]time.Duration{
+			playlist.PlaylistId + "/" + prepared.Id: gracePeriod(prepared.Ad.Segment.Duration, duration),
+		}
+	}
+
+	cacheKey := playlist.PlaylistId + "/" + splice.Id
+	expirations := map[string]time.Duration{}
+	if !segment.Gap && segment.CacheKey != cacheKey {
+		expirations[segment.CacheKey] = gracePeriod(segment.Duration, duration)
+	}
+	for _, part := range segment.Parts {
+		if !part.Gap {
+			expirations[part.CacheKey] = gracePeriod(part.Duration, duration)
+		}
+	}
+
+	segment.Ad = &model.AdSplice{
+		BreakId:      splice.BreakId,
+		AssetId:      splice.Ad.AssetId,
+		Position:     splice.Position,
+		LiveDuration: segment.Duration,
+	}
+	segment.Id = splice.Id
+	segment.CacheKey = cacheKey
+	segment.InitCacheKey = splice.InitCacheKey
+	segment.Duration = splice.Ad.Segment.Duration
+	segment.ProgramDateTime = start
+	segment.Discontinuity = segment.Discontinuity || splice.Position == 0
+	segment.Gap = false
+	segment.Parts = nil
+	return expirations
+}
+
+// segmentIndex returns the index of the segment in the playlist, or -1 when the playlist does not list it.
+func segmentIndex(playlist *model.MediaPlaylist, segment *model.Segment) int {
+	for index, listed := range playlist.Segments {
+		if listed == segment {
+			return index
+		}
+	}
+	return -1
+}
+
+// adPosition returns the position in the pod of the ad segment which replaces the segment at the index within the
+// ad break, continuing the ads of the previous segment. Once a segment of the break stayed on the live media, because
+// the pod ran out or its next segment did not fit, the pod of the break is exhausted and the break stays live.
+func adPosition(playlist *model.MediaPlaylist, index int, adBreak *model.DateRange) (int, bool) {
+	if index == 0 {
+		return 0, true
+	}
+	previous := playlist.Segments[index-1]
+	if previous.Ad != nil && previous.Ad.BreakId == adBreak.Id {
+		return previous.Ad.Position + 1, true
+	}
+	for _, earlier := range playlist.Segments[:index] {
+		if earlier.Ad != nil && earlier.Ad.BreakId == adBreak.Id {
+			return 0, false
+		}
+	}
+	// The break also stays live when its first segment did not fit, which left no ad of the break to find.
+	previousBreak := adBreakAt(playlist, liveStart(playlist, index-1))
+	return 0, previousBreak == nil || previousBreak.Id != adBreak.Id
+}
+
+// liveStart returns the program date time the segment at the index starts at on the live timeline, which ad segments
+// shorter or longer than the live segments they replaced no longer tell.
+func liveStart(playlist *model.MediaPlaylist, index int) time.Time {
+	if index < 0 {
+		return time.Time{}
+	}
+	if segment := playlist.Segments[index]; !segment.ProgramDateTime.IsZero() {
+		return segment.ProgramDateTime
+	}
+	starts, _ := playlist.ProgramDateTimes()
+	if previous := index - 1; previous >= 0 && playlist.Segments[previous].Ad != nil && !starts[previous].IsZero() {
+		return starts[previous].Add(model.Seconds(playlist.Segments[previous].Ad.LiveDuration))
+	}
+	return starts[index]
+}
+
+// adBreakAt returns the latest ad break which started by the given time and had not ended yet.
+// Breaks whose end is not known yet last until their planned duration is over, or until they end.
+func adBreakAt(playlist *model.MediaPlaylist, at time.Time) *model.DateRange {
+	drift := model.Seconds(maxAdDrift)
+	var adBreak *model.DateRange
+	for _, dateRange := range playlist.DateRanges {
+		if dateRange.SCTE35Out == "" || dateRange.StartDate.After(at.Add(drift)) {
+			continue
+		}
+		end := dateRange.End()
+		if end.IsZero() && dateRange.PlannedDuration > 0 {
+			end = dateRange.StartDate.Add(model.Seconds(dateRange.PlannedDuration))
+		}
+		if !end.IsZero() && !at.Add(drift).Before(end) {
+			continue
+		}
+		if adBreak == nil || dateRange.StartDate.After(adBreak.StartDate) {
+			adBreak = dateRange
+		}
+	}
+	return adBreak
+}
@@ -0,0 +1,340 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/ads"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/playlist"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/scte35"
+	"github.com/sehovizko/mobworx-streamer/src/internal/scte35/scte35test"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAdAsset writes the media of an ad asset of two 4 second segments per rendition to the directory.
+// Along with the muxed renditions, it carries an audio rendition for demuxed variants.
+func newTestAdAsset(t *testing.T, root string) *model.AdAsset {
+	asset := &model.AdAsset{Id: "spring"}
+	for _, rendition := range []struct {
+		Name      string
+		Codecs    string
+		Bandwidth int
+	}{
+		{Name: "720p", Codecs: "avc1.4dc00d,mp4a.40.2", Bandwidth: 2000},
+		{Name: "1080p", Codecs: "avc1.4dc00d,mp4a.40.2", Bandwidth: 6000},
+		{Name: "hevc", Codecs: "hvc1.1.6.L93.B0,mp4a.40.2", Bandwidth: 2048},
+		{Name: "audio", Codecs: "Opus", Bandwidth: 128},
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "spring", rendition.Name), 0o755))
+		initKey := "spring/" + rendition.Name + "/init.mp4"
+		require.NoError(t, os.WriteFile(filepath.Join(root, filepath.FromSlash(initKey)), testInit("ad "+rendition.Name), 0o644))
+		adRendition := &model.AdRendition{Codecs: rendition.Codecs, Bandwidth: rendition.Bandwidth, InitKey: initKey}
+		for _, name := range []string{"segment-0.m4s", "segment-1.m4s"} {
+			key := "spring/" + rendition.Name + "/" + name
+			require.NoError(t, os.WriteFile(filepath.Join(root, filepath.FromSlash(key)), testFragment("ad "+key), 0o644))
+			adRendition.Segments = append(adRendition.Segments, &model.AdSegment{Key: key, Duration: 4})
+		}
+		asset.Renditions = append(asset.Renditions, adRendition)
+	}
+	return asset
+}
+
+func TestUpdateSegmentSplicesAds(t *testing.T) {
+	ctx := context.Background()
+	ingester, server := newTestIngester(t)
+	ingester.Ads = ads.NewFileStore(t.TempDir())
+	repo := ingester.Repository
+	playlistId := uuid.MustParse(testPlaylistId)
+
+	uploadTimedSegment(t, ingester, 3)
+	require.NoError(t, ingester.RegisterAdAsset(ctx, playlistId, newTestAdAsset(t, ingester.Ads.(*ads.FileStore).Root)))
+
+	// The break starts with segment 4 and lasts two segments.
+	out := scte35test.SpliceInsert(7, true, 900000+360360, 8.008*scte35.Clock)
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		SCTE35: base64.StdEncoding.EncodeToString(out),
+	})))
+	var liveKeys []string
+	for sequence := 4; sequence <= 6; sequence++ {
+		liveKeys = append(liveKeys, uploadTimedSegment(t, ingester, sequence))
+		// The initialization section is read from the ad store once for all the segments of the asset.
+		require.NoError(t, os.RemoveAll(filepath.Join(ingester.Ads.(*ads.FileStore).Root, "spring", "720p", "init.mp4")))
+	}
+
+	media, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, media.Segments, 4)
+	start := time.Unix(1676898433, 0)
+	live, first, second, rejoin := media.Segments[0], media.Segments[1], media.Segments[2], media.Segments[3]
+
+	for position, ad := range []*model.Segment{first, second} {
+		require.NotNil(t, ad.Ad)
+		assert.Equal(t, &model.AdSplice{BreakId: "splice-7", AssetId: "spring", Position: position, LiveDuration: 4.004}, ad.Ad)
+		assert.Equal(t, 4.0, ad.Duration)
+		assert.NotEqual(t, live.InitCacheKey, ad.InitCacheKey)
+		data, err := repo.GetMedia(ctx, ad.CacheKey)
+		require.NoError(t, err)
+		assert.Equal(t, testFragment("ad spring/720p/segment-"+[]string{"0", "1"}[position]+".m4s"), data)
+	}
+	assert.True(t, first.Discontinuity)
+	assert.False(t, second.Discontinuity)
+	assert.True(t, start.Add(4004*time.Millisecond).Equal(first.ProgramDateTime))
+	assert.True(t, start.Add(8008*time.Millisecond).Equal(second.ProgramDateTime))
+	init, err := repo.GetMedia(ctx, first.InitCacheKey)
+	require.NoError(t, err)
+	assert.Equal(t, testInit("ad 720p"), init)
+	assert.Equal(t, repository.StreamTTL, server.TTL(first.InitCacheKey))
+	assert.Equal(t, repository.StreamTTL, server.TTL("adassets:"+testPlaylistId))
+
+	// The live timeline rejoins where the break ends, and the media of the replaced segments expires.
+	assert.Nil(t, rejoin.Ad)
+	assert.True(t, rejoin.Discontinuity)
+	assert.True(t, start.Add(12012*time.Millisecond).Equal(rejoin.ProgramDateTime))
+	assert.Equal(t, live.InitCacheKey, rejoin.InitCacheKey)
+	assert.Positive(t, server.TTL(liveKeys[0]))
+	assert.Positive(t, server.TTL(liveKeys[1]))
+	assert.Zero(t, server.TTL(liveKeys[2]))
+
+	rendered := playlist.NewMedia(media).String()
+	assert.Equal(t, 3, strings.Count(rendered, "#EXT-X-MAP:"))
+	assert.Equal(t, 2, strings.Count(rendered, "#EXT-X-DISCONTINUITY\n"))
+}
+
+func TestUpdateSegmentStaysLiveOnceThePodRunsOut(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	ingester.Ads = ads.NewFileStore(t.TempDir())
+	playlistId := uuid.MustParse(testPlaylistId)
+
+	uploadTimedSegment(t, ingester, 3)
+	require.NoError(t, ingester.RegisterAdAsset(ctx, playlistId, newTestAdAsset(t, ingester.Ads.(*ads.FileStore).Root)))
+
+	// The break starts with segment 4 and lasts four segments, twice as long as the pod.
+	out := scte35test.SpliceInsert(7, true, 900000+360360, 16.016*scte35.Clock)
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		SCTE35: base64.StdEncoding.EncodeToString(out),
+	})))
+	for sequence := 4; sequence <= 8; sequence++ {
+		uploadTimedSegment(t, ingester, sequence)
+	}
+
+	media, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	var positions []int
+	var discontinuities []int
+	for _, segment := range media.Segments {
+		if segment.Ad != nil {
+			positions = append(positions, segment.Ad.Position)
+		}
+		if segment.Discontinuity {
+			discontinuities = append(discontinuities, segment.Sequence)
+		}
+	}
+	// The rest of the break stays on the live media instead of restarting the pod.
+	assert.Equal(t, []int{0, 1}, positions)
+	assert.Equal(t, []int{4, 6}, discontinuities)
+	for _, segment := range media.Segments[3:] {
+		assert.Nil(t, segment.Ad, segment.Sequence)
+		assert.Equal(t, media.Segments[0].InitCacheKey, segment.InitCacheKey, segment.Sequence)
+	}
+}
+
+func TestUpdatePartHoldsBackPartsOfAdBreaks(t *testing.T) {
+	ctx := context.Background()
+	ingester, server := newTestIngester(t)
+	ingester.Ads = ads.NewFileStore(t.TempDir())
+	playlistId := uuid.MustParse(testPlaylistId)
+
+	uploadTimedSegment(t, ingester, 3)
+	require.NoError(t, ingester.RegisterAdAsset(ctx, playlistId, newTestAdAsset(t, ingester.Ads.(*ads.FileStore).Root)))
+	out := scte35test.SpliceInsert(7, true, 900000+360360, 8.008*scte35.Clock)
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		SCTE35: base64.StdEncoding.EncodeToString(out),
+	})))
+
+	// Segment 4 starts the break, so players must not follow its live parts.
+	part := newTestPartMessage(t, uuid.NewString(), 0, false, false)
+	part.Payload.Segment.Sequence = 4
+	part.Payload.Segment.ProgramDateTime = helpers.Timestamp{}
+	part.Payload.Part.Data = timedFragment(900000+360360, 360360)
+	part.Payload.Part.CacheKey = testPlaylistId + "/" + part.Payload.Part.Id.String()
+	require.NoError(t, ingester.UpdatePart(ctx, part))
+
+	media, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, media.Segments, 2)
+	assert.True(t, media.Segments[1].Held)
+	assert.Len(t, media.Segments[1].Parts, 1)
+	rendered := playlist.NewMedia(media).String()
+	assert.NotContains(t, rendered, playlist.PartURI(4, 0))
+	assert.NotContains(t, rendered, "#EXT-X-PRELOAD-HINT")
+	assert.Equal(t, &model.RenditionReport{CacheKey: media.CacheKey, LastMsn: 3, LastPart: -1}, media.RenditionReport())
+
+	uploadTimedSegment(t, ingester, 4)
+	uploadTimedSegment(t, ingester, 5)
+
+	media, err = ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, media.Segments, 3)
+	for position, ad := range media.Segments[1:] {
+		require.NotNil(t, ad.Ad, ad.Sequence)
+		assert.Equal(t, position, ad.Ad.Position)
+		assert.False(t, ad.Held)
+		assert.Empty(t, ad.Parts)
+	}
+	// The held part expires along with the live segment it belonged to.
+	assert.Positive(t, server.TTL(part.Payload.Part.CacheKey))
+}
+
+func TestUpdateSegmentKeepsPublishedPartsLive(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	ingester.Ads = ads.NewFileStore(t.TempDir())
+	playlistId := uuid.MustParse(testPlaylistId)
+
+	uploadTimedSegment(t, ingester, 3)
+	require.NoError(t, ingester.RegisterAdAsset(ctx, playlistId, newTestAdAsset(t, ingester.Ads.(*ads.FileStore).Root)))
+
+	// Players following the parts of segment 4 already play its live media when the break is announced.
+	part := newTestPartMessage(t, uuid.NewString(), 0, false, false)
+	part.Payload.Segment.Sequence = 4
+	part.Payload.Segment.ProgramDateTime = helpers.Timestamp{}
+	part.Payload.Part.Data = timedFragment(900000+360360, 360360)
+	require.NoError(t, ingester.UpdatePart(ctx, part))
+	out := scte35test.SpliceInsert(7, true, 900000+360360, 8.008*scte35.Clock)
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		SCTE35: base64.StdEncoding.EncodeToString(out),
+	})))
+	uploadTimedSegment(t, ingester, 4)
+	uploadTimedSegment(t, ingester, 5)
+
+	media, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, media.Segments, 3)
+	assert.Len(t, media.Segments[1].Parts, 1)
+	for _, segment := range media.Segments {
+		assert.Nil(t, segment.Ad, segment.Sequence)
+		assert.False(t, segment.Held, segment.Sequence)
+		assert.Equal(t, media.Segments[0].InitCacheKey, segment.InitCacheKey, segment.Sequence)
+	}
+}
+
+// uploadTimedDemuxSegment uploads a 4.004 second segment of the test variant and of its audio rendition,
+// counting from segment 3, and returns the cache key of the audio segment.
+func uploadTimedDemuxSegment(t *testing.T, ingester *Ingester, sequence int) string {
+	message := newTestDemuxPartMessage(t, 0)
+	message.Action = signals.DataActionUpdateDemuxSegment
+	payload := message.Payload
+	payload.Part, payload.AudioPart = nil, nil
+	payload.Segment.Id = uuid.New()
+	payload.Segment.CacheKey = testPlaylistId + "/" + payload.Segment.Id.String()
+	payload.Segment.Sequence, payload.AudioSegment.Sequence = sequence, sequence
+	payload.Segment.Duration, payload.AudioSegment.Duration = 4.004, 4.004
+	payload.Segment.Discontinuity = false
+	payload.Segment.Data = timedFragment(900000+uint64(sequence-3)*360360, 360360)
+	payload.AudioSegment.Data = base64.StdEncoding.EncodeToString(isobmfftest.Fragment{
+		Sequence:            1,
+		TrackId:             2,
+		BaseMediaDecodeTime: uint64(sequence-3) * 192192,
+		Samples:             []isobmff.Sample{{Duration: 192192, Size: 1}},
+		Data:                []byte{0},
+	}.Bytes())
+	if sequence == 3 {
+		payload.Variant.InitCacheKey = testPlaylistId + "/" + testMapId
+		payload.AudioSegment.ProgramDateTime = payload.Segment.ProgramDateTime
+	} else {
+		payload.Segment.Map, payload.AudioSegment.Map = nil, nil
+		payload.Segment.ProgramDateTime = helpers.Timestamp{}
+	}
+	require.NoError(t, ingester.UpdateDemuxSegment(context.Background(), message))
+	return payload.AudioSegment.CacheKey
+}
+
+func TestUpdateDemuxSegmentSplicesAudio(t *testing.T) {
+	ctx := context.Background()
+	ingester, server := newTestIngester(t)
+	ingester.Ads = ads.NewFileStore(t.TempDir())
+	repo := ingester.Repository
+	playlistId := uuid.MustParse(testPlaylistId)
+
+	uploadTimedDemuxSegment(t, ingester, 3)
+	require.NoError(t, ingester.RegisterAdAsset(ctx, playlistId, newTestAdAsset(t, ingester.Ads.(*ads.FileStore).Root)))
+	out := scte35test.SpliceInsert(7, true, 900000+360360, 8.008*scte35.Clock)
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		SCTE35: base64.StdEncoding.EncodeToString(out),
+	})))
+	var liveKeys []string
+	for sequence := 4; sequence <= 6; sequence++ {
+		liveKeys = append(liveKeys, uploadTimedDemuxSegment(t, ingester, sequence))
+	}
+
+	audio, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testAudioRenditionId)
+	require.NoError(t, err)
+	require.Len(t, audio.Segments, 4)
+	for position, ad := range audio.Segments[1:3] {
+		require.NotNil(t, ad.Ad, ad.Sequence)
+		assert.Equal(t, &model.AdSplice{BreakId: "splice-7", AssetId: "spring", Position: position, LiveDuration: 4.004}, ad.Ad)
+		data, err := repo.GetMedia(ctx, ad.CacheKey)
+		require.NoError(t, err)
+		assert.Equal(t, testFragment("ad spring/audio/segment-"+[]string{"0", "1"}[position]+".m4s"), data)
+		init, err := repo.GetMedia(ctx, ad.InitCacheKey)
+		require.NoError(t, err)
+		assert.Equal(t, testInit("ad audio"), init)
+	}
+	rejoin := audio.Segments[3]
+	assert.Nil(t, rejoin.Ad)
+	assert.True(t, rejoin.Discontinuity)
+	assert.Equal(t, testPlaylistId+"/"+testAudioMapId, rejoin.InitCacheKey)
+	assert.Positive(t, server.TTL(liveKeys[0]))
+	assert.Zero(t, server.TTL(liveKeys[2]))
+}
+
+func TestRegisterAdAssetRejectsInvalidAssets(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	playlistId := uuid.MustParse(testPlaylistId)
+	uploadTimedSegment(t, ingester, 3)
+
+	root := t.TempDir()
+	asset := newTestAdAsset(t, root)
+	assert.ErrorIs(t, ingester.RegisterAdAsset(ctx, playlistId, asset), ErrNoAdStore)
+	ingester.Ads = ads.NewFileStore(root)
+
+	missing := newTestAdAsset(t, root)
+	missing.Renditions[0].Segments[1].Key = "spring/720p/segment-2.m4s"
+	assert.ErrorIs(t, ingester.RegisterAdAsset(ctx, playlistId, missing), ads.ErrMediaNotFound)
+
+	cases := map[string]func(asset *model.AdAsset){
+		"id":         func(asset *model.AdAsset) { asset.Id = "spring/summer" },
+		"renditions": func(asset *model.AdAsset) { asset.Renditions = nil },
+		"codecs":     func(asset *model.AdAsset) { asset.Renditions[0].Codecs = " " },
+		"bandwidth":  func(asset *model.AdAsset) { asset.Renditions[0].Bandwidth = 0 },
+		"segments":   func(asset *model.AdAsset) { asset.Renditions[0].Segments = nil },
+		"duration":   func(asset *model.AdAsset) { asset.Renditions[0].Segments[0].Duration = 0 },
+	}
+	for name, invalidate := range cases {
+		t.Run(name, func(t *testing.T) {
+			invalid := newTestAdAsset(t, root)
+			invalidate(invalid)
+			assert.ErrorIs(t, ingester.RegisterAdAsset(ctx, playlistId, invalid), ErrInvalidAdAsset)
+		})
+	}
+
+	require.NoError(t, ingester.RegisterAdAsset(ctx, playlistId, asset))
+	require.NoError(t, ingester.RemoveAdAsset(ctx, playlistId, "spring"))
+	assets, err := ingester.Repository.GetAdAssets(ctx, testPlaylistId)
+	require.NoError(t, err)
+	assert.Empty(t, assets)
+}
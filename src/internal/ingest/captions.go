@@ -0,0 +1,97 @@
+package ingest
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/captions"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"strings"
+)
+
+// closedCaptionsGroup names the captions group a master playlist registers detected captions under,
+// unless the variant declares a group of its own.
+const closedCaptionsGroup = "closed-captions"
+
+// detectCaptions scans the SEI of the H.264 or HEVC video track of the media for CEA-608 and CEA-708 captions.
+// It returns the INSTREAM-IDs the playlist did not carry so far, which the playlist remembers from then on.
+func detectCaptions(playlist *model.MediaPlaylist, media *isobmff.Media) []string {
+	track := referenceTrack(playlist)
+	if media == nil || track == nil || track.HandlerType != "vide" || track.NALLengthSize == 0 {
+		return nil
+	}
+
+	hevc := strings.HasPrefix(track.Codec, "hvc1") || strings.HasPrefix(track.Codec, "hev1")
+	detector := captions.NewDetector(track.NALLengthSize, hevc)
+	for _, fragment := range media.Fragments {
+		for _, trackFragment := range fragment.Tracks {
+			if trackFragment.TrackId != track.Id {
+				continue
+			}
+			for _, sample := range fragment.SampleData(trackFragment, track.DefaultSampleSize) {
+				detector.Scan(sample)
+			}
+		}
+	}
+
+	var detected []string
+	for _, instreamId := range detector.InstreamIds() {
+		if !contains(playlist.ClosedCaptions, instreamId) {
+			detected = append(detected, instreamId)
+			playlist.ClosedCaptions = append(playlist.ClosedCaptions, instreamId)
+		}
+	}
+	return detected
+}
+
+// registerCaptions adds a CLOSED-CAPTIONS rendition per detected INSTREAM-ID to the captions group of the variant
+// of the payload. Variants which declare no group join the one their master playlist keeps for detected captions.
+func (i *Ingester) registerCaptions(ctx context.Context, payload *signals.DataGeneralShapePayload, instreamIds []string) error {
+	playlistId := payload.Playlist.Id
+	return i.updateMultivariantPlaylist(ctx, playlistId.String(), func(playlist *model.MultivariantPlaylist) (bool, error) {
+		variant := playlist.Variant(payload.Variant.Id.String())
+		if variant == nil {
+			return false, nil
+		}
+
+		changed := false
+		if variant.ClosedCaptions == "" {
+			variant.ClosedCaptions = uuid.NewSHA1(playlistId, []byte(closedCaptionsGroup)).String()
+			changed = true
+		}
+		for _, instreamId := range instreamIds {
+			if captionsRendition(playlist, variant.ClosedCaptions, instreamId) != nil {
+				continue
+			}
+			playlist.Renditions = append(playlist.Renditions, &model.Rendition{
+				Id:         uuid.NewSHA1(playlistId, []byte(variant.ClosedCaptions+"/"+instreamId)).String(),
+				Type:       string(signals.DataRenditionTypeClosedCaptions),
+				GroupId:    variant.ClosedCaptions,
+				Name:       instreamId,
+				AutoSelect: true,
+				InstreamId: instreamId,
+			})
+			changed = true
+		}
+		return changed, nil
+	})
+}
+
+func captionsRendition(playlist *model.MultivariantPlaylist, groupId string, instreamId string) *model.Rendition {
+	for _, rendition := range playlist.Renditions {
+		if rendition.GroupId == groupId && rendition.InstreamId == instreamId {
+			return rendition
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, listed := range values {
+		if listed == value {
+			return true
+		}
+	}
+	return false
+}
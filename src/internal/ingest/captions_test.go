@@ -0,0 +1,53 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/captions/captionstest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestUpdatePartDetectsCaptions(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	repo := ingester.Repository
+
+	upload := func(sequence int, sample []byte) {
+		message := newTestPartMessage(t, uuid.NewString(), sequence, false, sequence == 0)
+		if sequence == 0 {
+			message = withTestInit(message, testVideoTrack)
+		}
+		message.Payload.Variant.Codecs = ""
+		message.Payload.Part.Data = base64.StdEncoding.EncodeToString(isobmfftest.Fragment{Sequence: uint32(sequence + 1), TrackId: 1, Data: sample}.Bytes())
+		require.NoError(t, ingester.UpdatePart(ctx, message))
+	}
+
+	upload(0, captionstest.Sample(false, [3]byte{0xfc, 0x94, 0x2c}))
+	upload(1, captionstest.Sample(false, [3]byte{0xfc, 0x94, 0x20}, [3]byte{0xff, 0x02, 0x21}, [3]byte{0xfe, 0x41, 0x00}))
+	upload(2, []byte("no captions"))
+
+	media, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"CC1", "SERVICE1"}, media.ClosedCaptions)
+
+	// A version bump of the variant keeps the captions found in its media.
+	require.NoError(t, ingester.UpdateVariant(ctx, newTestVariantMessage(t, 2, "", 2048, 4)))
+
+	playlist, err := repo.GetMultivariantPlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	group := uuid.NewSHA1(uuid.MustParse(testPlaylistId), []byte(closedCaptionsGroup)).String()
+	assert.Equal(t, group, playlist.Variant(testVariantId).ClosedCaptions)
+	require.Len(t, playlist.Renditions, 2)
+	for i, instreamId := range []string{"CC1", "SERVICE1"} {
+		rendition := playlist.Renditions[i]
+		assert.Equal(t, "CLOSED-CAPTIONS", rendition.Type)
+		assert.Equal(t, group, rendition.GroupId)
+		assert.Equal(t, instreamId, rendition.InstreamId)
+		assert.Equal(t, instreamId, rendition.Name)
+		assert.Empty(t, rendition.CacheKey)
+	}
+}
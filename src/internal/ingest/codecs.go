@@ -0,0 +1,54 @@
+package ingest
+
+import (
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"strings"
+)
+
+var ErrCodecsMismatch = fmt.Errorf("%d: declared codecs do not match the initialization section", 400)
+
+// applyCodecs fills the codecs the publisher left empty with those of the initialization section and
+// rejects declared codecs which lack one of them. Declared codecs may list more, as a variant also lists
+// the codecs of the renditions it is played along with.
+func applyCodecs(payload *signals.DataGeneralShapePayload, tracks []*model.Track) error {
+	var declared *string
+	switch {
+	case payload.Variant != nil:
+		declared = &payload.Variant.Codecs
+	case payload.Rendition != nil:
+		declared = &payload.Rendition.Codecs
+	default:
+		return nil
+	}
+
+	var carried []string
+	for _, track := range tracks {
+		if track.Codec != "" && !containsCodec(carried, track.Codec) {
+			carried = append(carried, track.Codec)
+		}
+	}
+
+	if strings.TrimSpace(*declared) == "" {
+		*declared = strings.Join(carried, ",")
+		return nil
+	}
+
+	listed := strings.Split(normalizeCodecs(*declared), ",")
+	for _, codec := range carried {
+		if !containsCodec(listed, codec) {
+			return fmt.Errorf("%w: %q declared, %q carried", ErrCodecsMismatch, *declared, strings.Join(carried, ","))
+		}
+	}
+	return nil
+}
+
+func containsCodec(codecs []string, codec string) bool {
+	for _, listed := range codecs {
+		if strings.EqualFold(listed, codec) {
+			return true
+		}
+	}
+	return false
+}
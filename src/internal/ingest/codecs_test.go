@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var (
+	testVideoTrack = isobmfftest.Track{
+		Id:          1,
+		Timescale:   90000,
+		HandlerType: "vide",
+		SampleEntry: isobmfftest.VisualSampleEntry("avc1", 1280, 720, isobmfftest.Box("avcC", []byte{1, 0x64, 0x00, 0x1f, 0xff})),
+	}
+	testAudioTrack = isobmfftest.Track{
+		Id:          2,
+		Timescale:   48000,
+		HandlerType: "soun",
+		SampleEntry: isobmfftest.AudioSampleEntry("Opus", 2, 48000),
+	}
+)
+
+func withTestInit(message *signals.DataGeneralShape, tracks ...isobmfftest.Track) *signals.DataGeneralShape {
+	message.Payload.Segment.Map.Data = base64.StdEncoding.EncodeToString(isobmfftest.Init(tracks...))
+	return message
+}
+
+func TestUpdateVariantDerivesCodecs(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	// Registered without an initialization section first, the codecs are filled in once it arrives.
+	message := newTestVariantMessage(t, 1, "", 2048, 4)
+	message.Payload.Segment.Map = nil
+	require.NoError(t, ingester.UpdateVariant(ctx, message))
+	message = withTestInit(newTestVariantMessage(t, 1, "", 2048, 4), testVideoTrack, testAudioTrack)
+	require.NoError(t, ingester.UpdateVariant(ctx, message))
+	assert.Equal(t, "avc1.64001f,Opus", message.Payload.Variant.Codecs)
+
+	multivariant, err := ingester.Repository.GetMultivariantPlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	assert.Equal(t, "avc1.64001f,Opus", multivariant.Variant(testVariantId).Codecs)
+
+	media, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, media.Tracks, 2)
+	assert.Equal(t, "avc1.64001f", media.Tracks[0].Codec)
+	assert.Equal(t, uint32(48000), media.Track(2).Timescale)
+}
+
+func TestUpdateVariantChecksDeclaredCodecs(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	message := withTestInit(newTestVariantMessage(t, 1, "avc1.4dc00d,mp4a.40.2", 2048, 4), testVideoTrack)
+	assert.ErrorIs(t, ingester.UpdateVariant(ctx, message), ErrCodecsMismatch)
+
+	// A variant lists the codecs of its audio renditions along with its own.
+	message = withTestInit(newTestVariantMessage(t, 1, "avc1.64001F, mp4a.40.2", 2048, 4), testVideoTrack)
+	require.NoError(t, ingester.UpdateVariant(ctx, message))
+}
+
+func TestUpdateRenditionDerivesCodecs(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	renditionId := "d02288ec-b11f-11ed-afa1-0242ac120002"
+	message := newTestRenditionMessage(t, 1, renditionId, signals.DataRenditionTypeAudio, testGroupId, true)
+	require.NoError(t, ingester.UpdateRendition(ctx, withTestInit(message, testAudioTrack)))
+
+	multivariant, err := ingester.Repository.GetMultivariantPlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	assert.Equal(t, "Opus", multivariant.Rendition(renditionId).Codecs)
+}
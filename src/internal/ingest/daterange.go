@@ -0,0 +1,228 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/scte35"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidDateRange = fmt.Errorf("%d: invalid date range", 400)
+
+var (
+	// clientAttribute matches the names of client defined date range attributes.
+	clientAttribute = regexp.MustCompile(`^X-[A-Z0-9-]+$`)
+	hexSequence     = regexp.MustCompile(`^0[xX][0-9A-Fa-f]+$`)
+)
+
+// UpdateDateRange adds the date range of an updateDateRange message to the media playlist of every variant and
+// rendition of its master playlist. SCTE-35 splice_info_sections are mapped the way the HLS specification maps them:
+// a splice_insert out of the network, or a time_signal starting a break, opens an ad break with SCTE35-OUT at its
+// splice time, and the matching return closes the break under the same id with SCTE35-IN. Other commands are carried
+// by SCTE35-CMD, and cancelled events are removed. The media playlists list the breaks as EXT-X-CUE-OUT and
+// EXT-X-CUE-IN as well, for players which do not read date ranges.
+func (i *Ingester) UpdateDateRange(ctx context.Context, message *signals.DataGeneralShape) error {
+	payload := message.Payload
+	if payload == nil || payload.Playlist == nil {
+		return ErrNoMediaPlaylist
+	}
+	if payload.DateRange == nil {
+		return fmt.Errorf("%w: payload carries no date range", ErrInvalidDateRange)
+	}
+
+	multivariant, err := i.Repository.GetMultivariantPlaylist(ctx, payload.Playlist.Id.String())
+	if err != nil {
+		return err
+	}
+	video, err := i.timedVideo(ctx, multivariant.Variants)
+	if err != nil {
+		return err
+	}
+	dateRange, cancel, err := dateRangeOf(payload.Playlist.Id, payload.DateRange, video)
+	if err != nil {
+		return err
+	}
+
+	return i.spreadDateRange(ctx, multivariant, dateRange, cancel)
+}
+
+// spreadDateRange adds the date range to the media playlist of every variant and rendition of the master playlist
+// which has one, or removes it from them.
+func (i *Ingester) spreadDateRange(ctx context.Context, multivariant *model.MultivariantPlaylist, dateRange *model.DateRange, remove bool) error {
+	for _, cacheKey := range multivariant.MediaCacheKeys() {
+		err := i.updateStoredMediaPlaylist(ctx, cacheKey, func(playlist *model.MediaPlaylist) error {
+			if remove {
+				playlist.RemoveDateRange(dateRange.Id)
+				return nil
+			}
+			upserted := *dateRange
+			playlist.UpsertDateRange(&upserted)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dateRangeOf validates the date range of a message and reports whether it cancels a splice event instead.
+// Date ranges without a start date start at the splice time of their section or at the live edge of the video.
+func dateRangeOf(playlistId uuid.UUID, signal *signals.DataGeneralShapePayloadDateRange, video *model.MediaPlaylist) (*model.DateRange, bool, error) {
+	dateRange := &model.DateRange{
+		Id:              signal.Id,
+		Class:           signal.Class,
+		StartDate:       signal.StartDate.Time,
+		EndDate:         signal.EndDate.Time,
+		Duration:        signal.Duration,
+		PlannedDuration: signal.PlannedDuration,
+	}
+
+	for name, value := range signal.Attributes {
+		switch {
+		case !clientAttribute.MatchString(name):
+			return nil, false, fmt.Errorf("%w: attribute %q is no client attribute", ErrInvalidDateRange, name)
+		case hexSequence.MatchString(value):
+		case strings.ContainsAny(value, "\"\r\n"):
+			return nil, false, fmt.Errorf("%w: attribute %s has the value %q", ErrInvalidDateRange, name, value)
+		default:
+			value = "\"" + value + "\""
+		}
+		if dateRange.Attributes == nil {
+			dateRange.Attributes = map[string]string{}
+		}
+		dateRange.Attributes[name] = value
+	}
+
+	cancel := false
+	if signal.SCTE35 != "" {
+		section, err := base64.StdEncoding.DecodeString(signal.SCTE35)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: SCTE-35 section is not valid base64", ErrInvalidDateRange)
+		}
+		info, err := scte35.Parse(section)
+		if err != nil {
+			return nil, false, err
+		}
+		cancel = applySpliceInfo(dateRange, info, section, video)
+		if dateRange.Id == "" {
+			dateRange.Id = uuid.NewSHA1(playlistId, section).String()
+		}
+	}
+
+	if dateRange.StartDate.IsZero() {
+		dateRange.StartDate = liveEdge(video)
+	}
+	switch {
+	case dateRange.Id == "" || strings.ContainsAny(dateRange.Id+dateRange.Class, "\"\r\n"):
+		return nil, false, fmt.Errorf("%w: id %q and class %q", ErrInvalidDateRange, dateRange.Id, dateRange.Class)
+	case strings.HasPrefix(dateRange.Id, interstitialDateRangePrefix):
+		return nil, false, fmt.Errorf("%w: id %q is reserved for interstitials", ErrInvalidDateRange, dateRange.Id)
+	case dateRange.Duration < 0 || dateRange.PlannedDuration < 0:
+		return nil, false, fmt.Errorf("%w: negative duration", ErrInvalidDateRange)
+	case !dateRange.EndDate.IsZero() && dateRange.EndDate.Before(dateRange.StartDate):
+		return nil, false, fmt.Errorf("%w: ends before it starts", ErrInvalidDateRange)
+	}
+	return dateRange, cancel, nil
+}
+
+// applySpliceInfo carries the splice_info_section over to the date range, which takes the splice event id of the
+// section unless the message names it, and reports whether the section cancels its splice event.
+func applySpliceInfo(dateRange *model.DateRange, info *scte35.SpliceInfo, section []byte, video *model.MediaPlaylist) bool {
+	sequence := "0x" + strings.ToUpper(hex.EncodeToString(section))
+	at := dateRange.StartDate
+	if at.IsZero() && info.HasPTS && !info.Immediate {
+		at, _ = programDateTimeOf(video, info.PTS)
+	}
+	if at.IsZero() {
+		at = liveEdge(video)
+	}
+
+	eventId, cancel := "", false
+	switch {
+	case info.Command == scte35.CommandSpliceInsert:
+		eventId, cancel = strconv.FormatUint(uint64(info.EventId), 10), info.Cancel
+		if info.OutOfNetwork {
+			dateRange.SCTE35Out = sequence
+			if info.HasBreakDuration {
+				dateRange.PlannedDuration = float64(info.BreakDuration) / scte35.Clock
+			}
+		} else {
+			dateRange.SCTE35In = sequence
+			dateRange.EndDate = at
+		}
+	case info.Command == scte35.CommandTimeSignal && breakSegmentation(info) != nil:
+		segmentation := breakSegmentation(info)
+		eventId, cancel = strconv.FormatUint(uint64(segmentation.EventId), 10), segmentation.Cancel
+		if segmentation.BreakStart() {
+			dateRange.SCTE35Out = sequence
+			if segmentation.HasDuration {
+				dateRange.PlannedDuration = float64(segmentation.Duration) / scte35.Clock
+			}
+		} else {
+			dateRange.SCTE35In = sequence
+			dateRange.EndDate = at
+		}
+	default:
+		dateRange.SCTE35Cmd = sequence
+	}
+
+	if dateRange.Id == "" && eventId != "" {
+		dateRange.Id = "splice-" + eventId
+	}
+	dateRange.StartDate = at
+	return cancel
+}
+
+// breakSegmentation returns the first segmentation_descriptor of the section which starts or ends a break.
+func breakSegmentation(info *scte35.SpliceInfo) *scte35.Segmentation {
+	for _, segmentation := range info.Segmentations {
+		if segmentation.Cancel || segmentation.BreakStart() || segmentation.BreakEnd() {
+			return segmentation
+		}
+	}
+	return nil
+}
+
+// programDateTimeOf places a PTS of the video on its program date time, measured from the latest segment starting
+// by then whose decode time is known. Presentation and decode times are taken as equal, composition offsets aside.
+// It reports false when no segment of the video has both times.
+func programDateTimeOf(video *model.MediaPlaylist, pts uint64) (time.Time, bool) {
+	if video == nil {
+		return time.Time{}, false
+	}
+
+	starts, _ := video.ProgramDateTimes()
+	timescale := float64(referenceTrack(video).Timescale)
+	var earliest time.Time
+	for index := len(video.Segments) - 1; index >= 0; index-- {
+		segment := video.Segments[index]
+		if starts[index].IsZero() || segment.Gap || (segment.DecodeTime == 0 && index > 0) {
+			continue
+		}
+		offset := wrapMpegTs(float64(pts)/scte35.Clock - float64(segment.DecodeTime)/timescale)
+		earliest = starts[index].Add(model.Seconds(offset))
+		if offset >= 0 {
+			break
+		}
+	}
+	return earliest, !earliest.IsZero()
+}
+
+// liveEdge returns the program date time the video ends at, or the current time while it has none.
+func liveEdge(video *model.MediaPlaylist) time.Time {
+	if video != nil {
+		if _, edge := video.ProgramDateTimes(); !edge.IsZero() {
+			return edge
+		}
+	}
+	return time.Now()
+}
@@ -0,0 +1,179 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/scte35"
+	"github.com/sehovizko/mobworx-streamer/src/internal/scte35/scte35test"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDateRangeMessage(dateRange *signals.DataGeneralShapePayloadDateRange) *signals.DataGeneralShape {
+	return &signals.DataGeneralShape{
+		Action: signals.DataActionUpdateDateRange,
+		Payload: &signals.DataGeneralShapePayload{
+			Playlist:  &signals.DataGeneralShapePayloadPlaylist{Id: uuid.MustParse(testPlaylistId)},
+			DateRange: dateRange,
+		},
+	}
+}
+
+// uploadTimedSegment uploads a video segment of 4.004 seconds decoding from 10 seconds on at sequence 3,
+// of which only the first one carries its program date time, and returns the cache key of its media.
+func uploadTimedSegment(t *testing.T, ingester *Ingester, sequence int) string {
+	message := newTestSegmentMessage(t, sequence, nil)
+	message.Payload.Segment.Discontinuity = false
+	message.Payload.Segment.Data = timedFragment(900000+uint64(sequence-3)*360360, 360360)
+	if sequence == 3 {
+		message.Payload.Segment.Map = &signals.MediaInitializationSection{
+			Id:   uuid.MustParse(testMapId),
+			Data: base64.StdEncoding.EncodeToString(testInit("init")),
+		}
+		message.Payload.Variant.InitCacheKey = testPlaylistId + "/" + testMapId
+	} else {
+		message.Payload.Segment.ProgramDateTime = helpers.Timestamp{}
+	}
+	require.NoError(t, ingester.UpdateSegment(context.Background(), message))
+	return message.Payload.Segment.CacheKey
+}
+
+func hexSequenceOf(section []byte) string {
+	return "0x" + strings.ToUpper(hex.EncodeToString(section))
+}
+
+func TestUpdateDateRangeSplicesEveryPlaylist(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	repo := ingester.Repository
+
+	start := time.Unix(1676898433, 0)
+	uploadTimedSegment(t, ingester, 3)
+	uploadTimedSegment(t, ingester, 4)
+	vtt := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:03.000\nHello\n"
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSubtitlesMessage(t, 3, vtt)))
+
+	// The break starts 5 seconds into the video and is planned to last 30 seconds, but returns after 9.
+	out := scte35test.SpliceInsert(7, true, 900000+450000, 30*scte35.Clock)
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		SCTE35: base64.StdEncoding.EncodeToString(out),
+	})))
+	in := scte35test.SpliceInsert(7, false, 900000+810000, 0)
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		SCTE35: base64.StdEncoding.EncodeToString(in),
+	})))
+
+	// Plain metadata starts at the live edge unless it names its start.
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		Id:         "chapter-2",
+		Class:      "com.example.chapter",
+		Duration:   60,
+		Attributes: map[string]string{"X-TITLE": "Second half", "X-MARKER": "0x2A"},
+	})))
+
+	for _, cacheKey := range []string{testPlaylistId + "/" + testVariantId, testPlaylistId + "/" + testSubtitlesRenditionId} {
+		playlist, err := repo.GetMediaPlaylist(ctx, cacheKey)
+		require.NoError(t, err)
+		require.Len(t, playlist.DateRanges, 2, cacheKey)
+
+		splice := playlist.DateRange("splice-7")
+		require.NotNil(t, splice)
+		assert.True(t, start.Add(5*time.Second).Equal(splice.StartDate), splice.StartDate)
+		assert.True(t, start.Add(9*time.Second).Equal(splice.EndDate), splice.EndDate)
+		assert.Equal(t, 30.0, splice.PlannedDuration)
+		assert.Equal(t, hexSequenceOf(out), splice.SCTE35Out)
+		assert.Equal(t, hexSequenceOf(in), splice.SCTE35In)
+
+		chapter := playlist.DateRange("chapter-2")
+		require.NotNil(t, chapter)
+		assert.True(t, start.Add(8008*time.Millisecond).Equal(chapter.StartDate), chapter.StartDate)
+		assert.Equal(t, map[string]string{"X-TITLE": "\"Second half\"", "X-MARKER": "0x2A"}, chapter.Attributes)
+	}
+
+	// Time signals open a break with the segmentation event id.
+	opportunity := scte35test.TimeSignal(900000, 9, 0x34, 0)
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		SCTE35: base64.StdEncoding.EncodeToString(opportunity),
+	})))
+	playlist, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.NotNil(t, playlist.DateRange("splice-9"))
+	assert.Equal(t, hexSequenceOf(opportunity), playlist.DateRange("splice-9").SCTE35Out)
+	assert.True(t, start.Equal(playlist.DateRange("splice-9").StartDate))
+}
+
+func TestUpdateDateRangeExpiresWithWindow(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	ingester.Window = model.Window{Segments: 3}
+
+	uploadTimedSegment(t, ingester, 3)
+	out := scte35test.TimeSignal(900000+90000, 7, 0x34, 2*scte35.Clock)
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		SCTE35: base64.StdEncoding.EncodeToString(out),
+	})))
+	for sequence := 4; sequence <= 6; sequence++ {
+		uploadTimedSegment(t, ingester, sequence)
+	}
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.Empty(t, playlist.DateRanges)
+	assert.Equal(t, []string{"splice-7"}, playlist.RecentlyRemovedDateRanges)
+}
+
+func TestUpdateDateRangeSkipsRetiredPlaylists(t *testing.T) {
+	ctx := context.Background()
+	ingester, server := newTestIngester(t)
+
+	uploadTimedSegment(t, ingester, 3)
+	vtt := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:03.000\nHello\n"
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSubtitlesMessage(t, 3, vtt)))
+	server.Del("mediaplaylist:" + testPlaylistId + "/" + testSubtitlesRenditionId)
+
+	require.NoError(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		Id:        "event",
+		StartDate: helpers.Timestamp{Time: time.Unix(1676898433, 0)},
+	})))
+	_, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testSubtitlesRenditionId)
+	assert.ErrorIs(t, err, repository.ErrMediaPlaylistNotFound)
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.NotNil(t, playlist.DateRange("event"))
+}
+
+func TestUpdateDateRangeRejectsInvalidSignals(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	uploadTimedSegment(t, ingester, 3)
+
+	cases := map[string]*signals.DataGeneralShapePayloadDateRange{
+		"attribute name":  {Id: "a", Attributes: map[string]string{"TITLE": "x"}},
+		"attribute value": {Id: "a", Attributes: map[string]string{"X-TITLE": "\"x\""}},
+		"id":              {},
+		"duration":        {Id: "a", Duration: -1},
+		"end":             {Id: "a", StartDate: helpers.Timestamp{Time: time.Unix(1676898433, 0)}, EndDate: helpers.Timestamp{Time: time.Unix(1676898432, 0)}},
+		"base64":          {SCTE35: "not base64!"},
+	}
+	for name, dateRange := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, ingester.UpdateDateRange(ctx, newTestDateRangeMessage(dateRange)), ErrInvalidDateRange)
+		})
+	}
+
+	malformed := scte35test.SpliceInsert(7, true, 900000, 0)
+	malformed[len(malformed)-1] ^= 0xff
+	err := ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		SCTE35: base64.StdEncoding.EncodeToString(malformed),
+	}))
+	assert.ErrorIs(t, err, scte35.ErrMalformedSection)
+}
@@ -0,0 +1,88 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+)
+
+var (
+	ErrNoAudioSegment = fmt.Errorf("%d: demuxed payload carries no audio segment", 400)
+	ErrNoAudioPart    = fmt.Errorf("%d: demuxed payload carries no audio part", 400)
+	ErrInvalidDemux   = fmt.Errorf("%d: demuxed payload needs a variant and an audio rendition of its audio group", 400)
+)
+
+type (
+	preparer func(ctx context.Context, message *signals.DataGeneralShape) (*mediaUpload, error)
+	storer   func(ctx context.Context, message *signals.DataGeneralShape, upload *mediaUpload) error
+)
+
+// UpdateDemuxPart appends the separately muxed video and audio parts of an updateDemuxPart message
+// to the variant and to its audio rendition.
+func (i *Ingester) UpdateDemuxPart(ctx context.Context, message *signals.DataGeneralShape) error {
+	if message.Payload != nil && message.Payload.AudioPart == nil {
+		return ErrNoAudioPart
+	}
+	return i.demux(ctx, message, i.preparePart, i.storePart)
+}
+
+// UpdateDemuxSegment closes out the separately muxed video and audio segments of an updateDemuxSegment
+// message for the variant and for its audio rendition.
+func (i *Ingester) UpdateDemuxSegment(ctx context.Context, message *signals.DataGeneralShape) error {
+	return i.demux(ctx, message, i.prepareSegment, i.storeSegment)
+}
+
+// demux splits the message into one for the audio rendition and one for the variant and applies the update
+// to both. Both halves are validated before either is stored, so a rejected upload leaves both playlists as they were.
+// The rendition is stored first, so the master playlist never lists the variant without its audio group.
+// A variant which names no audio group joins the group of the rendition.
+func (i *Ingester) demux(ctx context.Context, message *signals.DataGeneralShape, prepare preparer, store storer) error {
+	payload := message.Payload
+	if payload == nil || payload.Variant == nil || payload.Rendition == nil {
+		return ErrInvalidDemux
+	}
+	if payload.AudioSegment == nil {
+		return ErrNoAudioSegment
+	}
+
+	rendition := payload.Rendition
+	if rendition.Type != signals.DataRenditionTypeAudio || rendition.GroupId == uuid.Nil {
+		return fmt.Errorf("%w: rendition of type %q in group %s", ErrInvalidDemux, rendition.Type, rendition.GroupId)
+	}
+	if payload.Variant.Audio == "" {
+		payload.Variant.Audio = rendition.GroupId.String()
+	}
+	if payload.Variant.Audio != rendition.GroupId.String() {
+		return fmt.Errorf("%w: variant plays group %s, rendition belongs to %s", ErrInvalidDemux, payload.Variant.Audio, rendition.GroupId)
+	}
+
+	audio := *message
+	audio.Payload = &signals.DataGeneralShapePayload{
+		Playlist:  payload.Playlist,
+		Rendition: rendition,
+		Segment:   payload.AudioSegment,
+		Part:      payload.AudioPart,
+	}
+	video := *message
+	video.Payload = &signals.DataGeneralShapePayload{
+		Playlist: payload.Playlist,
+		Variant:  payload.Variant,
+		Segment:  payload.Segment,
+		Part:     payload.Part,
+	}
+
+	audioUpload, err := prepare(ctx, &audio)
+	if err != nil {
+		return err
+	}
+	videoUpload, err := prepare(ctx, &video)
+	if err != nil {
+		return err
+	}
+	err = store(ctx, &audio, audioUpload)
+	if err != nil {
+		return err
+	}
+	return store(ctx, &video, videoUpload)
+}
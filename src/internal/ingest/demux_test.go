@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+const (
+	testAudioRenditionId = "d02288ec-b11f-11ed-afa1-0242ac120002"
+	testAudioGroupId     = "dc5daa10-b11f-11ed-afa1-0242ac120002"
+	testAudioMapId       = "5a1c1f3a-b123-11ed-afa1-0242ac120002"
+)
+
+// newTestDemuxPartMessage adds an audio rendition along with its segment and part to a part message of the test variant.
+func newTestDemuxPartMessage(t *testing.T, sequence int) *signals.DataGeneralShape {
+	message := newTestPartMessage(t, uuid.NewString(), sequence, false, sequence == 0)
+	message.Action = signals.DataActionUpdateDemuxPart
+	message.Payload.Variant.Codecs = ""
+
+	payload := message.Payload
+	payload.Rendition = &signals.DataGeneralShapePayloadRendition{
+		Id:                 uuid.MustParse(testAudioRenditionId),
+		Type:               signals.DataRenditionTypeAudio,
+		GroupId:            uuid.MustParse(testAudioGroupId),
+		Name:               "audio-en",
+		TargetDuration:     4,
+		TargetPartDuration: 1.0,
+		CacheKey:           testPlaylistId + "/" + testAudioRenditionId,
+	}
+	segmentId := uuid.New()
+	payload.AudioSegment = &signals.DataGeneralShapePayloadSegment{
+		Id:       segmentId,
+		Sequence: payload.Segment.Sequence,
+		CacheKey: testPlaylistId + "/" + segmentId.String(),
+	}
+	if sequence == 0 {
+		payload.Rendition.InitCacheKey = testPlaylistId + "/" + testAudioMapId
+		payload.AudioSegment.Map = &signals.MediaInitializationSection{
+			Id:   uuid.MustParse(testAudioMapId),
+			Data: base64.StdEncoding.EncodeToString(isobmfftest.Init(testAudioTrack)),
+		}
+	}
+	partId := uuid.New()
+	payload.AudioPart = &signals.DataGeneralShapePayloadPart{
+		Id:       partId,
+		Sequence: sequence,
+		Duration: 1.0,
+		Data:     base64.StdEncoding.EncodeToString(isobmfftest.Fragment{Sequence: 1, TrackId: 2, Data: []byte("audio")}.Bytes()),
+		CacheKey: testPlaylistId + "/" + partId.String(),
+	}
+	return message
+}
+
+func TestUpdateDemuxPart(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	repo := ingester.Repository
+
+	var messages []*signals.DataGeneralShape
+	for sequence := 0; sequence < 2; sequence++ {
+		message := newTestDemuxPartMessage(t, sequence)
+		require.NoError(t, ingester.UpdateDemuxPart(ctx, message))
+		messages = append(messages, message)
+	}
+
+	video, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	audio, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testAudioRenditionId)
+	require.NoError(t, err)
+	assert.Equal(t, testPlaylistId+"/"+testAudioMapId, audio.InitCacheKey)
+	assert.Equal(t, string(signals.MimeTypeAudio), audio.MimeType)
+
+	for i, message := range messages {
+		assert.Equal(t, message.Payload.Part.CacheKey, video.Segment(3).Parts[i].CacheKey)
+		assert.Equal(t, message.Payload.AudioPart.CacheKey, audio.Segment(3).Parts[i].CacheKey)
+		assert.NotEqual(t, message.Payload.Part.CacheKey, message.Payload.AudioPart.CacheKey)
+
+		data, err := repo.GetMedia(ctx, message.Payload.AudioPart.CacheKey)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "audio")
+	}
+
+	closing := newTestDemuxPartMessage(t, 1)
+	closing.Action = signals.DataActionUpdateDemuxSegment
+	closing.Payload.Part, closing.Payload.AudioPart = nil, nil
+	closing.Payload.Segment.Duration, closing.Payload.AudioSegment.Duration = 2.002, 2.002
+	require.NoError(t, ingester.UpdateDemuxSegment(ctx, closing))
+
+	for _, cacheKey := range []string{closing.Payload.Segment.CacheKey, closing.Payload.AudioSegment.CacheKey} {
+		_, err = repo.GetMedia(ctx, cacheKey)
+		assert.NoError(t, err)
+	}
+
+	multivariant, err := repo.GetMultivariantPlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	assert.Equal(t, testAudioGroupId, multivariant.Variant(testVariantId).Audio)
+	rendition := multivariant.Rendition(testAudioRenditionId)
+	require.NotNil(t, rendition)
+	assert.Equal(t, "Opus", rendition.Codecs)
+}
+
+func TestUpdateDemuxPartRejectsInvalidMessages(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	noAudioPart := newTestDemuxPartMessage(t, 0)
+	noAudioPart.Payload.AudioPart = nil
+
+	noAudioSegment := newTestDemuxPartMessage(t, 0)
+	noAudioSegment.Payload.AudioSegment = nil
+
+	noRendition := newTestDemuxPartMessage(t, 0)
+	noRendition.Payload.Rendition = nil
+
+	videoRendition := newTestDemuxPartMessage(t, 0)
+	videoRendition.Payload.Rendition.Type = signals.DataRenditionTypeVideo
+
+	otherGroup := newTestDemuxPartMessage(t, 0)
+	otherGroup.Payload.Variant.Audio = uuid.NewString()
+
+	cases := []struct {
+		Message  *signals.DataGeneralShape
+		Expected error
+	}{
+		{Message: noAudioPart, Expected: ErrNoAudioPart},
+		{Message: noAudioSegment, Expected: ErrNoAudioSegment},
+		{Message: noRendition, Expected: ErrInvalidDemux},
+		{Message: videoRendition, Expected: ErrInvalidDemux},
+		{Message: otherGroup, Expected: ErrInvalidDemux},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			assert.ErrorIs(t, ingester.UpdateDemuxPart(ctx, c.Message), c.Expected)
+		})
+	}
+}
+
+func TestUpdateDemuxRejectsBothHalves(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	repo := ingester.Repository
+	require.NoError(t, ingester.UpdateDemuxPart(ctx, newTestDemuxPartMessage(t, 0)))
+
+	unknownTrack := newTestDemuxPartMessage(t, 1)
+	unknownTrack.Payload.Part.Data = base64.StdEncoding.EncodeToString(
+		isobmfftest.Fragment{Sequence: 2, TrackId: 3, Data: []byte("video")}.Bytes())
+	assert.ErrorIs(t, ingester.UpdateDemuxPart(ctx, unknownTrack), ErrUnknownTrack)
+	_, err := repo.GetMedia(ctx, unknownTrack.Payload.AudioPart.CacheKey)
+	assert.ErrorIs(t, err, repository.ErrMediaNotFound)
+
+	noDuration := newTestDemuxPartMessage(t, 0)
+	noDuration.Action = signals.DataActionUpdateDemuxSegment
+	noDuration.Payload.Part, noDuration.Payload.AudioPart = nil, nil
+	noDuration.Payload.AudioSegment.Duration = 1.001
+	assert.ErrorIs(t, ingester.UpdateDemuxSegment(ctx, noDuration), ErrNoSegmentDuration)
+
+	audio, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testAudioRenditionId)
+	require.NoError(t, err)
+	require.Len(t, audio.Segments, 1)
+	assert.Len(t, audio.Segments[0].Parts, 1)
+	assert.False(t, audio.Segments[0].Complete)
+}
@@ -0,0 +1,64 @@
+package ingest
+
+import (
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"math"
+)
+
+// continuesEdge tells whether a part, or a whole segment when part is negative, follows the media uploaded last
+// to the playlist. Retried uploads and parts of a segment which was already closed do not.
+func continuesEdge(playlist *model.MediaPlaylist, segment int, part int) bool {
+	if len(playlist.Segments) == 0 {
+		return true
+	}
+
+	last := playlist.Segments[len(playlist.Segments)-1]
+	switch {
+	case segment > last.Sequence:
+		return true
+	case segment < last.Sequence || last.Complete:
+		return false
+	case part < 0:
+		return len(last.Parts) == 0
+	case len(last.Parts) == 0:
+		return true
+	}
+	return part > last.Parts[len(last.Parts)-1].Sequence
+}
+
+// followDecodeTime advances the decode time the next media of the playlist is expected at past the media,
+// and reports whether the media does not start where the previous one ended: its decode time either ran
+// backwards, as it does when the encoder of the publisher restarts, or jumped ahead by more than a target duration.
+func followDecodeTime(playlist *model.MediaPlaylist, media *isobmff.Media) bool {
+	measured, ok := timingOf(playlist, media)
+	if !ok {
+		return false
+	}
+
+	expected := playlist.NextDecodeTime
+	playlist.NextDecodeTime = measured.End
+	if expected == 0 {
+		return false
+	}
+
+	timescale := float64(referenceTrack(playlist).Timescale)
+	drift := (float64(measured.Start) - float64(expected)) / timescale
+	return drift < -durationTolerance || drift > float64(playlist.TargetDuration)
+}
+
+// skipDecodeTime advances the decode time the next media of the playlist is expected at past a gap.
+func skipDecodeTime(playlist *model.MediaPlaylist, duration float64) {
+	reference := referenceTrack(playlist)
+	if playlist.NextDecodeTime == 0 || reference == nil {
+		return
+	}
+	playlist.NextDecodeTime += uint64(math.Round(duration * float64(reference.Timescale)))
+}
+
+// markDecodeTime records the decode time the media of a segment starts at, unless the media carries no timed samples.
+func markDecodeTime(playlist *model.MediaPlaylist, segment *model.Segment, media *isobmff.Media) {
+	if measured, ok := timingOf(playlist, media); ok {
+		segment.DecodeTime = measured.Start
+	}
+}
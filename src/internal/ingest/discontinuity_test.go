@@ -0,0 +1,104 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// timedFragment encodes a single sample of the test video track lasting duration from decodeTime.
+func timedFragment(decodeTime uint64, duration uint32) string {
+	return base64.StdEncoding.EncodeToString(isobmfftest.Fragment{
+		Sequence:            1,
+		TrackId:             1,
+		BaseMediaDecodeTime: decodeTime,
+		Samples:             []isobmff.Sample{{Duration: duration, Size: 1}},
+		Data:                []byte{0},
+	}.Bytes())
+}
+
+func TestUpdatePartDetectsDecodeTimeReset(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	upload := func(segment int, part int, gap bool, decodeTime uint64) {
+		message := newTestPartMessage(t, uuid.NewString(), part, gap, segment == 3 && part == 0)
+		message.Payload.Segment.Sequence = segment
+		message.Payload.Segment.Discontinuity = false
+		if !gap {
+			message.Payload.Part.Data = timedFragment(decodeTime, 90000)
+		}
+		require.NoError(t, ingester.UpdatePart(ctx, message))
+	}
+
+	upload(3, 0, false, 0)
+	upload(3, 1, true, 0)
+	upload(3, 2, false, 180000)
+	// A retried part does not move the decode time back.
+	upload(3, 0, false, 0)
+	upload(3, 3, false, 270000)
+	// The encoder restarted without the publisher flagging it.
+	upload(4, 0, false, 0)
+	upload(4, 1, false, 90000)
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, playlist.Segments, 2)
+	assert.False(t, playlist.Segments[0].Discontinuity)
+	assert.True(t, playlist.Segments[1].Discontinuity)
+	assert.Equal(t, uint64(180000), playlist.NextDecodeTime)
+}
+
+func TestUpdateSegmentCountsDiscontinuities(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	ingester.Window = model.Window{Segments: 2}
+
+	upload := func(sequence int, decodeTime uint64) {
+		message := newTestSegmentMessage(t, sequence, nil)
+		message.Payload.Segment.Discontinuity = false
+		message.Payload.Segment.Data = timedFragment(decodeTime, 360360)
+		if sequence == 3 {
+			message.Payload.Segment.Map = &signals.MediaInitializationSection{
+				Id:   uuid.MustParse(testMapId),
+				Data: base64.StdEncoding.EncodeToString(testInit("init")),
+			}
+		}
+		require.NoError(t, ingester.UpdateSegment(ctx, message))
+	}
+
+	upload(3, 0)
+	upload(4, 360360)
+	upload(5, 720720)
+	upload(6, 0)
+	upload(7, 360360)
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.Equal(t, 5, playlist.MediaSequence)
+	assert.Zero(t, playlist.DiscontinuitySequence)
+	assert.True(t, playlist.Segment(6).Discontinuity)
+	assert.False(t, playlist.Segment(7).Discontinuity)
+
+	// The discontinuity is counted once its segment becomes the first one, and only then.
+	upload(8, 720720)
+
+	playlist, err = ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.Equal(t, 6, playlist.MediaSequence)
+	assert.Equal(t, 1, playlist.DiscontinuitySequence)
+
+	upload(9, 1081080)
+
+	playlist, err = ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.Equal(t, 7, playlist.MediaSequence)
+	assert.Equal(t, 1, playlist.DiscontinuitySequence)
+}
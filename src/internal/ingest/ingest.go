@@ -0,0 +1,324 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/ads"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"log"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNoMediaPlaylist  = fmt.Errorf("%d: payload carries neither a variant nor a rendition", 400)
+	ErrNoSegment        = fmt.Errorf("%d: payload carries no segment", 400)
+	ErrNoPart           = fmt.Errorf("%d: payload carries no part", 400)
+	ErrInvalidMediaData = fmt.Errorf("%d: media data is not valid base64", 400)
+	ErrMalformedInit    = fmt.Errorf("%d: malformed initialization section", 400)
+	ErrMalformedMedia   = fmt.Errorf("%d: malformed media data", 400)
+	ErrUnknownTrack     = fmt.Errorf("%d: media data carries a track missing from the initialization section", 400)
+)
+
+// DefaultWindow is the live window of the media playlists whose publisher configures none.
+var DefaultWindow = model.Window{Duration: 60}
+
+// DefaultCaptionDelay is how long live caption cues are awaited after the video segment they belong to ends.
+var DefaultCaptionDelay = 6 * time.Second
+
+type Ingester struct {
+	Repository *repository.StreamRepository
+	Redlock    *redlock.Redlock
+	// Window applies to the media playlists whose publisher configures no window of their own.
+	Window model.Window
+	// CorrectParts replaces the declared duration and independence of parts with those measured on their media.
+	CorrectParts bool
+	// CaptionDelay holds back the subtitle segments of live captions behind the live edge of the video,
+	// as captions are transcribed after the fact.
+	CaptionDelay time.Duration
+	// Ads holds the media of the ad assets spliced into ad breaks. Without it, ad breaks are only marked.
+	Ads ads.Store
+}
+
+func NewIngester(repo *repository.StreamRepository, redlock *redlock.Redlock) *Ingester {
+	return &Ingester{
+		Repository:   repo,
+		Redlock:      redlock,
+		Window:       DefaultWindow,
+		CaptionDelay: DefaultCaptionDelay,
+	}
+}
+
+// updateMediaPlaylist serializes the updates of a media playlist by locking its cache key.
+// A write is rejected when a later lock holder already stored the playlist, which happens
+// when this invocation outlived its lock.
+func (i *Ingester) updateMediaPlaylist(ctx context.Context, seed *model.MediaPlaylist, update func(playlist *model.MediaPlaylist) error) error {
+	return i.lockMediaPlaylist(ctx, seed, true, update)
+}
+
+// updateStoredMediaPlaylist works like updateMediaPlaylist, but skips the update when no media playlist is stored
+// under the cache key, as it was never created or already retired.
+func (i *Ingester) updateStoredMediaPlaylist(ctx context.Context, cacheKey string, update func(playlist *model.MediaPlaylist) error) error {
+	return i.lockMediaPlaylist(ctx, &model.MediaPlaylist{CacheKey: cacheKey}, false, update)
+}
+
+func (i *Ingester) lockMediaPlaylist(ctx context.Context, seed *model.MediaPlaylist, create bool, update func(playlist *model.MediaPlaylist) error) error {
+	lock, err := i.Redlock.Acquire(ctx, seed.CacheKey)
+	if err != nil {
+		return err
+	}
+	defer release(ctx, lock)
+
+	playlist, err := i.Repository.GetMediaPlaylist(ctx, seed.CacheKey)
+	if errors.Is(err, repository.ErrMediaPlaylistNotFound) {
+		if !create {
+			return nil
+		}
+		playlist = seed
+		err = i.applyInterstitials(ctx, playlist)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if playlist.FenceToken > lock.Token() {
+		return redlock.ErrLockLost
+	}
+	err = mergeMediaPlaylist(playlist, seed)
+	if err != nil {
+		return err
+	}
+
+	err = update(playlist)
+	if err != nil {
+		return err
+	}
+
+	if !lock.Valid() {
+		return redlock.ErrLockLost
+	}
+	playlist.FenceToken = lock.Token()
+	playlist.UpdatedAt = time.Now()
+	err = i.Repository.SetMediaPlaylist(ctx, playlist)
+	if errors.Is(err, repository.ErrFenced) {
+		return redlock.ErrLockLost
+	}
+	return err
+}
+
+func release(ctx context.Context, lock *redlock.Lock) {
+	err := lock.Release(ctx)
+	if err != nil {
+		log.Println("releasing lock failed: ", err)
+	}
+}
+
+// mediaPlaylistOf seeds the media playlist state of the variant or rendition the payload belongs to.
+// Payloads carrying both belong to the variant.
+func mediaPlaylistOf(payload *signals.DataGeneralShapePayload) (*model.MediaPlaylist, error) {
+	if payload != nil && payload.Variant != nil {
+		return variantPlaylistOf(payload)
+	}
+	return renditionPlaylistOf(payload)
+}
+
+func variantPlaylistOf(payload *signals.DataGeneralShapePayload) (*model.MediaPlaylist, error) {
+	if payload == nil || payload.Playlist == nil || payload.Variant == nil {
+		return nil, ErrNoMediaPlaylist
+	}
+
+	variant := payload.Variant
+	return &model.MediaPlaylist{
+		Id:                 variant.Id.String(),
+		PlaylistId:         payload.Playlist.Id.String(),
+		CacheKey:           variant.CacheKey,
+		InitCacheKey:       variant.InitCacheKey,
+		MimeType:           string(signals.MimeTypeVideo),
+		TargetDuration:     variant.TargetDuration,
+		TargetPartDuration: variant.TargetPartDuration,
+		Window: model.Window{
+			Segments: variant.WindowSegments,
+			Duration: variant.WindowDuration,
+		},
+	}, nil
+}
+
+func renditionPlaylistOf(payload *signals.DataGeneralShapePayload) (*model.MediaPlaylist, error) {
+	if payload == nil || payload.Playlist == nil || payload.Rendition == nil {
+		return nil, ErrNoMediaPlaylist
+	}
+
+	rendition := payload.Rendition
+	mimeType := signals.GetMimeType(rendition.Type)
+	if rendition.Type == signals.DataRenditionTypeSubtitles {
+		// Subtitles are either fMP4 or plain WebVTT, so their playlist takes the type of the media uploaded.
+		mimeType = ""
+	}
+	return &model.MediaPlaylist{
+		Id:                 rendition.Id.String(),
+		PlaylistId:         payload.Playlist.Id.String(),
+		CacheKey:           rendition.CacheKey,
+		InitCacheKey:       rendition.InitCacheKey,
+		MimeType:           mimeType,
+		TargetDuration:     rendition.TargetDuration,
+		TargetPartDuration: rendition.TargetPartDuration,
+		Window: model.Window{
+			Segments: rendition.WindowSegments,
+			Duration: rendition.WindowDuration,
+		},
+	}, nil
+}
+
+// checkMediaPlaylist verifies the seed can be merged into the stored media playlist, so that a registration
+// the playlist would reject is rejected before anything of it is stored.
+func (i *Ingester) checkMediaPlaylist(ctx context.Context, seed *model.MediaPlaylist) error {
+	playlist, err := i.Repository.GetMediaPlaylist(ctx, seed.CacheKey)
+	if errors.Is(err, repository.ErrMediaPlaylistNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return mergeMediaPlaylist(playlist, seed)
+}
+
+// mergeMediaPlaylist carries the publisher supplied settings of the seed over to the stored state.
+// The target duration is fixed once the playlist carries segments.
+func mergeMediaPlaylist(playlist, seed *model.MediaPlaylist) error {
+	if seed.TargetDuration != 0 && seed.TargetDuration != playlist.TargetDuration {
+		if len(playlist.Segments) > 0 {
+			return &IncompatibleChangeError{
+				Id:        playlist.Id,
+				Field:     "targetDuration",
+				Current:   strconv.Itoa(playlist.TargetDuration),
+				Requested: strconv.Itoa(seed.TargetDuration),
+			}
+		}
+		playlist.TargetDuration = seed.TargetDuration
+	}
+	if seed.InitCacheKey != "" {
+		playlist.InitCacheKey = seed.InitCacheKey
+	}
+	if seed.MimeType != "" {
+		playlist.MimeType = seed.MimeType
+	}
+	if seed.TargetPartDuration != 0 {
+		playlist.TargetPartDuration = seed.TargetPartDuration
+	}
+	if len(seed.Tracks) > 0 {
+		playlist.Tracks = seed.Tracks
+	}
+	if !seed.Window.IsZero() {
+		playlist.Window = seed.Window
+	}
+	return nil
+}
+
+// parseInit validates the initialization section the payload carries, hands its tracks to the seed and
+// derives the codecs of the variant or rendition from them. It returns nil when the payload carries none.
+func parseInit(seed *model.MediaPlaylist, payload *signals.DataGeneralShapePayload) ([]byte, error) {
+	if payload == nil || payload.Segment == nil || payload.Segment.Map == nil {
+		return nil, nil
+	}
+
+	data, err := decodeMedia(payload.Segment.Map.Data)
+	if err != nil {
+		return nil, err
+	}
+	init, err := isobmff.ParseInit(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedInit, err)
+	}
+
+	seed.Tracks = nil
+	for _, track := range init.Tracks {
+		seed.Tracks = append(seed.Tracks, &model.Track{
+			Id:          track.Id,
+			Timescale:   track.Timescale,
+			HandlerType: track.HandlerType,
+			Codec:       track.Codec,
+
+			DefaultSampleDuration: track.DefaultSampleDuration,
+			DefaultSampleFlags:    track.DefaultSampleFlags,
+			DefaultSampleSize:     track.DefaultSampleSize,
+			NALLengthSize:         track.NALLengthSize,
+		})
+	}
+	err = applyCodecs(payload, seed.Tracks)
+	if err != nil {
+		return nil, err
+	}
+	applyVideo(payload, init)
+	return data, nil
+}
+
+func parseMedia(data []byte) (*isobmff.Media, error) {
+	media, err := isobmff.ParseMedia(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMedia, err)
+	}
+	return media, nil
+}
+
+// checkTracks verifies the media data only carries tracks of the initialization section of the playlist.
+func checkTracks(playlist *model.MediaPlaylist, media *isobmff.Media) error {
+	if media == nil || len(playlist.Tracks) == 0 {
+		return nil
+	}
+
+	for _, id := range media.TrackIds() {
+		if playlist.Track(id) == nil {
+			return fmt.Errorf("%w: track %d", ErrUnknownTrack, id)
+		}
+	}
+	return nil
+}
+
+// mediaUpload is the media of an upload which passed validation and is about to be stored.
+type mediaUpload struct {
+	Seed *model.MediaPlaylist
+	// Data is the media data of the part or segment, nil for gaps and segments assembled from their parts.
+	Data  []byte
+	Media *isobmff.Media
+	// Init is the initialization section the upload carries, if any.
+	Init []byte
+}
+
+// storeInit caches the initialization section of the upload, if it carries one.
+func (i *Ingester) storeInit(ctx context.Context, upload *mediaUpload) error {
+	if upload.Init == nil {
+		return nil
+	}
+	return i.Repository.SetMedia(ctx, upload.Seed.InitCacheKey, upload.Init)
+}
+
+// checkStoredSegment verifies the segment of an upload is still listed by the stored playlist, and its media data
+// only carries tracks of the initialization section the upload carries, or else of the one stored for the playlist,
+// so that uploads it rejects store nothing. The checks are repeated under the lock of the playlist, as the playlist
+// may change in between.
+func (i *Ingester) checkStoredSegment(ctx context.Context, seed *model.MediaPlaylist, sequence int, media *isobmff.Media) error {
+	playlist, err := i.Repository.GetMediaPlaylist(ctx, seed.CacheKey)
+	if errors.Is(err, repository.ErrMediaPlaylistNotFound) {
+		playlist = seed
+	} else if err != nil {
+		return err
+	}
+	if len(seed.Tracks) > 0 {
+		playlist.Tracks = seed.Tracks
+	}
+	return checkSegment(playlist, sequence, media)
+}
+
+func decodeMedia(data string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMediaData, err)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,179 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrInvalidInterstitial = fmt.Errorf("%d: invalid interstitial", 400)
+
+// InterstitialClass is the date range class which tells players to play an interstitial.
+const InterstitialClass = "com.apple.hls.interstitial"
+
+// interstitialId matches the ids of interstitials, which name their asset list in its path.
+var interstitialId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// interstitialDateRangePrefix starts the ids of the date ranges announcing interstitials, see InterstitialDateRangeId.
+const interstitialDateRangePrefix = "interstitial-"
+
+// restrictions are the values X-RESTRICT may list.
+var restrictions = []string{"SKIP", "JUMP"}
+
+// ScheduleInterstitial stores the interstitial for its master playlist and announces it in the media playlist of
+// every variant and rendition with an interstitial date range. A single asset is referenced by X-ASSET-URI, while
+// pods are resolved by players through the asset list of the interstitial, see AssetListUri. Media playlists which
+// are created later, including those of a stream which did not start yet, pick up the interstitial on creation.
+// Interstitials can not be rescheduled under their id, as published date ranges must not change, but scheduling
+// the same interstitial again completes a schedule which failed midway.
+func (i *Ingester) ScheduleInterstitial(ctx context.Context, playlistId uuid.UUID, interstitial *model.Interstitial) error {
+	err := validateInterstitial(interstitial)
+	if err != nil {
+		return err
+	}
+	if interstitial.Id == "" {
+		interstitial.Id = uuid.NewString()
+	}
+	if interstitial.Duration == 0 {
+		for _, asset := range interstitial.Assets {
+			interstitial.Duration += asset.Duration
+		}
+	}
+	interstitial.CreatedAt = time.Now()
+
+	err = i.Repository.AddInterstitial(ctx, playlistId.String(), interstitial)
+	if errors.Is(err, repository.ErrInterstitialExists) {
+		stored, err := i.Repository.GetInterstitial(ctx, playlistId.String(), interstitial.Id)
+		if err != nil {
+			return err
+		}
+		if !sameInterstitial(stored, interstitial) {
+			return repository.ErrInterstitialExists
+		}
+		interstitial.CreatedAt = stored.CreatedAt
+	} else if err != nil {
+		return err
+	}
+
+	multivariant, err := i.Repository.GetMultivariantPlaylist(ctx, playlistId.String())
+	if errors.Is(err, repository.ErrMultivariantPlaylistNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return i.spreadDateRange(ctx, multivariant, interstitialDateRange(interstitial), false)
+}
+
+// CancelInterstitial withdraws a scheduled interstitial from the media playlists of its master playlist.
+func (i *Ingester) CancelInterstitial(ctx context.Context, playlistId uuid.UUID, id string) error {
+	interstitial, err := i.Repository.GetInterstitial(ctx, playlistId.String(), id)
+	if err != nil {
+		return err
+	}
+	multivariant, err := i.Repository.GetMultivariantPlaylist(ctx, playlistId.String())
+	if err == nil {
+		err = i.spreadDateRange(ctx, multivariant, interstitialDateRange(interstitial), true)
+	} else if errors.Is(err, repository.ErrMultivariantPlaylistNotFound) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return i.Repository.DeleteInterstitial(ctx, playlistId.String(), id)
+}
+
+// applyInterstitials announces the scheduled interstitials of its master playlist in a media playlist being created.
+func (i *Ingester) applyInterstitials(ctx context.Context, playlist *model.MediaPlaylist) error {
+	if playlist.PlaylistId == "" {
+		return nil
+	}
+	interstitials, err := i.Repository.GetInterstitials(ctx, playlist.PlaylistId)
+	if err != nil {
+		return err
+	}
+	for _, interstitial := range interstitials {
+		playlist.UpsertDateRange(interstitialDateRange(interstitial))
+	}
+	return nil
+}
+
+// InterstitialDateRangeId returns the id of the date range announcing an interstitial. Interstitials take their
+// own namespace of date range ids, so they can not collide with the date ranges of the publisher.
+func InterstitialDateRangeId(id string) string {
+	return interstitialDateRangePrefix + id
+}
+
+// AssetListUri returns the URI of the asset list of an interstitial relative to the media playlists.
+func AssetListUri(id string) string {
+	return "../interstitials/" + id + "/assets.json"
+}
+
+func validateInterstitial(interstitial *model.Interstitial) error {
+	switch {
+	case interstitial.Id != "" && !interstitialId.MatchString(interstitial.Id):
+		return fmt.Errorf("%w: id %q", ErrInvalidInterstitial, interstitial.Id)
+	case interstitial.StartDate.IsZero():
+		return fmt.Errorf("%w: missing start date", ErrInvalidInterstitial)
+	case interstitial.Duration < 0:
+		return fmt.Errorf("%w: negative duration", ErrInvalidInterstitial)
+	case (interstitial.AssetUri == "") == (len(interstitial.Assets) == 0):
+		return fmt.Errorf("%w: needs either an asset URI or assets", ErrInvalidInterstitial)
+	case !validUri(interstitial.AssetUri):
+		return fmt.Errorf("%w: asset URI %q", ErrInvalidInterstitial, interstitial.AssetUri)
+	case interstitial.ResumeOffset != nil && *interstitial.ResumeOffset < 0:
+		return fmt.Errorf("%w: negative resume offset", ErrInvalidInterstitial)
+	}
+	for index, asset := range interstitial.Assets {
+		if asset.Uri == "" || !validUri(asset.Uri) || asset.Duration <= 0 {
+			return fmt.Errorf("%w: asset %d", ErrInvalidInterstitial, index)
+		}
+	}
+	for _, restriction := range interstitial.Restrict {
+		if !contains(restrictions, restriction) {
+			return fmt.Errorf("%w: restriction %q", ErrInvalidInterstitial, restriction)
+		}
+	}
+	return nil
+}
+
+// validUri tells whether the URI can be quoted in an attribute list.
+func validUri(uri string) bool {
+	return !strings.ContainsAny(uri, "\"\r\n")
+}
+
+// sameInterstitial tells whether two interstitials announce and play the same.
+func sameInterstitial(a, b *model.Interstitial) bool {
+	return a.StartDate.Equal(b.StartDate) && a.Duration == b.Duration && a.AssetUri == b.AssetUri &&
+		reflect.DeepEqual(a.AssetList(), b.AssetList()) && reflect.DeepEqual(a.ResumeOffset, b.ResumeOffset) &&
+		strings.Join(a.Restrict, ",") == strings.Join(b.Restrict, ",")
+}
+
+func interstitialDateRange(interstitial *model.Interstitial) *model.DateRange {
+	attributes := map[string]string{}
+	if interstitial.AssetUri != "" {
+		attributes["X-ASSET-URI"] = "\"" + interstitial.AssetUri + "\""
+	} else {
+		attributes["X-ASSET-LIST"] = "\"" + AssetListUri(interstitial.Id) + "\""
+	}
+	if interstitial.ResumeOffset != nil {
+		attributes["X-RESUME-OFFSET"] = formatSeconds(*interstitial.ResumeOffset)
+	}
+	if len(interstitial.Restrict) > 0 {
+		attributes["X-RESTRICT"] = "\"" + strings.Join(interstitial.Restrict, ",") + "\""
+	}
+
+	return &model.DateRange{
+		Id:         InterstitialDateRangeId(interstitial.Id),
+		Class:      InterstitialClass,
+		StartDate:  interstitial.StartDate,
+		Duration:   interstitial.Duration,
+		Attributes: attributes,
+	}
+}
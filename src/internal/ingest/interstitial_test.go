@@ -0,0 +1,141 @@
+package ingest
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestScheduleInterstitial(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	repo := ingester.Repository
+	playlistId := uuid.MustParse(testPlaylistId)
+
+	uploadTimedSegment(t, ingester, 3)
+	vtt := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:03.000\nHello\n"
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSubtitlesMessage(t, 3, vtt)))
+
+	start := time.Unix(1676898433, 0).Add(time.Minute)
+	resume := 0.0
+	pod := &model.Interstitial{
+		Id:        "pod-1",
+		StartDate: start,
+		Assets: []*model.InterstitialAsset{
+			{Uri: "https://ads.example.com/a/master.m3u8", Duration: 15},
+			{Uri: "https://ads.example.com/b/master.m3u8", Duration: 15.5},
+		},
+		ResumeOffset: &resume,
+		Restrict:     []string{"SKIP", "JUMP"},
+	}
+	require.NoError(t, ingester.ScheduleInterstitial(ctx, playlistId, pod))
+	bumper := &model.Interstitial{StartDate: start.Add(time.Hour), AssetUri: "https://ads.example.com/bumper.m3u8"}
+	require.NoError(t, ingester.ScheduleInterstitial(ctx, playlistId, bumper))
+	assert.NotEmpty(t, bumper.Id)
+
+	for _, cacheKey := range []string{testPlaylistId + "/" + testVariantId, testPlaylistId + "/" + testSubtitlesRenditionId} {
+		playlist, err := repo.GetMediaPlaylist(ctx, cacheKey)
+		require.NoError(t, err)
+		require.Len(t, playlist.DateRanges, 2)
+
+		dateRange := playlist.DateRange(InterstitialDateRangeId("pod-1"))
+		require.NotNil(t, dateRange)
+		assert.Equal(t, InterstitialClass, dateRange.Class)
+		assert.True(t, start.Equal(dateRange.StartDate))
+		assert.Equal(t, 30.5, dateRange.Duration)
+		assert.Equal(t, map[string]string{
+			"X-ASSET-LIST":    "\"../interstitials/pod-1/assets.json\"",
+			"X-RESUME-OFFSET": "0",
+			"X-RESTRICT":      "\"SKIP,JUMP\"",
+		}, dateRange.Attributes)
+
+		dateRange = playlist.DateRange(InterstitialDateRangeId(bumper.Id))
+		require.NotNil(t, dateRange)
+		assert.Equal(t, map[string]string{"X-ASSET-URI": "\"https://ads.example.com/bumper.m3u8\""}, dateRange.Attributes)
+	}
+
+	stored, err := repo.GetInterstitial(ctx, testPlaylistId, "pod-1")
+	require.NoError(t, err)
+	assert.Equal(t, &model.AssetList{Assets: []*model.AssetListEntry{
+		{Uri: "https://ads.example.com/a/master.m3u8", Duration: 15},
+		{Uri: "https://ads.example.com/b/master.m3u8", Duration: 15.5},
+	}}, stored.AssetList())
+	interstitials, err := repo.GetInterstitials(ctx, testPlaylistId)
+	require.NoError(t, err)
+	require.Len(t, interstitials, 2)
+	assert.Equal(t, "pod-1", interstitials[0].Id)
+	assert.Equal(t, repository.StreamTTL, repo.Client.TTL(ctx, "interstitials:"+testPlaylistId).Val())
+
+	// Published interstitials can not change, only be cancelled, while scheduling them again changes nothing.
+	require.NoError(t, ingester.ScheduleInterstitial(ctx, playlistId, pod))
+	moved := *pod
+	moved.StartDate = start.Add(time.Minute)
+	assert.ErrorIs(t, ingester.ScheduleInterstitial(ctx, playlistId, &moved), repository.ErrInterstitialExists)
+	require.NoError(t, ingester.CancelInterstitial(ctx, playlistId, "pod-1"))
+	playlist, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.Nil(t, playlist.DateRange(InterstitialDateRangeId("pod-1")))
+	assert.Equal(t, []string{InterstitialDateRangeId("pod-1")}, playlist.RecentlyRemovedDateRanges)
+	assert.ErrorIs(t, ingester.CancelInterstitial(ctx, playlistId, "pod-1"), repository.ErrInterstitialNotFound)
+}
+
+func TestScheduleInterstitialBeforeMediaPlaylists(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	repo := ingester.Repository
+
+	// Interstitials scheduled before the stream starts reach every media playlist once it is created.
+	start := time.Unix(1676898433, 0).Add(time.Minute)
+	interstitial := &model.Interstitial{Id: "pod-1", StartDate: start, AssetUri: "https://ads.example.com/a/master.m3u8"}
+	require.NoError(t, ingester.ScheduleInterstitial(ctx, uuid.MustParse(testPlaylistId), interstitial))
+	uploadTimedSegment(t, ingester, 3)
+	vtt := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:03.000\nHello\n"
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSubtitlesMessage(t, 3, vtt)))
+
+	for _, cacheKey := range []string{testPlaylistId + "/" + testVariantId, testPlaylistId + "/" + testSubtitlesRenditionId} {
+		playlist, err := repo.GetMediaPlaylist(ctx, cacheKey)
+		require.NoError(t, err)
+		dateRange := playlist.DateRange(InterstitialDateRangeId("pod-1"))
+		require.NotNil(t, dateRange)
+		assert.True(t, start.Equal(dateRange.StartDate))
+	}
+
+	// Publisher date ranges can not take over the date range of an interstitial.
+	err := ingester.UpdateDateRange(ctx, newTestDateRangeMessage(&signals.DataGeneralShapePayloadDateRange{
+		Id:        InterstitialDateRangeId("pod-1"),
+		StartDate: helpers.Timestamp{Time: start},
+	}))
+	assert.ErrorIs(t, err, ErrInvalidDateRange)
+}
+
+func TestScheduleInterstitialRejectsInvalidInterstitials(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	uploadTimedSegment(t, ingester, 3)
+
+	start := time.Unix(1676898433, 0)
+	negative := -1.0
+	cases := map[string]*model.Interstitial{
+		"id":            {Id: "../pod", StartDate: start, AssetUri: "ad.m3u8"},
+		"start":         {AssetUri: "ad.m3u8"},
+		"assets":        {StartDate: start},
+		"both":          {StartDate: start, AssetUri: "ad.m3u8", Assets: []*model.InterstitialAsset{{Uri: "ad.m3u8", Duration: 1}}},
+		"uri":           {StartDate: start, AssetUri: "ad\".m3u8"},
+		"asset":         {StartDate: start, Assets: []*model.InterstitialAsset{{Uri: "ad.m3u8"}}},
+		"resume offset": {StartDate: start, AssetUri: "ad.m3u8", ResumeOffset: &negative},
+		"restrict":      {StartDate: start, AssetUri: "ad.m3u8", Restrict: []string{"PAUSE"}},
+	}
+	for name, interstitial := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := ingester.ScheduleInterstitial(ctx, uuid.MustParse(testPlaylistId), interstitial)
+			assert.ErrorIs(t, err, ErrInvalidInterstitial)
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/sehovizko/mobworx-streamer/src/internal/webvtt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCaptions = fmt.Errorf("%d: invalid caption cues", 400)
+
+// captionsGroup names the subtitles group a master playlist registers the languages of live captions under.
+const captionsGroup = "captions"
+
+// languageTag matches the BCP 47 language tags caption cues are sent with.
+var languageTag = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// UpdateCaptions queues the live caption cues of an updateCaptions message and registers a SUBTITLES rendition
+// per language of the cues under their master playlist. The cues are published as WebVTT segments which line up
+// with the segments of the video, see publishCaptions.
+func (i *Ingester) UpdateCaptions(ctx context.Context, message *signals.DataGeneralShape) error {
+	payload := message.Payload
+	if payload == nil || payload.Playlist == nil {
+		return ErrNoMediaPlaylist
+	}
+
+	cues, languages, err := captionCuesOf(payload.Captions)
+	if err != nil {
+		return err
+	}
+	err = i.Repository.AddCaptionCues(ctx, payload.Playlist.Id.String(), cues)
+	if err != nil {
+		return err
+	}
+	err = i.registerCaptionLanguages(ctx, payload.Playlist.Id, languages)
+	if err != nil {
+		return err
+	}
+	return i.publishCaptions(ctx, payload.Playlist.Id)
+}
+
+// captionCuesOf validates the caption cues of a message and returns them along with their languages.
+func captionCuesOf(captions []*signals.DataGeneralShapePayloadCaption) ([]*model.CaptionCue, []string, error) {
+	if len(captions) == 0 {
+		return nil, nil, fmt.Errorf("%w: message carries no cues", ErrInvalidCaptions)
+	}
+
+	cues := make([]*model.CaptionCue, 0, len(captions))
+	var languages []string
+	for index, caption := range captions {
+		switch {
+		case !languageTag.MatchString(caption.Language):
+			return nil, nil, fmt.Errorf("%w: cue %d has the language %q", ErrInvalidCaptions, index, caption.Language)
+		case caption.Start.IsZero() || !caption.End.After(caption.Start.Time):
+			return nil, nil, fmt.Errorf("%w: cue %d does not end after it starts", ErrInvalidCaptions, index)
+		case strings.TrimSpace(caption.Text) == "":
+			return nil, nil, fmt.Errorf("%w: cue %d has no text", ErrInvalidCaptions, index)
+		}
+
+		if !contains(languages, caption.Language) {
+			languages = append(languages, caption.Language)
+		}
+		cues = append(cues, &model.CaptionCue{
+			Language: caption.Language,
+			Text:     caption.Text,
+			Start:    caption.Start.Time,
+			End:      caption.End.Time,
+		})
+	}
+	return cues, languages, nil
+}
+
+// registerCaptionLanguages adds a SUBTITLES rendition per language to the captions group of the master playlist
+// and lets the variants which declare no subtitles group of their own play along with that group.
+func (i *Ingester) registerCaptionLanguages(ctx context.Context, playlistId uuid.UUID, languages []string) error {
+	groupId := captionsGroupId(playlistId)
+	return i.updateMultivariantPlaylist(ctx, playlistId.String(), func(playlist *model.MultivariantPlaylist) (bool, error) {
+		changed := linkCaptions(playlist, groupId)
+		for _, language := range languages {
+			id := uuid.NewSHA1(playlistId, []byte(captionsGroup+"/"+language)).String()
+			if playlist.Rendition(id) != nil {
+				continue
+			}
+			playlist.Renditions = append(playlist.Renditions, &model.Rendition{
+				Id:         id,
+				CacheKey:   playlistId.String() + "/" + id,
+				Type:       string(signals.DataRenditionTypeSubtitles),
+				GroupId:    groupId,
+				Name:       language,
+				Language:   language,
+				AutoSelect: true,
+			})
+			changed = true
+		}
+		return changed, nil
+	})
+}
+
+func captionsGroupId(playlistId uuid.UUID) string {
+	return uuid.NewSHA1(playlistId, []byte(captionsGroup)).String()
+}
+
+// linkCaptions hands the captions group to the variants without a subtitles group and reports whether there were any.
+func linkCaptions(playlist *model.MultivariantPlaylist, groupId string) bool {
+	linked := false
+	for _, variant := range playlist.Variants {
+		if variant.Subtitles == "" {
+			variant.Subtitles = groupId
+			linked = true
+		}
+	}
+	return linked
+}
+
+// publishCaptions slices the queued caption cues into a WebVTT segment per complete segment of the video, once the
+// video moved on by the caption delay, for every language of the captions group of the master playlist. The captions
+// segments take the sequence, duration and program date time of their video segment. A cue spanning segments is
+// repeated in each of them, and cues which end before the segments published last are dropped.
+func (i *Ingester) publishCaptions(ctx context.Context, playlistId uuid.UUID) error {
+	multivariant, err := i.Repository.GetMultivariantPlaylist(ctx, playlistId.String())
+	if errors.Is(err, repository.ErrMultivariantPlaylistNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	groupId := captionsGroupId(playlistId)
+	var renditions []*model.Rendition
+	for _, rendition := range multivariant.Renditions {
+		if rendition.GroupId == groupId {
+			renditions = append(renditions, rendition)
+		}
+	}
+	if len(renditions) == 0 {
+		return nil
+	}
+	// Variants registered after the captions, or replaced by a version bump, join the captions group as well.
+	if linkCaptions(multivariant, groupId) {
+		err = i.registerCaptionLanguages(ctx, playlistId, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	video, err := i.timedVideo(ctx, multivariant.Variants)
+	if err != nil || video == nil {
+		return err
+	}
+	cues, err := i.Repository.GetCaptionCues(ctx, playlistId.String())
+	if err != nil {
+		return err
+	}
+
+	var published time.Time
+	for index, rendition := range renditions {
+		end, err := i.publishCaptionsRendition(ctx, rendition, video, cues)
+		if err != nil {
+			return err
+		}
+		if index == 0 || end.Before(published) {
+			published = end
+		}
+	}
+	if published.IsZero() {
+		return nil
+	}
+	return i.Repository.TrimCaptionCues(ctx, playlistId.String(), published)
+}
+
+// publishCaptionsRendition appends the captions segments of a language the video is ready for to its media playlist
+// and returns the time its last segment ends at, which is zero while it has none.
+func (i *Ingester) publishCaptionsRendition(ctx context.Context, rendition *model.Rendition, video *model.MediaPlaylist, cues []*model.CaptionCue) (time.Time, error) {
+	starts, edge := video.ProgramDateTimes()
+	ready := -1
+	for index, segment := range video.Segments {
+		if starts[index].IsZero() {
+			continue
+		}
+		if !segment.Complete || segmentEnd(starts[index], segment).Add(i.CaptionDelay).After(edge) {
+			break
+		}
+		ready = segment.Sequence
+	}
+
+	published, err := i.Repository.GetMediaPlaylist(ctx, rendition.CacheKey)
+	if err != nil && !errors.Is(err, repository.ErrMediaPlaylistNotFound) {
+		return time.Time{}, err
+	}
+	if published != nil && len(published.Segments) > 0 {
+		last := published.Segments[len(published.Segments)-1]
+		if last.Sequence >= ready {
+			return segmentEnd(last.ProgramDateTime, last), nil
+		}
+	}
+	if ready < 0 {
+		return time.Time{}, nil
+	}
+
+	seed := &model.MediaPlaylist{
+		Id:             rendition.Id,
+		PlaylistId:     video.PlaylistId,
+		CacheKey:       rendition.CacheKey,
+		MimeType:       string(signals.MimeTypeWebVTT),
+		TargetDuration: video.TargetDuration,
+		Window:         video.Window,
+	}
+	timescale := referenceTrack(video).Timescale
+	var end time.Time
+	var expirations map[string]time.Duration
+	err = i.updateMediaPlaylist(ctx, seed, func(playlist *model.MediaPlaylist) error {
+		last := -1
+		if len(playlist.Segments) > 0 {
+			last = playlist.Segments[len(playlist.Segments)-1].Sequence
+		}
+
+		for index, segment := range video.Segments {
+			if segment.Sequence > ready {
+				break
+			}
+			if segment.Sequence <= last || starts[index].IsZero() {
+				continue
+			}
+
+			id := uuid.NewSHA1(uuid.MustParse(rendition.Id), []byte(strconv.Itoa(segment.Sequence))).String()
+			captions := &model.Segment{
+				Id:              id,
+				Sequence:        segment.Sequence,
+				Duration:        segment.Duration,
+				Discontinuity:   segment.Discontinuity || (last >= 0 && segment.Sequence != last+1),
+				ProgramDateTime: starts[index],
+				CacheKey:        video.PlaylistId + "/" + id,
+				Complete:        true,
+				Gap:             segment.Gap,
+			}
+			if !segment.Gap {
+				data := captionsSegment(segment, starts[index], timescale, cues, rendition.Language)
+				err := i.Repository.SetMedia(ctx, captions.CacheKey, data)
+				if err != nil {
+					return err
+				}
+			}
+			playlist.UpsertSegment(captions)
+			last = segment.Sequence
+		}
+
+		expirations = i.retain(playlist)
+		if len(playlist.Segments) > 0 {
+			last := playlist.Segments[len(playlist.Segments)-1]
+			end = segmentEnd(last.ProgramDateTime, last)
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return end, i.Repository.ExpireMedia(ctx, expirations)
+}
+
+// captionsSegment writes the cues of a language which overlap the video segment starting at the given time
+// as a WebVTT file in the order they start. The cues are timed from the start of the segment, which X-TIMESTAMP-MAP maps onto the
+// decode time of its video.
+func captionsSegment(segment *model.Segment, start time.Time, timescale uint32, cues []*model.CaptionCue, language string) []byte {
+	decodeTime := segment.DecodeTime
+	mpegTs := decodeTime/uint64(timescale)*webvtt.MpegTsClock + decodeTime%uint64(timescale)*webvtt.MpegTsClock/uint64(timescale)
+	file := &webvtt.File{
+		TimestampMap: &webvtt.TimestampMap{MpegTs: mpegTs % (1 << 33)},
+	}
+
+	end := segmentEnd(start, segment)
+	for _, cue := range cues {
+		if cue.Language != language || !cue.End.After(start) || !cue.Start.Before(end) {
+			continue
+		}
+		cueStart := cue.Start.Sub(start)
+		if cueStart < 0 {
+			cueStart = 0
+		}
+		file.Cues = append(file.Cues, &webvtt.Cue{
+			Start: cueStart,
+			End:   cue.End.Sub(start),
+			Text:  webvtt.EscapeText(cue.Text),
+		})
+	}
+	// The cues are stored by their end, while WebVTT lists them by their start.
+	sort.SliceStable(file.Cues, func(a, b int) bool {
+		return file.Cues[a].Start < file.Cues[b].Start
+	})
+	return file.Bytes()
+}
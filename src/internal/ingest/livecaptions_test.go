@@ -0,0 +1,140 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newTestCaptionsMessage(captions ...*signals.DataGeneralShapePayloadCaption) *signals.DataGeneralShape {
+	return &signals.DataGeneralShape{
+		Action: signals.DataActionUpdateCaptions,
+		Payload: &signals.DataGeneralShapePayload{
+			Playlist: &signals.DataGeneralShapePayloadPlaylist{Id: uuid.MustParse(testPlaylistId)},
+			Captions: captions,
+		},
+	}
+}
+
+func TestUpdateCaptions(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	ingester.CaptionDelay = 2 * time.Second
+	repo := ingester.Repository
+
+	start := time.Unix(1676898433, 0)
+	cue := func(language, text string, from, to time.Duration) *signals.DataGeneralShapePayloadCaption {
+		return &signals.DataGeneralShapePayloadCaption{Text: text, Start: helpers.Timestamp{Time: start.Add(from)}, End: helpers.Timestamp{Time: start.Add(to)}, Language: language}
+	}
+	require.NoError(t, ingester.UpdateCaptions(ctx, newTestCaptionsMessage(
+		cue("en", "Hello", time.Second, 3*time.Second),
+		cue("en", "Tom & Jerry", 3500*time.Millisecond, 5*time.Second),
+		cue("de", "Hallo", time.Second, 2*time.Second),
+	)))
+
+	// The video segments last 4.004 seconds, and only the first one carries its program date time.
+	upload := func(sequence int) {
+		message := newTestSegmentMessage(t, sequence, nil)
+		message.Payload.Segment.Discontinuity = false
+		message.Payload.Segment.Data = timedFragment(900000+uint64(sequence-3)*360360, 360360)
+		if sequence == 3 {
+			message.Payload.Segment.Map = &signals.MediaInitializationSection{
+				Id:   uuid.MustParse(testMapId),
+				Data: base64.StdEncoding.EncodeToString(testInit("init")),
+			}
+		} else {
+			message.Payload.Segment.ProgramDateTime = helpers.Timestamp{}
+		}
+		require.NoError(t, ingester.UpdateSegment(ctx, message))
+	}
+	english := testPlaylistId + "/" + uuid.NewSHA1(uuid.MustParse(testPlaylistId), []byte("captions/en")).String()
+
+	// Captions wait for the video to move on by the caption delay.
+	upload(3)
+	_, err := repo.GetMediaPlaylist(ctx, english)
+	assert.ErrorIs(t, err, repository.ErrMediaPlaylistNotFound)
+	upload(4)
+	upload(5)
+
+	playlist, err := repo.GetMediaPlaylist(ctx, english)
+	require.NoError(t, err)
+	assert.Equal(t, string(signals.MimeTypeWebVTT), playlist.MimeType)
+	require.Len(t, playlist.Segments, 2)
+	assert.Equal(t, 4, playlist.Segments[1].Sequence)
+	assert.Equal(t, 4.004, playlist.Segments[1].Duration)
+	assert.True(t, start.Add(4004*time.Millisecond).Equal(playlist.Segments[1].ProgramDateTime))
+
+	data, err := repo.GetMedia(ctx, playlist.Segments[0].CacheKey)
+	require.NoError(t, err)
+	assert.Equal(t, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n"+
+		"00:00:01.000 --> 00:00:03.000\nHello\n\n"+
+		"00:00:03.500 --> 00:00:05.000\nTom &amp; Jerry\n", string(data))
+	data, err = repo.GetMedia(ctx, playlist.Segments[1].CacheKey)
+	require.NoError(t, err)
+	assert.Equal(t, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:1260360,LOCAL:00:00:00.000\n\n"+
+		"00:00:00.000 --> 00:00:00.996\nTom &amp; Jerry\n", string(data))
+
+	// Cues which end before the published segments are dropped, later ones still make it in.
+	cues, err := repo.GetCaptionCues(ctx, testPlaylistId)
+	require.NoError(t, err)
+	assert.Empty(t, cues)
+	require.NoError(t, ingester.UpdateCaptions(ctx, newTestCaptionsMessage(cue("en", "Late", 9*time.Second, 10*time.Second))))
+	assert.Equal(t, repository.StreamTTL, repo.Client.TTL(ctx, "captioncues:"+testPlaylistId).Val())
+	upload(6)
+	playlist, err = repo.GetMediaPlaylist(ctx, english)
+	require.NoError(t, err)
+	require.Len(t, playlist.Segments, 3)
+	data, err = repo.GetMedia(ctx, playlist.Segments[2].CacheKey)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "00:00:00.992 --> 00:00:01.992\nLate\n")
+
+	multivariant, err := repo.GetMultivariantPlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	group := uuid.NewSHA1(uuid.MustParse(testPlaylistId), []byte(captionsGroup)).String()
+	assert.Equal(t, group, multivariant.Variant(testVariantId).Subtitles)
+	require.Len(t, multivariant.Renditions, 2)
+	for i, language := range []string{"en", "de"} {
+		rendition := multivariant.Renditions[i]
+		assert.Equal(t, "SUBTITLES", rendition.Type)
+		assert.Equal(t, group, rendition.GroupId)
+		assert.Equal(t, language, rendition.Language)
+	}
+}
+
+func TestCaptionsSegmentOrdersOverlappingCues(t *testing.T) {
+	start := time.Unix(1676898433, 0)
+	segment := &model.Segment{Sequence: 3, Duration: 4.004, DecodeTime: 900000}
+	cues := []*model.CaptionCue{
+		{Text: "Short", Start: start.Add(500 * time.Millisecond), End: start.Add(time.Second), Language: "en"},
+		{Text: "Long", Start: start, End: start.Add(3 * time.Second), Language: "en"},
+	}
+	assert.Equal(t, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n"+
+		"00:00:00.000 --> 00:00:03.000\nLong\n\n"+
+		"00:00:00.500 --> 00:00:01.000\nShort\n", string(captionsSegment(segment, start, 90000, cues, "en")))
+}
+
+func TestUpdateCaptionsRejectsInvalidCues(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	start := time.Unix(1676898433, 0)
+	cases := map[string]*signals.DataGeneralShapePayloadCaption{
+		"language": {Text: "Hello", Start: helpers.Timestamp{Time: start}, End: helpers.Timestamp{Time: start.Add(time.Second)}, Language: "en\""},
+		"timing":   {Text: "Hello", Start: helpers.Timestamp{Time: start}, End: helpers.Timestamp{Time: start}, Language: "en"},
+		"text":     {Text: " \n", Start: helpers.Timestamp{Time: start}, End: helpers.Timestamp{Time: start.Add(time.Second)}, Language: "en"},
+	}
+	for name, caption := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, ingester.UpdateCaptions(ctx, newTestCaptionsMessage(caption)), ErrInvalidCaptions)
+		})
+	}
+	assert.ErrorIs(t, ingester.UpdateCaptions(ctx, newTestCaptionsMessage()), ErrInvalidCaptions)
+}
@@ -0,0 +1,160 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"time"
+)
+
+var ErrStalePlaylistVersion = fmt.Errorf("%d: playlist version is older than the registered one", 409)
+
+// UpdateMultivariantPlaylist registers the variant and rendition of the message under their master playlist.
+// Entries which are already registered are only replaced when the publisher bumps the playlist version.
+func (i *Ingester) UpdateMultivariantPlaylist(ctx context.Context, message *signals.DataGeneralShape) error {
+	return i.registerMedia(ctx, message.Payload, false)
+}
+
+// registerMedia adds the variant and rendition of the payload to their master playlist. In strict mode
+// changes to a registered entry without a playlist version bump are rejected instead of being ignored.
+// Most uploads register nothing new, so the master playlist is only locked when a copy read without the lock
+// takes a change. Registrations only move forward, so a copy which takes none would not take one under the lock either.
+func (i *Ingester) registerMedia(ctx context.Context, payload *signals.DataGeneralShapePayload, strict bool) error {
+	if payload == nil || payload.Playlist == nil {
+		return ErrNoMediaPlaylist
+	}
+
+	playlist, err := i.Repository.GetMultivariantPlaylist(ctx, payload.Playlist.Id.String())
+	if err == nil {
+		changed, err := registerPayload(playlist, payload, strict)
+		if err != nil || !changed {
+			return err
+		}
+	} else if !errors.Is(err, repository.ErrMultivariantPlaylistNotFound) {
+		return err
+	}
+
+	return i.updateMultivariantPlaylist(ctx, payload.Playlist.Id.String(), func(playlist *model.MultivariantPlaylist) (bool, error) {
+		return registerPayload(playlist, payload, strict)
+	})
+}
+
+// registerPayload applies the registration of the payload to the master playlist and reports whether it changed.
+func registerPayload(playlist *model.MultivariantPlaylist, payload *signals.DataGeneralShapePayload, strict bool) (bool, error) {
+	if payload.Playlist.Version < playlist.Version {
+		if strict {
+			return false, fmt.Errorf("%w: %d < %d", ErrStalePlaylistVersion, payload.Playlist.Version, playlist.Version)
+		}
+		return false, nil
+	}
+	bumped := payload.Playlist.Version > playlist.Version
+	changed := bumped || playlist.UpdatedAt.IsZero()
+
+	if payload.Variant != nil {
+		variant := variantOf(payload.Variant)
+		existing := playlist.Variant(variant.Id)
+		if existing != nil && fillVariant(existing, variant) {
+			changed = true
+		}
+		switch {
+		case existing == nil:
+			playlist.Variants = append(playlist.Variants, variant)
+			changed = true
+		case bumped:
+			// Captions detected in the media outlive the registration the publisher replaces.
+			if variant.ClosedCaptions == "" {
+				variant.ClosedCaptions = existing.ClosedCaptions
+			}
+			*existing = *variant
+		case strict:
+			err := compareVariants(existing, variant)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if payload.Rendition != nil {
+		registered, err := registerRendition(playlist, renditionOf(payload.Rendition), bumped, strict)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || registered
+	}
+
+	playlist.Version = payload.Playlist.Version
+	return changed, nil
+}
+
+// updateMultivariantPlaylist serializes the updates of a master playlist by locking its id.
+// The playlist is only written when the update reports a change.
+func (i *Ingester) updateMultivariantPlaylist(ctx context.Context, playlistId string, update func(playlist *model.MultivariantPlaylist) (bool, error)) error {
+	lock, err := i.Redlock.Acquire(ctx, playlistId)
+	if err != nil {
+		return err
+	}
+	defer release(ctx, lock)
+
+	playlist, err := i.Repository.GetMultivariantPlaylist(ctx, playlistId)
+	if errors.Is(err, repository.ErrMultivariantPlaylistNotFound) {
+		playlist = &model.MultivariantPlaylist{
+			Id: playlistId,
+		}
+	} else if err != nil {
+		return err
+	}
+	if playlist.FenceToken > lock.Token() {
+		return redlock.ErrLockLost
+	}
+
+	changed, err := update(playlist)
+	if err != nil || !changed {
+		return err
+	}
+
+	if !lock.Valid() {
+		return redlock.ErrLockLost
+	}
+	playlist.FenceToken = lock.Token()
+	playlist.UpdatedAt = time.Now()
+	err = i.Repository.SetMultivariantPlaylist(ctx, playlist)
+	if errors.Is(err, repository.ErrFenced) {
+		return redlock.ErrLockLost
+	}
+	return err
+}
+
+func variantOf(variant *signals.DataGeneralShapePayloadVariant) *model.Variant {
+	return &model.Variant{
+		Id:             variant.Id.String(),
+		CacheKey:       variant.CacheKey,
+		Codecs:         variant.Codecs,
+		Bandwidth:      variant.Bandwidth,
+		Audio:          variant.Audio,
+		Subtitles:      variant.Subtitles,
+		ClosedCaptions: variant.ClosedCaptions,
+
+		Resolution: variant.Resolution,
+		FrameRate:  variant.FrameRate,
+		VideoRange: variant.VideoRange,
+	}
+}
+
+func renditionOf(rendition *signals.DataGeneralShapePayloadRendition) *model.Rendition {
+	return &model.Rendition{
+		Id:         rendition.Id.String(),
+		CacheKey:   rendition.CacheKey,
+		Type:       string(rendition.Type),
+		GroupId:    rendition.GroupId.String(),
+		Name:       rendition.Name,
+		Language:   rendition.Language,
+		Codecs:     rendition.Codecs,
+		IsDefault:  rendition.IsDefault,
+		AutoSelect: rendition.AutoSelect,
+		InstreamId: rendition.InstreamId,
+	}
+}
@@ -0,0 +1,62 @@
+package ingest
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUpdateMultivariantPlaylist(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	cases := []struct {
+		Version           int
+		Bandwidth         int
+		ExpectedVersion   int
+		ExpectedBandwidth int
+	}{
+		{Version: 1, Bandwidth: 2048, ExpectedVersion: 1, ExpectedBandwidth: 2048},
+		{Version: 1, Bandwidth: 4096, ExpectedVersion: 1, ExpectedBandwidth: 2048},
+		{Version: 2, Bandwidth: 4096, ExpectedVersion: 2, ExpectedBandwidth: 4096},
+		{Version: 1, Bandwidth: 1024, ExpectedVersion: 2, ExpectedBandwidth: 4096},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			message := newTestPartMessage(t, "d9c836d4-b120-11ed-afa1-0242ac120002", 0, false, false)
+			message.Payload.Playlist.Version = c.Version
+			message.Payload.Variant.Bandwidth = c.Bandwidth
+			require.NoError(t, ingester.UpdateMultivariantPlaylist(ctx, message))
+
+			playlist, err := ingester.Repository.GetMultivariantPlaylist(ctx, testPlaylistId)
+			require.NoError(t, err)
+			assert.Equal(t, c.ExpectedVersion, playlist.Version)
+			require.Len(t, playlist.Variants, 1)
+			assert.Equal(t, c.ExpectedBandwidth, playlist.Variants[0].Bandwidth)
+		})
+	}
+}
+
+func TestUpdatePartSkipsUnchangedMultivariantPlaylist(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 0, false, true)))
+
+	// Parts which register nothing new do not wait for the lock of the master playlist.
+	lock, err := ingester.Redlock.Acquire(ctx, testPlaylistId)
+	require.NoError(t, err)
+	defer release(ctx, lock)
+	timeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, ingester.UpdatePart(timeout, newTestPartMessage(t, uuid.NewString(), 1, false, false)))
+
+	changed := newTestPartMessage(t, uuid.NewString(), 2, false, false)
+	changed.Payload.Playlist.Version = 2
+	assert.ErrorIs(t, ingester.UpdatePart(timeout, changed), redlock.ErrLockNotAcquired)
+}
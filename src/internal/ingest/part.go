@@ -0,0 +1,155 @@
+package ingest
+
+import (
+	"context"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"log"
+)
+
+// UpdatePart caches the part and the optional initialization section of an updatePart message
+// and appends the part to the media playlist of the owning variant or rendition, which is registered
+// under its master playlist along with the attributes measured on the media. The declared duration and
+// independence of the part are checked against its media, and the discrepancies recorded for its publisher.
+// A decode time which does not continue the previous part marks the segment as a discontinuity.
+// Closed captions found in the video of a variant are registered as CLOSED-CAPTIONS renditions.
+// The parts of a segment which an ad is going to replace are held back from the players, see holdsParts.
+func (i *Ingester) UpdatePart(ctx context.Context, message *signals.DataGeneralShape) error {
+	upload, err := i.preparePart(ctx, message)
+	if err != nil {
+		return err
+	}
+	return i.storePart(ctx, message, upload)
+}
+
+// preparePart validates the part and the initialization section of an updatePart message without storing either.
+func (i *Ingester) preparePart(ctx context.Context, message *signals.DataGeneralShape) (*mediaUpload, error) {
+	seed, err := mediaPlaylistOf(message.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if message.Payload.Segment == nil {
+		return nil, ErrNoSegment
+	}
+	part := message.Payload.Part
+	if part == nil {
+		return nil, ErrNoPart
+	}
+
+	upload := &mediaUpload{Seed: seed}
+	if !part.Gap {
+		upload.Data, err = decodeMedia(part.Data)
+		if err != nil {
+			return nil, err
+		}
+		upload.Media, _, err = parseMediaOf(seed, message.Payload, upload.Data, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	upload.Init, err = parseInit(seed, message.Payload)
+	if err != nil {
+		return nil, err
+	}
+	err = i.checkStoredSegment(ctx, seed, message.Payload.Segment.Sequence, upload.Media)
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// storePart stores a part validated by preparePart and appends it to its media playlist.
+func (i *Ingester) storePart(ctx context.Context, message *signals.DataGeneralShape, upload *mediaUpload) error {
+	seed, media := upload.Seed, upload.Media
+	segment, part := message.Payload.Segment, message.Payload.Part
+	if upload.Data != nil {
+		err := i.Repository.SetMedia(ctx, part.CacheKey, upload.Data)
+		if err != nil {
+			return err
+		}
+	}
+	err := i.storeInit(ctx, upload)
+	if err != nil {
+		return err
+	}
+	pod, err := i.adPodOf(ctx, message.Payload)
+	if err != nil {
+		return err
+	}
+
+	var (
+		frameRate     float64
+		discrepancies []*model.Discrepancy
+		stats         map[string]int64
+		captions      []string
+	)
+	err = i.updateMediaPlaylist(ctx, seed, func(playlist *model.MediaPlaylist) error {
+		// A late part must not put a segment which already left the window back into the playlist.
+		err := checkSegment(playlist, segment.Sequence, media)
+		if err != nil {
+			return err
+		}
+		frameRate = frameRateOf(playlist, media)
+		if message.Payload.Variant != nil {
+			captions = detectCaptions(playlist, media)
+		}
+
+		discontinuity := segment.Discontinuity
+		if continuesEdge(playlist, segment.Sequence, part.Sequence) {
+			if part.Gap {
+				skipDecodeTime(playlist, part.Duration)
+			} else if followDecodeTime(playlist, media) && !discontinuity {
+				log.Printf("decode time of part %d.%d of %s does not continue, inserting a discontinuity", segment.Sequence, part.Sequence, playlist.CacheKey)
+				discontinuity = true
+			}
+		}
+
+		stored := playlist.UpsertSegment(&model.Segment{
+			Id:              segment.Id.String(),
+			Sequence:        segment.Sequence,
+			Discontinuity:   discontinuity,
+			ProgramDateTime: segment.ProgramDateTime.Time,
+			InitCacheKey:    playlist.InitCacheKey,
+			CacheKey:        segment.CacheKey,
+		})
+		if part.Sequence == 0 {
+			markDecodeTime(playlist, stored, media)
+		}
+		if len(stored.Parts) == 0 && !stored.Complete {
+			stored.Held = holdsParts(playlist, segmentIndex(playlist, stored), pod)
+		}
+		upserted := &model.Part{
+			Id:          part.Id.String(),
+			Sequence:    part.Sequence,
+			Duration:    part.Duration,
+			Independent: part.Independent,
+			Gap:         part.Gap,
+			CacheKey:    part.CacheKey,
+		}
+		discrepancies = checkPart(playlist, segment.Sequence, upserted, media, i.CorrectParts)
+		if existing := stored.Part(part.Sequence); part.Gap && (existing == nil || !existing.Gap) {
+			stats = map[string]int64{model.StatGapParts: 1}
+		}
+		stored.UpsertPart(upserted)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = i.Repository.AddDiscrepancies(ctx, seed.PlaylistId, discrepancies)
+	if err != nil {
+		return err
+	}
+	err = i.Repository.IncrStreamStats(ctx, seed.PlaylistId, stats)
+	if err != nil {
+		return err
+	}
+
+	applyFrameRate(message.Payload, frameRate)
+	err = i.UpdateMultivariantPlaylist(ctx, message)
+	if err != nil || len(captions) == 0 {
+		return err
+	}
+	return i.registerCaptions(ctx, message.Payload, captions)
+}
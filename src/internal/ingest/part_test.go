@@ -0,0 +1,269 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testPlaylistId = "932ac3aa-b11f-11ed-afa1-0242ac120002"
+	testVariantId  = "a3e4e680-b11f-11ed-afa1-0242ac120002"
+	testSegmentId  = "a8652304-b120-11ed-afa1-0242ac120002"
+	testMapId      = "c9258c1e-b120-11ed-afa1-0242ac120002"
+)
+
+func newTestIngester(t *testing.T) (*Ingester, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewIngester(repository.NewStreamRepository(client), redlock.New(client)), server
+}
+
+// testInit and testFragment wrap the label into a valid initialization section and chunk,
+// which keeps the uploads of a test distinguishable.
+func testInit(label string) []byte {
+	init := isobmfftest.Init(isobmfftest.Track{Id: 1, Timescale: 90000, HandlerType: "vide"})
+	return append(init, isobmfftest.Box("free", []byte(label))...)
+}
+
+func testFragment(label string) []byte {
+	return isobmfftest.Fragment{Sequence: 1, TrackId: 1, Data: []byte(label)}.Bytes()
+}
+
+func newTestPartMessage(t *testing.T, partId string, sequence int, gap bool, withMap bool) *signals.DataGeneralShape {
+	segmentMap := ""
+	if withMap {
+		segmentMap = fmt.Sprintf(`"map": {"id": %q, "data": %q},`, testMapId, base64.StdEncoding.EncodeToString(testInit("init "+partId)))
+	}
+
+	body := fmt.Sprintf(`
+{
+	"action": "updatePart",
+	"version": 1,
+	"id": "6d2325da-b11f-11ed-afa1-0242ac120002",
+	"timestamp": 1676898433,
+	"numbytes": 2048,
+	"payload": {
+		"playlist": {"id": %q, "version": 1},
+		"variant": {
+			"id": %q,
+			"codecs": "avc1.4dc00d,mp4a.40.2",
+			"bandwidth": 2048,
+			"targetDuration": 4,
+			"targetPartDuration": 1.0
+		},
+		"segment": {
+			"id": %q,
+			"sequence": 3,
+			"programDateTime": 1676898433,
+			%s
+			"discontinuity": true
+		},
+		"part": {
+			"id": %q,
+			"sequence": %d,
+			"duration": 1.0,
+			"independent": %t,
+			"gap": %t,
+			"data": %q
+		}
+	}
+}`, testPlaylistId, testVariantId, testSegmentId, segmentMap, partId, sequence, sequence == 0, gap,
+		base64.StdEncoding.EncodeToString(testFragment("part "+partId)))
+
+	message, err := signals.NewDataMessage(body, false)
+	require.NoError(t, err)
+	return message
+}
+
+func TestUpdatePart(t *testing.T) {
+	ctx := context.Background()
+	ingester, server := newTestIngester(t)
+	repo := ingester.Repository
+
+	cases := []struct {
+		PartId  string
+		Gap     bool
+		WithMap bool
+	}{
+		{PartId: "d9c836d4-b120-11ed-afa1-0242ac120002", WithMap: true},
+		{PartId: "0b1b7e5e-b121-11ed-afa1-0242ac120002"},
+		{PartId: "1e4f0f52-b121-11ed-afa1-0242ac120002", Gap: true},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			message := newTestPartMessage(t, c.PartId, i, c.Gap, c.WithMap)
+			require.NoError(t, ingester.UpdatePart(ctx, message))
+
+			data, err := repo.GetMedia(ctx, testPlaylistId+"/"+c.PartId)
+			if c.Gap {
+				assert.ErrorIs(t, err, repository.ErrMediaNotFound)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, testFragment("part "+c.PartId), data)
+			}
+
+			ack := signals.NewAck(message, 10)
+			assert.Equal(t, signals.DataActionAckPart, ack.Action)
+			assert.Empty(t, ack.Payload.Part.Data)
+			assert.NotEmpty(t, message.Payload.Part.Data)
+		})
+	}
+
+	init, err := repo.GetMedia(ctx, testPlaylistId+"/"+testMapId)
+	require.NoError(t, err)
+	assert.Equal(t, testInit("init "+cases[0].PartId), init)
+
+	playlist, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.Equal(t, 4, playlist.TargetDuration)
+	assert.Equal(t, testPlaylistId+"/"+testMapId, playlist.InitCacheKey)
+	require.Len(t, playlist.Segments, 1)
+
+	segment := playlist.Segments[0]
+	assert.Equal(t, 3, segment.Sequence)
+	assert.True(t, segment.Discontinuity)
+	require.Len(t, segment.Parts, len(cases))
+	for i, c := range cases {
+		assert.Equal(t, i, segment.Parts[i].Sequence)
+		assert.Equal(t, c.Gap, segment.Parts[i].Gap)
+		assert.Equal(t, i == 0, segment.Parts[i].Independent)
+	}
+
+	assert.True(t, server.Exists("mediaplaylist:"+testPlaylistId+"/"+testVariantId))
+
+	reports, err := repo.GetRenditionReports(ctx, testPlaylistId)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, &model.RenditionReport{
+		CacheKey: testPlaylistId + "/" + testVariantId,
+		LastMsn:  3,
+		LastPart: len(cases) - 1,
+	}, reports[0])
+}
+
+func TestUpdatePartRejectsInvalidMessages(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 0, false, true)))
+
+	invalidData := newTestPartMessage(t, "d9c836d4-b120-11ed-afa1-0242ac120002", 0, false, false)
+	invalidData.Payload.Part.Data = "not base64!"
+
+	malformedPart := newTestPartMessage(t, "d9c836d4-b120-11ed-afa1-0242ac120002", 0, false, false)
+	malformedPart.Payload.Part.Data = base64.StdEncoding.EncodeToString([]byte("part"))
+
+	malformedInit := newTestPartMessage(t, "d9c836d4-b120-11ed-afa1-0242ac120002", 0, false, true)
+	malformedInit.Payload.Segment.Map.Data = base64.StdEncoding.EncodeToString(testFragment("init"))
+
+	unknownTrack := newTestPartMessage(t, "d9c836d4-b120-11ed-afa1-0242ac120002", 1, false, false)
+	unknownTrack.Payload.Part.Data = base64.StdEncoding.EncodeToString(
+		isobmfftest.Fragment{Sequence: 2, TrackId: 2, Data: []byte("part")}.Bytes())
+
+	noOwner := newTestPartMessage(t, "d9c836d4-b120-11ed-afa1-0242ac120002", 0, false, false)
+	noOwner.Payload.Variant = nil
+
+	noPart := newTestPartMessage(t, "d9c836d4-b120-11ed-afa1-0242ac120002", 0, false, false)
+	noPart.Payload.Part = nil
+
+	cases := []struct {
+		Message  *signals.DataGeneralShape
+		Expected error
+	}{
+		{Message: invalidData, Expected: ErrInvalidMediaData},
+		{Message: malformedPart, Expected: ErrMalformedMedia},
+		{Message: malformedInit, Expected: ErrMalformedInit},
+		{Message: unknownTrack, Expected: ErrUnknownTrack},
+		{Message: noOwner, Expected: ErrNoMediaPlaylist},
+		{Message: noPart, Expected: ErrNoPart},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			assert.ErrorIs(t, ingester.UpdatePart(ctx, c.Message), c.Expected)
+		})
+	}
+}
+
+func TestUpdatePartConcurrently(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	const parts = 8
+	wg := sync.WaitGroup{}
+	for i := 0; i < parts; i++ {
+		message := newTestPartMessage(t, uuid.NewString(), i, false, i == 0)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, ingester.UpdatePart(ctx, message))
+		}()
+	}
+	wg.Wait()
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, playlist.Segments, 1)
+	assert.Len(t, playlist.Segments[0].Parts, parts)
+	assert.Positive(t, playlist.FenceToken)
+}
+
+func TestUpdateMediaPlaylistRejectsStaleLockHolder(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 0, false, true)))
+
+	seed, err := mediaPlaylistOf(newTestPartMessage(t, uuid.NewString(), 1, false, false).Payload)
+	require.NoError(t, err)
+	var token int64
+	err = ingester.updateMediaPlaylist(ctx, seed, func(playlist *model.MediaPlaylist) error {
+		// A later lock holder stores the playlist while this update still runs.
+		later := *playlist
+		token = time.Now().Add(time.Hour).UnixMicro()
+		later.FenceToken = token
+		return ingester.Repository.SetMediaPlaylist(ctx, &later)
+	})
+	assert.ErrorIs(t, err, redlock.ErrLockLost)
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.Equal(t, token, playlist.FenceToken)
+}
+
+func TestUpdatePartRejectionStoresNothing(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	repo := ingester.Repository
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 0, false, true)))
+	init, err := repo.GetMedia(ctx, testPlaylistId+"/"+testMapId)
+	require.NoError(t, err)
+
+	for _, withMap := range []bool{false, true} {
+		unknownTrack := newTestPartMessage(t, uuid.NewString(), 1, false, withMap)
+		unknownTrack.Payload.Part.Data = base64.StdEncoding.EncodeToString(
+			isobmfftest.Fragment{Sequence: 2, TrackId: 2, Data: []byte("part")}.Bytes())
+		assert.ErrorIs(t, ingester.UpdatePart(ctx, unknownTrack), ErrUnknownTrack)
+
+		_, err = repo.GetMedia(ctx, unknownTrack.Payload.Part.CacheKey)
+		assert.ErrorIs(t, err, repository.ErrMediaNotFound)
+		stored, err := repo.GetMedia(ctx, testPlaylistId+"/"+testMapId)
+		require.NoError(t, err)
+		assert.Equal(t, init, stored)
+	}
+}
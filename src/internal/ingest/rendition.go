@@ -0,0 +1,148 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/captions"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"strconv"
+)
+
+var (
+	ErrNoRendition      = fmt.Errorf("%d: payload carries no rendition", 400)
+	ErrInvalidRendition = fmt.Errorf("%d: invalid rendition", 400)
+)
+
+// UpdateRendition registers an alternate rendition under its master playlist and group, and stores its
+// initialization section. Closed captions are carried within the video, so they register without media.
+// The registration is validated before any media of the rendition is stored.
+func (i *Ingester) UpdateRendition(ctx context.Context, message *signals.DataGeneralShape) error {
+	if message.Payload == nil || message.Payload.Rendition == nil {
+		return ErrNoRendition
+	}
+	rendition := message.Payload.Rendition
+	switch rendition.Type {
+	case signals.DataRenditionTypeVideo, signals.DataRenditionTypeAudio, signals.DataRenditionTypeSubtitles, signals.DataRenditionTypeClosedCaptions:
+	default:
+		return fmt.Errorf("%w: type %q", ErrInvalidRendition, rendition.Type)
+	}
+	if rendition.GroupId == uuid.Nil || rendition.Name == "" {
+		return fmt.Errorf("%w: group id and name are required", ErrInvalidRendition)
+	}
+	if rendition.Type == signals.DataRenditionTypeClosedCaptions && !captions.IsInstreamId(rendition.InstreamId) {
+		return fmt.Errorf("%w: instream id %q", ErrInvalidRendition, rendition.InstreamId)
+	}
+
+	payload := *message.Payload
+	payload.Variant = nil
+	if rendition.Type == signals.DataRenditionTypeClosedCaptions {
+		return i.registerMedia(ctx, &payload, true)
+	}
+
+	seed, err := renditionPlaylistOf(&payload)
+	if err != nil {
+		return err
+	}
+	init, err := parseInit(seed, &payload)
+	if err != nil {
+		return err
+	}
+	err = i.checkMediaPlaylist(ctx, seed)
+	if err != nil {
+		return err
+	}
+
+	err = i.registerMedia(ctx, &payload, true)
+	if err != nil {
+		return err
+	}
+
+	if init != nil {
+		err = i.Repository.SetMedia(ctx, seed.InitCacheKey, init)
+		if err != nil {
+			return err
+		}
+	}
+
+	return i.updateMediaPlaylist(ctx, seed, func(playlist *model.MediaPlaylist) error {
+		return nil
+	})
+}
+
+// registerRendition adds the rendition to its group. A group holds renditions of one type with at most
+// one default; taking over the default from another rendition of the group requires a version bump.
+func registerRendition(playlist *model.MultivariantPlaylist, rendition *model.Rendition, bumped bool, strict bool) (bool, error) {
+	for _, member := range playlist.Renditions {
+		if member.GroupId == rendition.GroupId && member.Id != rendition.Id && member.Type != rendition.Type {
+			return false, fmt.Errorf("%w: group %s holds %s renditions, not %s", ErrInvalidRendition, rendition.GroupId, member.Type, rendition.Type)
+		}
+	}
+
+	existing := playlist.Rendition(rendition.Id)
+	if existing != nil && !bumped {
+		filled := existing.Codecs == "" && rendition.Codecs != ""
+		if filled {
+			existing.Codecs = rendition.Codecs
+		}
+		if strict {
+			return filled, compareRenditions(existing, rendition)
+		}
+		return filled, nil
+	}
+
+	if rendition.IsDefault {
+		for _, member := range playlist.Renditions {
+			if member.GroupId != rendition.GroupId || member.Id == rendition.Id || !member.IsDefault {
+				continue
+			}
+			switch {
+			case bumped:
+				member.IsDefault = false
+			case strict:
+				return false, &IncompatibleChangeError{
+					Id:        rendition.GroupId,
+					Field:     "default",
+					Current:   member.Id,
+					Requested: rendition.Id,
+				}
+			default:
+				rendition.IsDefault = false
+			}
+		}
+	}
+
+	if existing == nil {
+		playlist.Renditions = append(playlist.Renditions, rendition)
+	} else {
+		*existing = *rendition
+	}
+	return true, nil
+}
+
+func compareRenditions(current, requested *model.Rendition) error {
+	fields := []struct {
+		Name      string
+		Current   string
+		Requested string
+	}{
+		{Name: "type", Current: current.Type, Requested: requested.Type},
+		{Name: "groupId", Current: current.GroupId, Requested: requested.GroupId},
+		{Name: "name", Current: current.Name, Requested: requested.Name},
+		{Name: "language", Current: current.Language, Requested: requested.Language},
+		{Name: "isDefault", Current: strconv.FormatBool(current.IsDefault), Requested: strconv.FormatBool(requested.IsDefault)},
+		{Name: "autoSelect", Current: strconv.FormatBool(current.AutoSelect), Requested: strconv.FormatBool(requested.AutoSelect)},
+	}
+	for _, field := range fields {
+		if field.Current != field.Requested {
+			return &IncompatibleChangeError{
+				Id:        current.Id,
+				Field:     field.Name,
+				Current:   field.Current,
+				Requested: field.Requested,
+			}
+		}
+	}
+	return nil
+}
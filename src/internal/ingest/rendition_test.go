@@ -0,0 +1,104 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+const testGroupId = "dc5daa10-b11f-11ed-afa1-0242ac120002"
+
+func newTestRenditionMessage(t *testing.T, version int, renditionId string, renditionType signals.DataRenditionType, groupId string, isDefault bool) *signals.DataGeneralShape {
+	message := newTestPartMessage(t, uuid.NewString(), 0, false, true)
+	message.Action = signals.DataActionUpdateRendition
+	message.Payload.Part = nil
+	message.Payload.Playlist.Version = version
+	message.Payload.Rendition = &signals.DataGeneralShapePayloadRendition{
+		Id:                 uuid.MustParse(renditionId),
+		Type:               renditionType,
+		GroupId:            uuid.MustParse(groupId),
+		Name:               "audio-" + renditionId[:4],
+		Language:           "en",
+		IsDefault:          isDefault,
+		AutoSelect:         true,
+		TargetDuration:     4,
+		TargetPartDuration: 1,
+		CacheKey:           testPlaylistId + "/" + renditionId,
+		InitCacheKey:       message.Payload.Variant.InitCacheKey,
+	}
+	return message
+}
+
+func TestUpdateRendition(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	english := "d02288ec-b11f-11ed-afa1-0242ac120002"
+	german := "7a3f5d1e-b122-11ed-afa1-0242ac120002"
+	captions := "5b1e0f3c-b123-11ed-afa1-0242ac120002"
+	closedCaptions := func(instreamId string) *signals.DataGeneralShape {
+		message := newTestRenditionMessage(t, 2, captions, signals.DataRenditionTypeClosedCaptions, uuid.NewString(), true)
+		message.Payload.Rendition.InstreamId = instreamId
+		return message
+	}
+
+	cases := []struct {
+		Message  *signals.DataGeneralShape
+		Expected *IncompatibleChangeError
+		Err      error
+	}{
+		{Message: newTestRenditionMessage(t, 1, english, signals.DataRenditionTypeAudio, testGroupId, true)},
+		{Message: newTestRenditionMessage(t, 1, english, signals.DataRenditionTypeAudio, testGroupId, true)},
+		{
+			Message:  newTestRenditionMessage(t, 1, german, signals.DataRenditionTypeAudio, testGroupId, true),
+			Expected: &IncompatibleChangeError{Id: testGroupId, Field: "default", Current: english, Requested: german},
+		},
+		{Message: newTestRenditionMessage(t, 1, german, signals.DataRenditionTypeAudio, testGroupId, false)},
+		{
+			Message:  newTestRenditionMessage(t, 1, german, signals.DataRenditionTypeAudio, testGroupId, true),
+			Expected: &IncompatibleChangeError{Id: german, Field: "isDefault", Current: "false", Requested: "true"},
+		},
+		{Message: newTestRenditionMessage(t, 1, captions, signals.DataRenditionTypeSubtitles, testGroupId, false), Err: ErrInvalidRendition},
+		{Message: newTestRenditionMessage(t, 1, captions, "video", testGroupId, false), Err: ErrInvalidRendition},
+		{Message: newTestRenditionMessage(t, 2, german, signals.DataRenditionTypeAudio, testGroupId, true)},
+		{Message: closedCaptions("CC5"), Err: ErrInvalidRendition},
+		{Message: closedCaptions("CC1")},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			err := ingester.UpdateRendition(ctx, c.Message)
+			switch {
+			case c.Expected != nil:
+				var changeErr *IncompatibleChangeError
+				require.True(t, errors.As(err, &changeErr))
+				assert.Equal(t, c.Expected, changeErr)
+			case c.Err != nil:
+				assert.ErrorIs(t, err, c.Err)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	playlist, err := ingester.Repository.GetMultivariantPlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	assert.Empty(t, playlist.Variants)
+	require.Len(t, playlist.Renditions, 3)
+	assert.False(t, playlist.Rendition(english).IsDefault)
+	assert.True(t, playlist.Rendition(german).IsDefault)
+	assert.Equal(t, "CLOSED-CAPTIONS", playlist.Rendition(captions).Type)
+	assert.Equal(t, "CC1", playlist.Rendition(captions).InstreamId)
+
+	media, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+english)
+	require.NoError(t, err)
+	assert.Equal(t, "audio/mp4", media.MimeType)
+
+	_, err = ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+captions)
+	assert.Error(t, err)
+}
@@ -0,0 +1,48 @@
+package ingest
+
+import (
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/playlist"
+	"math"
+	"time"
+)
+
+// retain slides the media playlist along its live window and returns the time to live of the media
+// it no longer advertises: the parts of complete segments which left the part window and the segments
+// which left the live window along with their parts.
+// Date ranges which ended before the first segment left are dropped along with the segments.
+// Evicted media stays available for its own duration plus the duration of the playlist, as clients
+// may still hold a playlist which lists it.
+func (i *Ingester) retain(media *model.MediaPlaylist) map[string]time.Duration {
+	window := media.Window
+	if window.IsZero() {
+		window = i.Window
+	}
+
+	duration := media.Duration()
+	// The first segment may not carry its program date time once the segments before it left.
+	starts, _ := media.ProgramDateTimes()
+	removed := media.Slide(window)
+	trimmed := media.TrimParts(float64(playlist.PartWindow * media.TargetDuration))
+	if len(removed) < len(starts) && !starts[len(removed)].IsZero() {
+		media.RemoveDateRangesBefore(starts[len(removed)])
+	}
+
+	expirations := map[string]time.Duration{}
+	for _, segment := range removed {
+		if !segment.Gap {
+			expirations[segment.CacheKey] = gracePeriod(segment.Duration, duration)
+		}
+		trimmed = append(trimmed, segment.Parts...)
+	}
+	for _, part := range trimmed {
+		if !part.Gap {
+			expirations[part.CacheKey] = gracePeriod(part.Duration, duration)
+		}
+	}
+	return expirations
+}
+
+func gracePeriod(mediaDuration, playlistDuration float64) time.Duration {
+	return time.Duration(math.Ceil(mediaDuration+playlistDuration)) * time.Second
+}
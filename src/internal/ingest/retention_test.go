@@ -0,0 +1,117 @@
+package ingest
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func TestUpdateSegmentSlidesWindow(t *testing.T) {
+	ctx := context.Background()
+	ingester, server := newTestIngester(t)
+	ingester.Window = model.Window{Segments: 4}
+
+	var parts []string
+	for i := 0; i < 3; i++ {
+		partId := uuid.NewString()
+		require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, partId, i, false, i == 0)))
+		parts = append(parts, testPlaylistId+"/"+partId)
+	}
+	first := newTestSegmentMessage(t, 3, nil)
+	require.NoError(t, ingester.UpdateSegment(ctx, first))
+
+	var segments []string
+	for sequence := 4; sequence < 10; sequence++ {
+		message := newTestSegmentMessage(t, sequence, []byte("segment "+strconv.Itoa(sequence)))
+		require.NoError(t, ingester.UpdateSegment(ctx, message))
+		segments = append(segments, message.Payload.Segment.CacheKey)
+	}
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, playlist.Segments, 4)
+	assert.Equal(t, 6, playlist.MediaSequence)
+	assert.Equal(t, 6, playlist.Segments[0].Sequence)
+
+	assert.Positive(t, server.TTL(first.Payload.Segment.CacheKey))
+	for _, part := range parts {
+		assert.Positive(t, server.TTL(part))
+	}
+	for i, segment := range segments {
+		if i < 2 {
+			assert.Positive(t, server.TTL(segment))
+		} else {
+			assert.Zero(t, server.TTL(segment))
+		}
+	}
+}
+
+func TestUpdateSegmentEvictsPartsOutsidePartWindow(t *testing.T) {
+	ctx := context.Background()
+	ingester, server := newTestIngester(t)
+
+	partId := uuid.NewString()
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, partId, 0, false, true)))
+	first := newTestSegmentMessage(t, 3, nil)
+	require.NoError(t, ingester.UpdateSegment(ctx, first))
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSegmentMessage(t, 4, []byte("segment 4"))))
+	assert.Zero(t, server.TTL(testPlaylistId+"/"+partId))
+
+	for sequence := 5; sequence < 7; sequence++ {
+		require.NoError(t, ingester.UpdateSegment(ctx, newTestSegmentMessage(t, sequence, []byte("segment"))))
+	}
+	assert.Positive(t, server.TTL(testPlaylistId+"/"+partId))
+	assert.Zero(t, server.TTL(first.Payload.Segment.CacheKey))
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, playlist.Segments, 4)
+	assert.Equal(t, 3, playlist.MediaSequence)
+	assert.Empty(t, playlist.Segments[0].Parts)
+}
+
+func TestUpdatePartRejectsPartsOfEvictedSegments(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	ingester.Window = model.Window{Segments: 4}
+
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 0, false, true)))
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSegmentMessage(t, 3, nil)))
+	for sequence := 4; sequence < 10; sequence++ {
+		require.NoError(t, ingester.UpdateSegment(ctx, newTestSegmentMessage(t, sequence, []byte("segment"))))
+	}
+
+	// A retried part of a segment which left the window neither comes back nor stops the window from sliding.
+	partId := uuid.NewString()
+	assert.ErrorIs(t, ingester.UpdatePart(ctx, newTestPartMessage(t, partId, 1, false, false)), ErrStaleSegment)
+	_, err := ingester.Repository.GetMedia(ctx, testPlaylistId+"/"+partId)
+	assert.ErrorIs(t, err, repository.ErrMediaNotFound)
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSegmentMessage(t, 10, []byte("segment"))))
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, playlist.Segments, 4)
+	assert.Equal(t, 7, playlist.MediaSequence)
+}
+
+func TestUpdateSegmentPrefersPublisherWindow(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	for sequence := 3; sequence < 13; sequence++ {
+		message := newTestSegmentMessage(t, sequence, []byte("segment"))
+		message.Payload.Variant.WindowDuration = 20
+		require.NoError(t, ingester.UpdateSegment(ctx, message))
+	}
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.Equal(t, model.Window{Duration: 20}, playlist.Window)
+	require.Len(t, playlist.Segments, 5)
+	assert.Equal(t, 8, playlist.MediaSequence)
+}
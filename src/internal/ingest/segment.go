@@ -0,0 +1,279 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/sehovizko/mobworx-streamer/src/internal/webvtt"
+	"log"
+	"time"
+)
+
+var (
+	ErrNoSegmentData     = fmt.Errorf("%d: segment carries no data, has no cached parts and is no gap", 400)
+	ErrMissingPart       = fmt.Errorf("%d: part of the segment is missing", 409)
+	ErrSegmentMismatch   = fmt.Errorf("%d: segment data does not match its parts", 409)
+	ErrStaleSegment      = fmt.Errorf("%d: segment already left the playlist", 409)
+	ErrNoSegmentDuration = fmt.Errorf("%d: segment has no duration", 400)
+)
+
+// UpdateSegment closes out a segment of the variant or rendition. The segment data is either sent whole
+// or assembled from the parts cached by UpdatePart; when both are available they have to match.
+// Completing a segment slides the playlist along its live window and evicts the media it no longer lists.
+// A whole segment whose decode time does not continue the previous media is marked as a discontinuity.
+// A segment without data whose parts are all gaps, or which the publisher declares a gap, is listed as a gap.
+// Subtitle renditions may send plain WebVTT segments, whose cues have to line up with the video.
+// Segments of a variant let the live captions of the master playlist catch up with the video.
+// Segments of a variant and of the audio renditions it plays are replaced by the segments of registered ads
+// while they start within an ad break, see spliceAd.
+func (i *Ingester) UpdateSegment(ctx context.Context, message *signals.DataGeneralShape) error {
+	upload, err := i.prepareSegment(ctx, message)
+	if err != nil {
+		return err
+	}
+	return i.storeSegment(ctx, message, upload)
+}
+
+// prepareSegment validates the segment and the initialization section of an updateSegment message against the
+// stored playlist without storing either. UpdateSegment repeats the checks under the lock of the playlist.
+func (i *Ingester) prepareSegment(ctx context.Context, message *signals.DataGeneralShape) (*mediaUpload, error) {
+	seed, err := mediaPlaylistOf(message.Payload)
+	if err != nil {
+		return nil, err
+	}
+	segment := message.Payload.Segment
+	if segment == nil {
+		return nil, ErrNoSegment
+	}
+	if segment.Duration <= 0 {
+		return nil, ErrNoSegmentDuration
+	}
+
+	upload := &mediaUpload{Seed: seed}
+	if segment.Data != "" {
+		upload.Data, err = decodeMedia(segment.Data)
+		if err != nil {
+			return nil, err
+		}
+		var subtitles *webvtt.File
+		upload.Media, subtitles, err = parseMediaOf(seed, message.Payload, upload.Data, true)
+		if err != nil {
+			return nil, err
+		}
+		err = i.checkSubtitleTimeline(ctx, message.Payload, subtitles)
+		if err != nil {
+			return nil, err
+		}
+	}
+	upload.Init, err = parseInit(seed, message.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := i.Repository.GetMediaPlaylist(ctx, seed.CacheKey)
+	if errors.Is(err, repository.ErrMediaPlaylistNotFound) {
+		playlist = seed
+	} else if err != nil {
+		return nil, err
+	}
+	if len(seed.Tracks) > 0 {
+		playlist.Tracks = seed.Tracks
+	}
+	err = checkSegment(playlist, segment.Sequence, upload.Media)
+	if err != nil {
+		return nil, err
+	}
+	stored := playlist.Segment(segment.Sequence)
+	if stored == nil {
+		stored = &model.Segment{Sequence: segment.Sequence}
+	}
+	_, _, err = i.segmentData(ctx, stored, upload.Data, segment.Gap)
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// storeSegment stores a segment validated by prepareSegment and completes it in its media playlist.
+func (i *Ingester) storeSegment(ctx context.Context, message *signals.DataGeneralShape, upload *mediaUpload) error {
+	seed, media := upload.Seed, upload.Media
+	segment := message.Payload.Segment
+	err := i.storeInit(ctx, upload)
+	if err != nil {
+		return err
+	}
+	pod, err := i.adPodOf(ctx, message.Payload)
+	if err != nil {
+		return err
+	}
+	var ad *adSplice
+	if len(pod) > 0 {
+		ad, err = i.prepareAd(ctx, seed, segment, pod)
+		if err != nil {
+			return err
+		}
+	}
+
+	var expirations map[string]time.Duration
+	var frameRate float64
+	var stats map[string]int64
+	var captions []string
+	err = i.updateMediaPlaylist(ctx, seed, func(playlist *model.MediaPlaylist) error {
+		err := checkSegment(playlist, segment.Sequence, media)
+		if err != nil {
+			return err
+		}
+		frameRate = frameRateOf(playlist, media)
+		if message.Payload.Variant != nil {
+			captions = detectCaptions(playlist, media)
+		}
+
+		discontinuity := segment.Discontinuity
+		edge := continuesEdge(playlist, segment.Sequence, -1)
+		if edge && followDecodeTime(playlist, media) && !discontinuity {
+			log.Printf("decode time of segment %d of %s does not continue, inserting a discontinuity", segment.Sequence, playlist.CacheKey)
+			discontinuity = true
+		}
+
+		stored := playlist.UpsertSegment(&model.Segment{
+			Id:            segment.Id.String(),
+			Sequence:      segment.Sequence,
+			Discontinuity: discontinuity,
+			InitCacheKey:  playlist.InitCacheKey,
+			CacheKey:      segment.CacheKey,
+		})
+		markDecodeTime(playlist, stored, media)
+
+		data, gap, err := i.segmentData(ctx, stored, upload.Data, segment.Gap)
+		if err != nil {
+			return err
+		}
+
+		if gap {
+			if edge && len(stored.Parts) == 0 {
+				skipDecodeTime(playlist, segment.Duration)
+			}
+			if !stored.Complete {
+				stats = map[string]int64{model.StatGapSegments: 1}
+			}
+		} else {
+			err = i.Repository.SetMedia(ctx, segment.CacheKey, data)
+			if err != nil {
+				return err
+			}
+		}
+
+		stored.Id = segment.Id.String()
+		stored.CacheKey = segment.CacheKey
+		stored.Duration = segment.Duration
+		if !segment.ProgramDateTime.IsZero() {
+			stored.ProgramDateTime = segment.ProgramDateTime.Time
+		}
+		stored.Complete = true
+		stored.Gap = gap
+		var replaced map[string]time.Duration
+		if len(pod) > 0 {
+			replaced = spliceAd(playlist, stored, pod, ad)
+		}
+		// The parts of a segment which stays live are released along with it.
+		stored.Held = false
+		expirations = i.retain(playlist)
+		for cacheKey, expiration := range replaced {
+			expirations[cacheKey] = expiration
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = i.Repository.IncrStreamStats(ctx, seed.PlaylistId, stats)
+	if err != nil {
+		return err
+	}
+
+	applyFrameRate(message.Payload, frameRate)
+	err = i.UpdateMultivariantPlaylist(ctx, message)
+	if err != nil {
+		return err
+	}
+	if len(captions) > 0 {
+		err = i.registerCaptions(ctx, message.Payload, captions)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The media is evicted only once the playlist which no longer lists it is stored.
+	err = i.Repository.ExpireMedia(ctx, expirations)
+	if err != nil || message.Payload.Variant == nil {
+		return err
+	}
+	return i.publishCaptions(ctx, message.Payload.Playlist.Id)
+}
+
+// checkSegment verifies the segment is still listed by the playlist and its media only carries tracks of the playlist.
+func checkSegment(playlist *model.MediaPlaylist, sequence int, media *isobmff.Media) error {
+	if len(playlist.Segments) > 0 && sequence < playlist.Segments[0].Sequence {
+		return fmt.Errorf("%w: sequence %d", ErrStaleSegment, sequence)
+	}
+	return checkTracks(playlist, media)
+}
+
+// segmentData returns the data of the segment, sent whole or assembled from its cached parts, and whether it is
+// listed as a gap. The data sent has to match the parts when both are available.
+func (i *Ingester) segmentData(ctx context.Context, segment *model.Segment, data []byte, declaredGap bool) ([]byte, bool, error) {
+	assembled, err := i.assembleParts(ctx, segment)
+	if err != nil {
+		return nil, false, err
+	}
+	switch {
+	case data == nil && assembled == nil:
+		if !declaredGap && !segment.GapsOnly() {
+			return nil, false, ErrNoSegmentData
+		}
+		return nil, true, nil
+	case data == nil:
+		return assembled, false, nil
+	case assembled != nil && !bytes.Equal(data, assembled):
+		return nil, false, fmt.Errorf("%w: %d bytes sent, %d bytes assembled", ErrSegmentMismatch, len(data), len(assembled))
+	}
+	return data, false, nil
+}
+
+// assembleParts concatenates the cached data of the parts of the segment, or returns nil when it has none.
+func (i *Ingester) assembleParts(ctx context.Context, segment *model.Segment) ([]byte, error) {
+	if len(segment.Parts) == 0 {
+		return nil, nil
+	}
+
+	for index, part := range segment.Parts {
+		if part.Sequence != index {
+			return nil, fmt.Errorf("%w: part %d of segment %d", ErrMissingPart, index, segment.Sequence)
+		}
+	}
+
+	assembled := &bytes.Buffer{}
+	for _, part := range segment.Parts {
+		if part.Gap {
+			continue
+		}
+		data, err := i.Repository.GetMedia(ctx, part.CacheKey)
+		if errors.Is(err, repository.ErrMediaNotFound) {
+			return nil, fmt.Errorf("%w: part %d of segment %d", ErrMissingPart, part.Sequence, segment.Sequence)
+		}
+		if err != nil {
+			return nil, err
+		}
+		assembled.Write(data)
+	}
+	if assembled.Len() == 0 {
+		return nil, nil
+	}
+	return assembled.Bytes(), nil
+}
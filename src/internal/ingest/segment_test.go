@@ -0,0 +1,140 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func newTestSegmentMessage(t *testing.T, sequence int, data []byte) *signals.DataGeneralShape {
+	message := newTestPartMessage(t, uuid.NewString(), 0, false, false)
+	message.Action = signals.DataActionUpdateSegment
+	message.Payload.Part = nil
+	message.Payload.Segment.Duration = 4.004
+	message.Payload.Segment.Id = uuid.New()
+	message.Payload.Segment.CacheKey = testPlaylistId + "/" + message.Payload.Segment.Id.String()
+	message.Payload.Segment.Sequence = sequence
+	if data != nil {
+		message.Payload.Segment.Data = base64.StdEncoding.EncodeToString(testFragment(string(data)))
+	}
+	return message
+}
+
+func TestUpdateSegment(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	var expected []byte
+	for i, partId := range []string{uuid.NewString(), uuid.NewString(), uuid.NewString()} {
+		require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, partId, i, false, i == 0)))
+		expected = append(expected, testFragment("part "+partId)...)
+	}
+
+	message := newTestSegmentMessage(t, 3, nil)
+	require.NoError(t, ingester.UpdateSegment(ctx, message))
+	assert.Equal(t, signals.DataActionAckSegment, signals.NewAck(message, 10).Action)
+
+	data, err := ingester.Repository.GetMedia(ctx, message.Payload.Segment.CacheKey)
+	require.NoError(t, err)
+	assert.Equal(t, expected, data)
+
+	whole := newTestSegmentMessage(t, 4, []byte("whole segment"))
+	require.NoError(t, ingester.UpdateSegment(ctx, whole))
+	data, err = ingester.Repository.GetMedia(ctx, whole.Payload.Segment.CacheKey)
+	require.NoError(t, err)
+	assert.Equal(t, testFragment("whole segment"), data)
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, playlist.Segments, 2)
+	assert.Equal(t, 3, playlist.MediaSequence)
+	for _, segment := range playlist.Segments {
+		assert.True(t, segment.Complete)
+		assert.Equal(t, 4.004, segment.Duration)
+	}
+	assert.Len(t, playlist.Segments[0].Parts, 3)
+	assert.Empty(t, playlist.Segments[1].Parts)
+}
+
+func TestUpdateSegmentRejectsInvalidSegments(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	partId := uuid.NewString()
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, partId, 0, false, true)))
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 2, false, false)))
+
+	noDuration := newTestSegmentMessage(t, 3, nil)
+	noDuration.Payload.Segment.Duration = 0
+
+	cases := []struct {
+		Message  *signals.DataGeneralShape
+		Expected error
+	}{
+		{Message: newTestSegmentMessage(t, 3, nil), Expected: ErrMissingPart},
+		{Message: newTestSegmentMessage(t, 3, []byte("part "+partId)), Expected: ErrMissingPart},
+		{Message: noDuration, Expected: ErrNoSegmentDuration},
+		{Message: newTestSegmentMessage(t, 5, nil), Expected: ErrNoSegmentData},
+		{Message: newTestSegmentMessage(t, 1, []byte("late segment")), Expected: ErrStaleSegment},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			assert.ErrorIs(t, ingester.UpdateSegment(ctx, c.Message), c.Expected)
+		})
+	}
+}
+
+func TestUpdateSegmentRejectsMismatchingData(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	partId := uuid.NewString()
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, partId, 0, false, true)))
+
+	err := ingester.UpdateSegment(ctx, newTestSegmentMessage(t, 3, []byte("something else")))
+	assert.ErrorIs(t, err, ErrSegmentMismatch)
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSegmentMessage(t, 3, []byte("part "+partId))))
+}
+
+func TestUpdateSegmentListsGaps(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 0, true, true)))
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 1, true, false)))
+	// A retried gap part is counted once.
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 1, true, false)))
+	gapped := newTestSegmentMessage(t, 3, nil)
+	require.NoError(t, ingester.UpdateSegment(ctx, gapped))
+	require.NoError(t, ingester.UpdateSegment(ctx, gapped))
+
+	declared := newTestSegmentMessage(t, 4, nil)
+	declared.Payload.Segment.Gap = true
+	require.NoError(t, ingester.UpdateSegment(ctx, declared))
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSegmentMessage(t, 5, []byte("segment 5"))))
+
+	playlist, err := ingester.Repository.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	require.Len(t, playlist.Segments, 3)
+	assert.True(t, playlist.Segments[0].Gap)
+	assert.True(t, playlist.Segments[1].Gap)
+	assert.False(t, playlist.Segments[2].Gap)
+	for _, segment := range playlist.Segments {
+		assert.True(t, segment.Complete)
+	}
+
+	_, err = ingester.Repository.GetMedia(ctx, gapped.Payload.Segment.CacheKey)
+	assert.ErrorIs(t, err, repository.ErrMediaNotFound)
+
+	stats, err := ingester.Repository.GetStreamStats(ctx, testPlaylistId)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{model.StatGapParts: 2, model.StatGapSegments: 2}, stats)
+}
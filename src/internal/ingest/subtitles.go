@@ -0,0 +1,148 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/sehovizko/mobworx-streamer/src/internal/webvtt"
+	"math"
+)
+
+var (
+	ErrWebVTTPart       = fmt.Errorf("%d: plain WebVTT subtitles are only accepted as whole segments", 400)
+	ErrSubtitleTimeline = fmt.Errorf("%d: subtitle cues lie outside the video timeline", 400)
+)
+
+// mpegTsWrap is the number of seconds after which the 33-bit timestamps of X-TIMESTAMP-MAP wrap around.
+const mpegTsWrap = float64(1<<33) / webvtt.MpegTsClock
+
+func isSubtitles(payload *signals.DataGeneralShapePayload) bool {
+	return payload.Variant == nil && payload.Rendition != nil && payload.Rendition.Type == signals.DataRenditionTypeSubtitles
+}
+
+// parseMediaOf parses the media data of a segment, or of a part unless whole is set. Subtitle renditions may send
+// whole segments as plain WebVTT files instead of fMP4, and their media playlist takes the type of what they send.
+func parseMediaOf(seed *model.MediaPlaylist, payload *signals.DataGeneralShapePayload, data []byte, whole bool) (*isobmff.Media, *webvtt.File, error) {
+	if !isSubtitles(payload) {
+		media, err := parseMedia(data)
+		return media, nil, err
+	}
+	if !webvtt.Is(data) {
+		seed.MimeType = string(signals.MimeTypeApplication)
+		media, err := parseMedia(data)
+		return media, nil, err
+	}
+	if !whole {
+		return nil, nil, ErrWebVTTPart
+	}
+
+	subtitles, err := webvtt.Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	seed.MimeType = string(signals.MimeTypeWebVTT)
+	return nil, subtitles, nil
+}
+
+// checkSubtitleTimeline verifies that the cues of a plain WebVTT segment, placed on the media timeline by its
+// X-TIMESTAMP-MAP, fall within the media a video variant of the master playlist lists, give or take a target
+// duration. Variants of the subtitles group of the rendition are preferred. Nothing is checked before video arrives.
+func (i *Ingester) checkSubtitleTimeline(ctx context.Context, payload *signals.DataGeneralShapePayload, subtitles *webvtt.File) error {
+	if subtitles == nil || len(subtitles.Cues) == 0 {
+		return nil
+	}
+	video, err := i.subtitledVideo(ctx, payload)
+	if err != nil || video == nil {
+		return err
+	}
+
+	reference := referenceTrack(video)
+	edge := float64(video.NextDecodeTime) / float64(reference.Timescale)
+	listed := 0.0
+	for _, segment := range video.Segments {
+		listed += segment.Duration
+		if !segment.Complete {
+			for _, part := range segment.Parts {
+				listed += part.Duration
+			}
+		}
+	}
+	tolerance := float64(video.TargetDuration)
+
+	start, end := subtitles.Cues[0].Start, subtitles.Cues[0].End
+	for _, cue := range subtitles.Cues[1:] {
+		if cue.Start < start {
+			start = cue.Start
+		}
+		if cue.End > end {
+			end = cue.End
+		}
+	}
+	for _, cueTime := range []float64{subtitles.MediaTime(start), subtitles.MediaTime(end)} {
+		offset := wrapMpegTs(cueTime - edge)
+		if offset < -listed-tolerance || offset > tolerance {
+			return fmt.Errorf("%w: cue at %.3fs, video spans %.3fs up to %.3fs", ErrSubtitleTimeline, math.Mod(cueTime, mpegTsWrap), listed, math.Mod(edge, mpegTsWrap))
+		}
+	}
+	return nil
+}
+
+// subtitledVideo returns the media playlist of the variant the subtitles of the payload play along with,
+// or nil when no variant knows the decode time of its live edge yet.
+func (i *Ingester) subtitledVideo(ctx context.Context, payload *signals.DataGeneralShapePayload) (*model.MediaPlaylist, error) {
+	multivariant, err := i.Repository.GetMultivariantPlaylist(ctx, payload.Playlist.Id.String())
+	if errors.Is(err, repository.ErrMultivariantPlaylistNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	variants := make([]*model.Variant, 0, len(multivariant.Variants))
+	for _, variant := range multivariant.Variants {
+		if variant.Subtitles == payload.Rendition.GroupId.String() {
+			variants = append(variants, variant)
+		}
+	}
+	for _, variant := range multivariant.Variants {
+		if variant.Subtitles != payload.Rendition.GroupId.String() {
+			variants = append(variants, variant)
+		}
+	}
+
+	return i.timedVideo(ctx, variants)
+}
+
+// timedVideo returns the media playlist of the first of the variants which knows the decode time of its live edge,
+// or nil when none does yet.
+func (i *Ingester) timedVideo(ctx context.Context, variants []*model.Variant) (*model.MediaPlaylist, error) {
+	for _, variant := range variants {
+		video, err := i.Repository.GetMediaPlaylist(ctx, variant.CacheKey)
+		if errors.Is(err, repository.ErrMediaPlaylistNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if reference := referenceTrack(video); video.NextDecodeTime != 0 && reference != nil && reference.Timescale != 0 {
+			return video, nil
+		}
+	}
+	return nil, nil
+}
+
+// wrapMpegTs brings an offset between two media times into the half period of the MPEG-2 timestamps around zero,
+// so cues mapped by a timestamp which wrapped around compare with the unwrapped decode times of the video.
+func wrapMpegTs(offset float64) float64 {
+	offset = math.Mod(offset, mpegTsWrap)
+	if offset > mpegTsWrap/2 {
+		offset -= mpegTsWrap
+	} else if offset < -mpegTsWrap/2 {
+		offset += mpegTsWrap
+	}
+	return offset
+}
@@ -0,0 +1,100 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const (
+	testSubtitlesRenditionId = "51d7f0a4-b125-11ed-afa1-0242ac120002"
+	testSubtitlesGroupId     = "4b1c06e2-b125-11ed-afa1-0242ac120002"
+)
+
+// newTestSubtitlesMessage turns a segment message into one of the test subtitles rendition carrying the WebVTT file.
+func newTestSubtitlesMessage(t *testing.T, sequence int, vtt string) *signals.DataGeneralShape {
+	message := newTestSegmentMessage(t, sequence, nil)
+	message.Payload.Variant = nil
+	message.Payload.Rendition = &signals.DataGeneralShapePayloadRendition{
+		Id:                 uuid.MustParse(testSubtitlesRenditionId),
+		Type:               signals.DataRenditionTypeSubtitles,
+		GroupId:            uuid.MustParse(testSubtitlesGroupId),
+		Name:               "subtitles-en",
+		Language:           "en",
+		TargetDuration:     4,
+		TargetPartDuration: 1.0,
+		CacheKey:           testPlaylistId + "/" + testSubtitlesRenditionId,
+	}
+	segment := message.Payload.Segment
+	segment.Id = uuid.New()
+	segment.CacheKey = testPlaylistId + "/" + segment.Id.String()
+	segment.Map = nil
+	segment.Data = base64.StdEncoding.EncodeToString([]byte(vtt))
+	return message
+}
+
+func TestUpdateSegmentAcceptsWebVTT(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	repo := ingester.Repository
+
+	// Subtitles are taken as they come until the video knows its decode time.
+	early := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:0,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:02.000\nEarly\n"
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSubtitlesMessage(t, 2, early)))
+
+	// The video spans 10 to 14.004 seconds.
+	video := newTestSegmentMessage(t, 3, nil)
+	video.Payload.Segment.Data = timedFragment(900000, 360360)
+	video.Payload.Segment.Map = &signals.MediaInitializationSection{
+		Id:   uuid.MustParse(testMapId),
+		Data: base64.StdEncoding.EncodeToString(testInit("init")),
+	}
+	require.NoError(t, ingester.UpdateSegment(ctx, video))
+
+	vtt := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:03.000\nHello\n"
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSubtitlesMessage(t, 3, vtt)))
+
+	// The timestamp wrapped around a second before the cue times started.
+	wrapped := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:8589844592,LOCAL:00:00:00.000\n\n00:00:12.000 --> 00:00:13.500\nWrapped\n"
+	require.NoError(t, ingester.UpdateSegment(ctx, newTestSubtitlesMessage(t, 4, wrapped)))
+
+	stale := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:0,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:03.000\nStale\n"
+	assert.ErrorIs(t, ingester.UpdateSegment(ctx, newTestSubtitlesMessage(t, 5, stale)), ErrSubtitleTimeline)
+	ahead := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:10.000\n\n00:00:30.000 --> 00:00:31.000\nAhead\n"
+	assert.ErrorIs(t, ingester.UpdateSegment(ctx, newTestSubtitlesMessage(t, 5, ahead)), ErrSubtitleTimeline)
+
+	subtitles, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testSubtitlesRenditionId)
+	require.NoError(t, err)
+	assert.Equal(t, string(signals.MimeTypeWebVTT), subtitles.MimeType)
+	require.Len(t, subtitles.Segments, 3)
+	assert.True(t, subtitles.Segments[2].Complete)
+	data, err := repo.GetMedia(ctx, subtitles.Segments[1].CacheKey)
+	require.NoError(t, err)
+	assert.Equal(t, vtt, string(data))
+
+	multivariant, err := repo.GetMultivariantPlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	require.NotNil(t, multivariant.Rendition(testSubtitlesRenditionId))
+	assert.Equal(t, "SUBTITLES", multivariant.Rendition(testSubtitlesRenditionId).Type)
+}
+
+func TestUpdateSegmentRejectsMalformedWebVTT(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	malformed := "WEBVTT\n\n00:00:01 --> 00:00:03.000\nHello\n"
+	assert.ErrorContains(t, ingester.UpdateSegment(ctx, newTestSubtitlesMessage(t, 3, malformed)), "400: malformed WebVTT")
+
+	part := newTestSubtitlesMessage(t, 3, "WEBVTT\n")
+	part.Payload.Part = &signals.DataGeneralShapePayloadPart{
+		Id:       uuid.New(),
+		Duration: 1.0,
+		Data:     part.Payload.Segment.Data,
+		CacheKey: testPlaylistId + "/" + uuid.NewString(),
+	}
+	assert.ErrorIs(t, ingester.UpdatePart(ctx, part), ErrWebVTTPart)
+}
@@ -0,0 +1,133 @@
+package ingest
+
+import (
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"math"
+	"strconv"
+	"time"
+)
+
+// durationTolerance is how far a declared part duration may stray from the measured one,
+// half the precision EXT-X-PART durations are rendered with.
+const durationTolerance = 0.0005
+
+// timing is what the fragments of a part tell about its duration and independence.
+type timing struct {
+	Duration    float64
+	Independent bool
+	// Start and End are the decode times the media spans, in the timescale of the measured track.
+	Start, End uint64
+}
+
+// timingOf measures the media on the first video track of the playlist, or on its first track when it has
+// no video. The duration spans from the decode time of the first fragment of that track to the end of the
+// samples of its last one, and the media is independent when its first sample is a sync sample.
+// It reports false when the media carries no timed samples of that track.
+func timingOf(playlist *model.MediaPlaylist, media *isobmff.Media) (timing, bool) {
+	reference := referenceTrack(playlist)
+	if media == nil || reference == nil || reference.Timescale == 0 {
+		return timing{}, false
+	}
+
+	var (
+		measured   timing
+		start, end uint64
+		found      bool
+	)
+	for _, fragment := range media.Fragments {
+		for _, track := range fragment.Tracks {
+			if track.TrackId != reference.Id || len(track.Samples) == 0 {
+				continue
+			}
+
+			decodeTime := end
+			if track.HasBaseMediaDecodeTime {
+				decodeTime = track.BaseMediaDecodeTime
+			}
+			if !found {
+				found = true
+				start = decodeTime
+				measured.Independent = track.Samples[0].IsSync(reference.DefaultSampleFlags)
+			}
+
+			end = decodeTime
+			for _, sample := range track.Samples {
+				end += uint64(sampleDuration(sample, reference))
+			}
+		}
+	}
+	if !found || end <= start {
+		return timing{}, false
+	}
+
+	measured.Start, measured.End = start, end
+	measured.Duration = float64(end-start) / float64(reference.Timescale)
+	return measured, true
+}
+
+// checkPart compares the declared duration and independence of a part with those measured on its media and
+// returns a discrepancy per attribute that differs. The part takes the measured values when correct is set.
+func checkPart(playlist *model.MediaPlaylist, segment int, part *model.Part, media *isobmff.Media, correct bool) []*model.Discrepancy {
+	measured, ok := timingOf(playlist, media)
+	if !ok {
+		return nil
+	}
+
+	var discrepancies []*model.Discrepancy
+	discrepancy := func(attribute, declared, actual string) {
+		discrepancies = append(discrepancies, &model.Discrepancy{
+			MediaPlaylistId: playlist.Id,
+			SegmentSequence: segment,
+			PartSequence:    part.Sequence,
+			Attribute:       attribute,
+			Declared:        declared,
+			Measured:        actual,
+			Corrected:       correct,
+			CreatedAt:       time.Now(),
+		})
+	}
+
+	if math.Abs(part.Duration-measured.Duration) > durationTolerance {
+		discrepancy(model.DiscrepancyDuration, formatSeconds(part.Duration), formatSeconds(measured.Duration))
+		if correct {
+			part.Duration = measured.Duration
+		}
+	}
+	if part.Independent != measured.Independent {
+		discrepancy(model.DiscrepancyIndependent, strconv.FormatBool(part.Independent), strconv.FormatBool(measured.Independent))
+		if correct {
+			part.Independent = measured.Independent
+		}
+	}
+	return discrepancies
+}
+
+// referenceTrack returns the first video track of the playlist, or its first track when it has no video.
+func referenceTrack(playlist *model.MediaPlaylist) *model.Track {
+	for _, track := range playlist.Tracks {
+		if track.HandlerType == "vide" {
+			return track
+		}
+	}
+	if len(playlist.Tracks) > 0 {
+		return playlist.Tracks[0]
+	}
+	return nil
+}
+
+// sampleDuration returns the duration of the sample, or the trex default of its track when its fragment declares none.
+func sampleDuration(sample *isobmff.Sample, track *model.Track) uint32 {
+	if sample.Duration == 0 {
+		return track.DefaultSampleDuration
+	}
+	return sample.Duration
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
+
+func segmentEnd(start time.Time, segment *model.Segment) time.Time {
+	return start.Add(model.Seconds(segment.Duration))
+}
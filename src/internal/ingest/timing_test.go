@@ -0,0 +1,90 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func testSamples(count int, duration uint32, firstFlags uint32) []isobmff.Sample {
+	samples := make([]isobmff.Sample, count)
+	for i := range samples {
+		samples[i] = isobmff.Sample{Duration: duration, Size: 1, Flags: 0x01010000, HasFlags: firstFlags != 0}
+	}
+	samples[0].Flags = firstFlags
+	return samples
+}
+
+func TestUpdatePartChecksTiming(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	repo := ingester.Repository
+
+	video := testVideoTrack
+	video.DefaultSampleFlags = 0x01010000
+
+	// Declared 1.0 and independent, while two fragments span 1.001 seconds from the first decode time
+	// and start with a sample the trex defaults mark as non-sync.
+	message := withTestInit(newTestPartMessage(t, uuid.NewString(), 0, false, true), video)
+	message.Payload.Variant.Codecs = ""
+	data := isobmfftest.Fragment{Sequence: 1, TrackId: 1, BaseMediaDecodeTime: 90090, Samples: testSamples(15, 3003, 0), Data: make([]byte, 15)}.Bytes()
+	data = append(data, isobmfftest.Fragment{Sequence: 2, TrackId: 1, BaseMediaDecodeTime: 135135, Samples: testSamples(15, 3003, 0), Data: make([]byte, 15)}.Bytes()...)
+	message.Payload.Part.Data = base64.StdEncoding.EncodeToString(data)
+	require.NoError(t, ingester.UpdatePart(ctx, message))
+
+	// Declared non-independent, while the first sample is flagged as a sync sample.
+	ingester.CorrectParts = true
+	message = newTestPartMessage(t, uuid.NewString(), 1, false, false)
+	message.Payload.Part.Data = base64.StdEncoding.EncodeToString(isobmfftest.Fragment{
+		Sequence:            3,
+		TrackId:             1,
+		BaseMediaDecodeTime: 180225,
+		Samples:             testSamples(30, 3000, 0x02000000),
+		Data:                make([]byte, 30),
+	}.Bytes())
+	require.NoError(t, ingester.UpdatePart(ctx, message))
+
+	// Gap parts carry no media to check.
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 2, true, false)))
+
+	discrepancies, err := repo.GetDiscrepancies(ctx, testPlaylistId)
+	require.NoError(t, err)
+	require.Len(t, discrepancies, 3)
+	for _, discrepancy := range discrepancies {
+		assert.Equal(t, testVariantId, discrepancy.MediaPlaylistId)
+		assert.False(t, discrepancy.CreatedAt.IsZero())
+	}
+	assert.Equal(t, model.DiscrepancyIndependent, discrepancies[0].Attribute)
+	assert.Equal(t, 1, discrepancies[0].PartSequence)
+	assert.Equal(t, "true", discrepancies[0].Measured)
+	assert.True(t, discrepancies[0].Corrected)
+	assert.Equal(t, model.DiscrepancyIndependent, discrepancies[1].Attribute)
+	assert.Equal(t, "false", discrepancies[1].Measured)
+	assert.Equal(t, model.DiscrepancyDuration, discrepancies[2].Attribute)
+	assert.Equal(t, "1", discrepancies[2].Declared)
+	assert.Equal(t, "1.001", discrepancies[2].Measured)
+	assert.Equal(t, 3, discrepancies[2].SegmentSequence)
+	assert.False(t, discrepancies[2].Corrected)
+
+	stats, err := repo.GetStreamStats(ctx, testPlaylistId)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"durationDiscrepancies": 1, "independentDiscrepancies": 2, model.StatGapParts: 1}, stats)
+	for _, key := range []string{"discrepancies:" + testPlaylistId, "streamstats:" + testPlaylistId} {
+		assert.Equal(t, repository.StreamTTL, repo.Client.TTL(ctx, key).Val(), key)
+	}
+
+	playlist, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	segment := playlist.Segment(3)
+	require.Len(t, segment.Parts, 3)
+	assert.Equal(t, 1.0, segment.Parts[0].Duration)
+	assert.True(t, segment.Parts[0].Independent)
+	assert.True(t, segment.Parts[1].Independent)
+}
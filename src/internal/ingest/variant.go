@@ -0,0 +1,141 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoVariant          = fmt.Errorf("%d: payload carries no variant", 400)
+	ErrInvalidVariant     = fmt.Errorf("%d: invalid variant", 400)
+	ErrIncompatibleChange = fmt.Errorf("%d: incompatible mid-stream change", 409)
+)
+
+// IncompatibleChangeError describes a change of a registered variant or rendition that players
+// cannot follow mid-stream. It is reported back to the publisher as is.
+type IncompatibleChangeError struct {
+	Id        string `json:"id"`
+	Field     string `json:"field"`
+	Current   string `json:"current"`
+	Requested string `json:"requested"`
+}
+
+func (e *IncompatibleChangeError) Error() string {
+	return fmt.Sprintf("%v: %s of %s changes from %q to %q", ErrIncompatibleChange, e.Field, e.Id, e.Current, e.Requested)
+}
+
+func (e *IncompatibleChangeError) Unwrap() error {
+	return ErrIncompatibleChange
+}
+
+// UpdateVariant registers the variant under its master playlist and stores its initialization section.
+// Codecs and bandwidth of a registered variant only change together with the playlist version,
+// and its target duration never changes once segments were published. The update is validated before
+// any media of the variant is stored.
+func (i *Ingester) UpdateVariant(ctx context.Context, message *signals.DataGeneralShape) error {
+	if message.Payload == nil || message.Payload.Variant == nil {
+		return ErrNoVariant
+	}
+	seed, err := variantPlaylistOf(message.Payload)
+	if err != nil {
+		return err
+	}
+
+	variant := message.Payload.Variant
+	if variant.Bandwidth <= 0 {
+		return fmt.Errorf("%w: bandwidth %d", ErrInvalidVariant, variant.Bandwidth)
+	}
+	if variant.TargetDuration <= 0 {
+		return fmt.Errorf("%w: target duration %d", ErrInvalidVariant, variant.TargetDuration)
+	}
+
+	init, err := parseInit(seed, message.Payload)
+	if err != nil {
+		return err
+	}
+	err = i.checkMediaPlaylist(ctx, seed)
+	if err != nil {
+		return err
+	}
+
+	err = i.registerMedia(ctx, message.Payload, true)
+	if err != nil {
+		return err
+	}
+
+	if init != nil {
+		err = i.Repository.SetMedia(ctx, seed.InitCacheKey, init)
+		if err != nil {
+			return err
+		}
+	}
+
+	return i.updateMediaPlaylist(ctx, seed, func(playlist *model.MediaPlaylist) error {
+		return nil
+	})
+}
+
+func compareVariants(current, requested *model.Variant) error {
+	if requested.Codecs != "" && normalizeCodecs(current.Codecs) != normalizeCodecs(requested.Codecs) {
+		return &IncompatibleChangeError{
+			Id:        current.Id,
+			Field:     "codecs",
+			Current:   current.Codecs,
+			Requested: requested.Codecs,
+		}
+	}
+	if current.Bandwidth != requested.Bandwidth {
+		return &IncompatibleChangeError{
+			Id:        current.Id,
+			Field:     "bandwidth",
+			Current:   strconv.Itoa(current.Bandwidth),
+			Requested: strconv.Itoa(requested.Bandwidth),
+		}
+	}
+	return nil
+}
+
+// fillVariant completes the attributes of a registered variant which were unknown at its registration
+// and reports whether it filled any.
+func fillVariant(existing, variant *model.Variant) bool {
+	filled := false
+	if existing.Codecs == "" && variant.Codecs != "" {
+		existing.Codecs = variant.Codecs
+		filled = true
+	}
+	if existing.Resolution == "" && variant.Resolution != "" {
+		existing.Resolution = variant.Resolution
+		filled = true
+	}
+	if existing.FrameRate == 0 && variant.FrameRate != 0 {
+		existing.FrameRate = variant.FrameRate
+		filled = true
+	}
+	if existing.VideoRange == "" && variant.VideoRange != "" {
+		existing.VideoRange = variant.VideoRange
+		filled = true
+	}
+	if existing.Subtitles == "" && variant.Subtitles != "" {
+		existing.Subtitles = variant.Subtitles
+		filled = true
+	}
+	if existing.ClosedCaptions == "" && variant.ClosedCaptions != "" {
+		existing.ClosedCaptions = variant.ClosedCaptions
+		filled = true
+	}
+	return filled
+}
+
+func normalizeCodecs(codecs string) string {
+	var normalized []string
+	for _, codec := range strings.Split(codecs, ",") {
+		if codec = strings.TrimSpace(codec); codec != "" {
+			normalized = append(normalized, codec)
+		}
+	}
+	return strings.Join(normalized, ",")
+}
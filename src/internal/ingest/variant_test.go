@@ -0,0 +1,105 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func newTestVariantMessage(t *testing.T, version int, codecs string, bandwidth int, targetDuration int) *signals.DataGeneralShape {
+	message := newTestPartMessage(t, uuid.NewString(), 0, false, true)
+	message.Action = signals.DataActionUpdateVariant
+	message.Payload.Part = nil
+	message.Payload.Playlist.Version = version
+	message.Payload.Variant.Codecs = codecs
+	message.Payload.Variant.Bandwidth = bandwidth
+	message.Payload.Variant.TargetDuration = targetDuration
+	return message
+}
+
+func TestUpdateVariant(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	require.NoError(t, ingester.UpdateVariant(ctx, newTestVariantMessage(t, 1, "avc1.4dc00d,mp4a.40.2", 2048, 4)))
+	_, err := ingester.Repository.GetMedia(ctx, testPlaylistId+"/"+testMapId)
+	require.NoError(t, err)
+
+	// Publishing a segment freezes the target duration.
+	require.NoError(t, ingester.UpdatePart(ctx, newTestPartMessage(t, uuid.NewString(), 0, false, false)))
+
+	cases := []struct {
+		Message  *signals.DataGeneralShape
+		Expected *IncompatibleChangeError
+		Err      error
+	}{
+		{Message: newTestVariantMessage(t, 1, "avc1.4dc00d, mp4a.40.2", 2048, 4)},
+		{
+			Message:  newTestVariantMessage(t, 1, "hvc1.1.6.L93.B0,mp4a.40.2", 2048, 4),
+			Expected: &IncompatibleChangeError{Id: testVariantId, Field: "codecs", Current: "avc1.4dc00d,mp4a.40.2", Requested: "hvc1.1.6.L93.B0,mp4a.40.2"},
+		},
+		{
+			Message:  newTestVariantMessage(t, 1, "avc1.4dc00d,mp4a.40.2", 4096, 4),
+			Expected: &IncompatibleChangeError{Id: testVariantId, Field: "bandwidth", Current: "2048", Requested: "4096"},
+		},
+		{
+			Message:  newTestVariantMessage(t, 2, "avc1.4dc00d,mp4a.40.2", 2048, 6),
+			Expected: &IncompatibleChangeError{Id: testVariantId, Field: "targetDuration", Current: "4", Requested: "6"},
+		},
+		{Message: newTestVariantMessage(t, 2, "avc1.64001f,mp4a.40.2", 4096, 4)},
+		{Message: newTestVariantMessage(t, 1, "avc1.64001f,mp4a.40.2", 4096, 4), Err: ErrStalePlaylistVersion},
+		{Message: newTestVariantMessage(t, 2, "avc1.64001f,mp4a.40.2", 0, 4), Err: ErrInvalidVariant},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			err := ingester.UpdateVariant(ctx, c.Message)
+			switch {
+			case c.Expected != nil:
+				var changeErr *IncompatibleChangeError
+				require.True(t, errors.As(err, &changeErr))
+				assert.Equal(t, c.Expected, changeErr)
+				assert.ErrorIs(t, err, ErrIncompatibleChange)
+			case c.Err != nil:
+				assert.ErrorIs(t, err, c.Err)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	playlist, err := ingester.Repository.GetMultivariantPlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	require.Len(t, playlist.Variants, 1)
+	assert.Equal(t, 2, playlist.Version)
+	assert.Equal(t, "avc1.64001f,mp4a.40.2", playlist.Variants[0].Codecs)
+	assert.Equal(t, 4096, playlist.Variants[0].Bandwidth)
+}
+
+func TestUpdateVariantRejectionKeepsState(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+	repo := ingester.Repository
+
+	require.NoError(t, ingester.UpdateVariant(ctx, newTestVariantMessage(t, 1, "avc1.4dc00d,mp4a.40.2", 2048, 4)))
+	init, err := repo.GetMedia(ctx, testPlaylistId+"/"+testMapId)
+	require.NoError(t, err)
+	playlist, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+
+	rejected := newTestVariantMessage(t, 1, "hvc1.1.6.L93.B0,mp4a.40.2", 2048, 4)
+	rejected.Payload.Variant.TargetPartDuration = 2
+	assert.ErrorIs(t, ingester.UpdateVariant(ctx, rejected), ErrIncompatibleChange)
+
+	stored, err := repo.GetMedia(ctx, testPlaylistId+"/"+testMapId)
+	require.NoError(t, err)
+	assert.Equal(t, init, stored)
+	after, err := repo.GetMediaPlaylist(ctx, testPlaylistId+"/"+testVariantId)
+	require.NoError(t, err)
+	assert.Equal(t, playlist, after)
+}
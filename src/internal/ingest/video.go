@@ -0,0 +1,72 @@
+package ingest
+
+import (
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"math"
+)
+
+// applyVideo fills the resolution and video range the publisher left empty with those of the first
+// video track of the initialization section of a variant.
+func applyVideo(payload *signals.DataGeneralShapePayload, init *isobmff.Init) {
+	if payload.Variant == nil {
+		return
+	}
+	for _, track := range init.Tracks {
+		if track.HandlerType != "vide" {
+			continue
+		}
+		if payload.Variant.Resolution == "" && track.Width > 0 && track.Height > 0 {
+			payload.Variant.Resolution = fmt.Sprintf("%dx%d", track.Width, track.Height)
+		}
+		if payload.Variant.VideoRange == "" {
+			payload.Variant.VideoRange = track.VideoRange
+		}
+		return
+	}
+}
+
+// applyFrameRate fills the frame rate the publisher left empty with the measured one.
+func applyFrameRate(payload *signals.DataGeneralShapePayload, frameRate float64) {
+	if payload.Variant != nil && payload.Variant.FrameRate == 0 {
+		payload.Variant.FrameRate = frameRate
+	}
+}
+
+// frameRateOf measures the frame rate of the first video track of the playlist on the sample durations
+// of the media, rounded to three decimals. It is zero when the media carries no timed video samples.
+func frameRateOf(playlist *model.MediaPlaylist, media *isobmff.Media) float64 {
+	if media == nil {
+		return 0
+	}
+
+	var video *model.Track
+	for _, track := range playlist.Tracks {
+		if track.HandlerType == "vide" {
+			video = track
+			break
+		}
+	}
+	if video == nil {
+		return 0
+	}
+
+	samples, duration := 0, uint64(0)
+	for _, fragment := range media.Fragments {
+		for _, track := range fragment.Tracks {
+			if track.TrackId != video.Id {
+				continue
+			}
+			for _, sample := range track.Samples {
+				samples++
+				duration += uint64(sampleDuration(sample, video))
+			}
+		}
+	}
+	if duration == 0 {
+		return 0
+	}
+	return math.Round(float64(samples)*float64(video.Timescale)/float64(duration)*1000) / 1000
+}
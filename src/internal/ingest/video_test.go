@@ -0,0 +1,55 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestUpdatePartMeasuresVideo(t *testing.T) {
+	ctx := context.Background()
+	ingester, _ := newTestIngester(t)
+
+	hdr := testVideoTrack
+	hdr.SampleEntry = isobmfftest.VisualSampleEntry("hvc1", 3840, 2160,
+		isobmfftest.Box("hvcC", []byte{1, 0x02, 0x20, 0, 0, 0, 0x90, 0, 0, 0, 0, 0, 150}),
+		isobmfftest.Box("colr", []byte("nclx"), isobmfftest.Uint16(9), isobmfftest.Uint16(18), isobmfftest.Uint16(9), []byte{0}))
+	hdr.DefaultSampleDuration = 3003
+
+	message := withTestInit(newTestPartMessage(t, uuid.NewString(), 0, false, true), hdr, testAudioTrack)
+	message.Payload.Variant.Codecs = ""
+	message.Payload.Part.Data = base64.StdEncoding.EncodeToString(isobmfftest.Fragment{
+		Sequence: 1,
+		TrackId:  1,
+		Samples:  []isobmff.Sample{{Size: 2}, {Size: 2}, {Duration: 3003, Size: 2}},
+		Data:     []byte("frames"),
+	}.Bytes())
+	require.NoError(t, ingester.UpdatePart(ctx, message))
+
+	multivariant, err := ingester.Repository.GetMultivariantPlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	variant := multivariant.Variant(testVariantId)
+	assert.Equal(t, "hvc1.2.4.L150.90,Opus", variant.Codecs)
+	assert.Equal(t, "3840x2160", variant.Resolution)
+	assert.Equal(t, 29.97, variant.FrameRate)
+	assert.Equal(t, isobmff.VideoRangeHLG, variant.VideoRange)
+
+	// Measurements fill in unknown attributes only.
+	message = newTestPartMessage(t, uuid.NewString(), 1, false, false)
+	message.Payload.Part.Data = base64.StdEncoding.EncodeToString(isobmfftest.Fragment{
+		Sequence: 2,
+		TrackId:  1,
+		Samples:  []isobmff.Sample{{Duration: 1500, Size: 2}},
+		Data:     []byte("fr"),
+	}.Bytes())
+	require.NoError(t, ingester.UpdatePart(ctx, message))
+
+	multivariant, err = ingester.Repository.GetMultivariantPlaylist(ctx, testPlaylistId)
+	require.NoError(t, err)
+	assert.Equal(t, 29.97, multivariant.Variant(testVariantId).FrameRate)
+}
@@ -0,0 +1,146 @@
+package isobmff
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+var (
+	ErrMalformedBox = fmt.Errorf("%d: malformed ISO-BMFF box", 400)
+	ErrMissingBox   = fmt.Errorf("%d: missing ISO-BMFF box", 400)
+)
+
+// Box is an ISO/IEC 14496-12 box with its payload, the bytes following the box header.
+type Box struct {
+	Type    string
+	Payload []byte
+}
+
+// ReadBoxes splits the data into the boxes it consists of.
+func ReadBoxes(data []byte) ([]Box, error) {
+	var boxes []Box
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 8 {
+			return nil, fmt.Errorf("%w: %d trailing bytes at offset %d", ErrMalformedBox, len(data)-offset, offset)
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[offset:]))
+		boxType := string(data[offset+4 : offset+8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data) - offset)
+		case 1:
+			if len(data)-offset < 16 {
+				return nil, fmt.Errorf("%w: %s at offset %d has a truncated size", ErrMalformedBox, boxType, offset)
+			}
+			size = binary.BigEndian.Uint64(data[offset+8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)-offset) {
+			return nil, fmt.Errorf("%w: %s at offset %d has size %d of %d available bytes", ErrMalformedBox, boxType, offset, size, len(data)-offset)
+		}
+
+		boxes = append(boxes, Box{
+			Type:    boxType,
+			Payload: data[offset+int(header) : offset+int(size)],
+		})
+		offset += int(size)
+	}
+	return boxes, nil
+}
+
+// Children splits the payload of a container box into its child boxes.
+func (b Box) Children() ([]Box, error) {
+	children, err := ReadBoxes(b.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, b.Type)
+	}
+	return children, nil
+}
+
+// find returns the first box of the type, or nil when there is none.
+func find(boxes []Box, boxType string) *Box {
+	for i := range boxes {
+		if boxes[i].Type == boxType {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
+
+// child returns the first child box of the type, failing when it is missing.
+func (b Box) child(boxType string) (*Box, error) {
+	children, err := b.Children()
+	if err != nil {
+		return nil, err
+	}
+	child := find(children, boxType)
+	if child == nil {
+		return nil, fmt.Errorf("%w: %s in %s", ErrMissingBox, boxType, b.Type)
+	}
+	return child, nil
+}
+
+// reader reads the big-endian fields of a box payload. Reading past the end sets err and yields zero values.
+type reader struct {
+	box    string
+	data   []byte
+	offset int
+	err    error
+}
+
+func newReader(box Box) *reader {
+	return &reader{box: box.Type, data: box.Payload}
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if n < 0 || len(r.data)-r.offset < n {
+		r.err = fmt.Errorf("%w: %s is truncated at offset %d", ErrMalformedBox, r.box, r.offset)
+		return make([]byte, n)
+	}
+	field := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return field
+}
+
+func (r *reader) skip(n int) {
+	r.next(n)
+}
+
+func (r *reader) uint8() uint8 {
+	return r.next(1)[0]
+}
+
+func (r *reader) uint16() uint16 {
+	return binary.BigEndian.Uint16(r.next(2))
+}
+
+func (r *reader) uint24() uint32 {
+	field := r.next(3)
+	return uint32(field[0])<<16 | uint32(field[1])<<8 | uint32(field[2])
+}
+
+func (r *reader) uint32() uint32 {
+	return binary.BigEndian.Uint32(r.next(4))
+}
+
+func (r *reader) uint64() uint64 {
+	return binary.BigEndian.Uint64(r.next(8))
+}
+
+// fullBox reads the version and flags of a full box.
+func (r *reader) fullBox() (uint8, uint32) {
+	return r.uint8(), r.uint24()
+}
+
+func (r *reader) fourCC() string {
+	return string(r.next(4))
+}
+
+func (r *reader) remaining() int {
+	return len(r.data) - r.offset
+}
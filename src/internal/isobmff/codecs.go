@@ -0,0 +1,251 @@
+package isobmff
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+)
+
+// sampleEntryHeaderSizes are the sizes of the fixed fields of the sample entries by the handler type
+// of their track, ahead of the child boxes.
+var sampleEntryHeaderSizes = map[string]int{
+	"vide": 78,
+	"soun": 28,
+}
+
+// SampleEntry is the first sample entry of the stsd box of a track with its child boxes.
+// Encrypted entries report the format of the protected media.
+type SampleEntry struct {
+	Box
+	Format   string
+	Children []Box
+}
+
+func parseSampleEntry(track *Track, stbl Box) (*SampleEntry, error) {
+	stsd, err := stbl.child("stsd")
+	if err != nil {
+		return nil, err
+	}
+	r := newReader(*stsd)
+	r.fullBox()
+	r.skip(4)
+	if r.err != nil {
+		return nil, r.err
+	}
+	entries, err := ReadBoxes(r.data[r.offset:])
+	if err != nil {
+		return nil, fmt.Errorf("%w in stsd", err)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	entry := &SampleEntry{Box: entries[0], Format: entries[0].Type}
+	headerSize, ok := sampleEntryHeaderSizes[track.HandlerType]
+	if !ok {
+		headerSize = 8
+	}
+	if len(entry.Payload) < headerSize {
+		return nil, fmt.Errorf("%w: %s sample entry of track %d is truncated", ErrMalformedBox, entry.Type, track.Id)
+	}
+	entry.Children, err = ReadBoxes(entry.Payload[headerSize:])
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, entry.Type)
+	}
+
+	if entry.Type == "encv" || entry.Type == "enca" {
+		sinf := find(entry.Children, "sinf")
+		if sinf == nil {
+			return nil, fmt.Errorf("%w: sinf in %s", ErrMissingBox, entry.Type)
+		}
+		frma, err := sinf.child("frma")
+		if err != nil {
+			return nil, err
+		}
+		r = newReader(*frma)
+		entry.Format = r.fourCC()
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	return entry, nil
+}
+
+// Codec returns the RFC 6381 codec of the sample entry, or an empty string for formats it does not know.
+func (e *SampleEntry) Codec() (string, error) {
+	switch e.Format {
+	case "avc1", "avc2", "avc3", "avc4":
+		return e.avcCodec()
+	case "hvc1", "hev1":
+		return e.hevcCodec()
+	case "av01":
+		return e.av1Codec()
+	case "mp4a":
+		return e.mp4aCodec()
+	case "Opus", "ac-3", "ec-3", "fLaC", "wvtt":
+		return e.Format, nil
+	}
+	return "", nil
+}
+
+func (e *SampleEntry) config(boxType string) (*reader, error) {
+	box := find(e.Children, boxType)
+	if box == nil {
+		return nil, fmt.Errorf("%w: %s in %s", ErrMissingBox, boxType, e.Type)
+	}
+	return newReader(*box), nil
+}
+
+// avcCodec reads profile, constraint flags and level off the avcC box, see ISO/IEC 14496-15.
+func (e *SampleEntry) avcCodec() (string, error) {
+	r, err := e.config("avcC")
+	if err != nil {
+		return "", err
+	}
+	r.skip(1)
+	profile, constraints, level := r.uint8(), r.uint8(), r.uint8()
+	if r.err != nil {
+		return "", r.err
+	}
+	return fmt.Sprintf("%s.%02x%02x%02x", e.Format, profile, constraints, level), nil
+}
+
+// nalLengthSize reads lengthSizeMinusOne off the avcC or hvcC box of an H.264 or HEVC sample entry.
+// It returns zero for other formats and for configuration records too short to tell.
+func (e *SampleEntry) nalLengthSize() int {
+	var config *Box
+	offset := 0
+	switch e.Format {
+	case "avc1", "avc2", "avc3", "avc4":
+		config, offset = find(e.Children, "avcC"), 4
+	case "hvc1", "hev1":
+		config, offset = find(e.Children, "hvcC"), 21
+	}
+	if config == nil || len(config.Payload) <= offset {
+		return 0
+	}
+	return int(config.Payload[offset]&0x03) + 1
+}
+
+// hevcCodec follows ISO/IEC 14496-15 Annex E: profile space and profile, reversed compatibility flags,
+// tier and level, then the constraint bytes without the trailing zero bytes.
+func (e *SampleEntry) hevcCodec() (string, error) {
+	r, err := e.config("hvcC")
+	if err != nil {
+		return "", err
+	}
+	r.skip(1)
+	profile := r.uint8()
+	compatibility := r.uint32()
+	constraints := r.next(6)
+	level := r.uint8()
+	if r.err != nil {
+		return "", r.err
+	}
+
+	space := []string{"", "A", "B", "C"}[profile>>6]
+	tier := "L"
+	if profile&0x20 != 0 {
+		tier = "H"
+	}
+	codec := fmt.Sprintf("%s.%s%d.%X.%s%d", e.Format, space, profile&0x1f, bits.Reverse32(compatibility), tier, level)
+
+	last := len(constraints)
+	for last > 0 && constraints[last-1] == 0 {
+		last--
+	}
+	for _, constraint := range constraints[:last] {
+		codec += fmt.Sprintf(".%X", constraint)
+	}
+	return codec, nil
+}
+
+// av1Codec follows the codecs parameter string of the AV1 ISO-BMFF binding, leaving out the optional fields.
+func (e *SampleEntry) av1Codec() (string, error) {
+	r, err := e.config("av1C")
+	if err != nil {
+		return "", err
+	}
+	r.skip(1)
+	profileLevel, flags := r.uint8(), r.uint8()
+	if r.err != nil {
+		return "", r.err
+	}
+
+	tier := "M"
+	if flags&0x80 != 0 {
+		tier = "H"
+	}
+	depth := 8
+	switch {
+	case flags&0x40 != 0 && profileLevel>>5 == 2 && flags&0x20 != 0:
+		depth = 12
+	case flags&0x40 != 0:
+		depth = 10
+	}
+	return fmt.Sprintf("av01.%d.%02d%s.%02d", profileLevel>>5, profileLevel&0x1f, tier, depth), nil
+}
+
+// mp4aCodec reads the object type indication and, for MPEG-4 audio, the audio object type of the esds box.
+func (e *SampleEntry) mp4aCodec() (string, error) {
+	r, err := e.config("esds")
+	if err != nil {
+		return "", err
+	}
+	r.fullBox()
+
+	if tag, _ := r.descriptor(); tag != 0x03 {
+		return "", fmt.Errorf("%w: esds carries no ES descriptor", ErrMalformedBox)
+	}
+	r.skip(2)
+	flags := r.uint8()
+	if flags&0x80 != 0 {
+		r.skip(2)
+	}
+	if flags&0x40 != 0 {
+		r.skip(int(r.uint8()))
+	}
+	if flags&0x20 != 0 {
+		r.skip(2)
+	}
+
+	if tag, _ := r.descriptor(); tag != 0x04 {
+		return "", fmt.Errorf("%w: esds carries no decoder config descriptor", ErrMalformedBox)
+	}
+	objectType := r.uint8()
+	r.skip(12)
+	if r.err != nil {
+		return "", r.err
+	}
+	if objectType != 0x40 {
+		return fmt.Sprintf("mp4a.%02X", objectType), nil
+	}
+
+	tag, size := r.descriptor()
+	if tag != 0x05 || size < 1 {
+		return "", fmt.Errorf("%w: esds carries no audio specific config", ErrMalformedBox)
+	}
+	config := r.uint16()
+	if r.err != nil {
+		return "", r.err
+	}
+	audioObjectType := int(config >> 11)
+	if audioObjectType == 31 {
+		audioObjectType = 32 + int(config>>5&0x3f)
+	}
+	return "mp4a.40." + strconv.Itoa(audioObjectType), nil
+}
+
+// descriptor reads the tag and the variable length size of an MPEG-4 descriptor.
+func (r *reader) descriptor() (uint8, int) {
+	tag := r.uint8()
+	size := 0
+	for i := 0; i < 4; i++ {
+		b := r.uint8()
+		size = size<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return tag, size
+}
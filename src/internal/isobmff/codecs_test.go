@@ -0,0 +1,117 @@
+package isobmff_test
+
+import (
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func esds(objectType byte, audioSpecificConfig ...byte) []byte {
+	decoderSpecificInfo := append([]byte{0x05, byte(len(audioSpecificConfig))}, audioSpecificConfig...)
+	decoderConfig := append([]byte{0x04, byte(13 + len(decoderSpecificInfo)), objectType, 0x15}, make([]byte, 11)...)
+	decoderConfig = append(decoderConfig, decoderSpecificInfo...)
+	es := append([]byte{0x03, 0x80, 0x80, 0x80, byte(3 + len(decoderConfig)), 0, 1, 0}, decoderConfig...)
+	return isobmfftest.FullBox("esds", 0, 0, es)
+}
+
+func TestSampleEntry_Codec(t *testing.T) {
+	avcC := isobmfftest.Box("avcC", []byte{1, 0x64, 0x00, 0x1f, 0xff})
+	hvcC := isobmfftest.Box("hvcC", []byte{1, 0x01, 0x60, 0, 0, 0, 0xb0, 0, 0, 0, 0, 0, 93})
+	sinf := isobmfftest.Box("sinf", isobmfftest.Box("frma", []byte("avc1")))
+
+	cases := []struct {
+		Name        string
+		HandlerType string
+		SampleEntry []byte
+		Expected    string
+	}{
+		{Name: "avc1", HandlerType: "vide", SampleEntry: isobmfftest.VisualSampleEntry("avc1", 1280, 720, avcC), Expected: "avc1.64001f"},
+		{Name: "avc3", HandlerType: "vide", SampleEntry: isobmfftest.VisualSampleEntry("avc3", 1280, 720, avcC), Expected: "avc3.64001f"},
+		{Name: "encv", HandlerType: "vide", SampleEntry: isobmfftest.VisualSampleEntry("encv", 1280, 720, avcC, sinf), Expected: "avc1.64001f"},
+		{Name: "hvc1", HandlerType: "vide", SampleEntry: isobmfftest.VisualSampleEntry("hvc1", 3840, 2160, hvcC), Expected: "hvc1.1.6.L93.B0"},
+		{
+			Name:        "hev1",
+			HandlerType: "vide",
+			SampleEntry: isobmfftest.VisualSampleEntry("hev1", 3840, 2160,
+				isobmfftest.Box("hvcC", []byte{1, 0x22, 0x20, 0, 0, 0, 0x90, 0, 0, 0, 0, 0, 153})),
+			Expected: "hev1.2.4.H153.90",
+		},
+		{
+			Name:        "av01",
+			HandlerType: "vide",
+			SampleEntry: isobmfftest.VisualSampleEntry("av01", 1920, 1080, isobmfftest.Box("av1C", []byte{0x81, 0x08, 0x0c, 0})),
+			Expected:    "av01.0.08M.08",
+		},
+		{
+			Name:        "av01/10bit",
+			HandlerType: "vide",
+			SampleEntry: isobmfftest.VisualSampleEntry("av01", 1920, 1080, isobmfftest.Box("av1C", []byte{0x81, 0x2d, 0xcc, 0})),
+			Expected:    "av01.1.13H.10",
+		},
+		{Name: "mp4a", HandlerType: "soun", SampleEntry: isobmfftest.AudioSampleEntry("mp4a", 2, 48000, esds(0x40, 0x11, 0x90)), Expected: "mp4a.40.2"},
+		{Name: "mp4a/he", HandlerType: "soun", SampleEntry: isobmfftest.AudioSampleEntry("mp4a", 2, 48000, esds(0x40, 0x2b, 0x11)), Expected: "mp4a.40.5"},
+		{Name: "mp4a/escape", HandlerType: "soun", SampleEntry: isobmfftest.AudioSampleEntry("mp4a", 2, 48000, esds(0x40, 0xf9, 0x40)), Expected: "mp4a.40.42"},
+		{Name: "mp4a/mp3", HandlerType: "soun", SampleEntry: isobmfftest.AudioSampleEntry("mp4a", 2, 48000, esds(0x6b)), Expected: "mp4a.6B"},
+		{Name: "Opus", HandlerType: "soun", SampleEntry: isobmfftest.AudioSampleEntry("Opus", 2, 48000), Expected: "Opus"},
+		{Name: "ac-3", HandlerType: "soun", SampleEntry: isobmfftest.AudioSampleEntry("ac-3", 6, 48000), Expected: "ac-3"},
+		{Name: "ec-3", HandlerType: "soun", SampleEntry: isobmfftest.AudioSampleEntry("ec-3", 6, 48000), Expected: "ec-3"},
+		{Name: "unknown", HandlerType: "soun", SampleEntry: isobmfftest.AudioSampleEntry("sowt", 2, 48000), Expected: ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			init, err := isobmff.ParseInit(isobmfftest.Init(isobmfftest.Track{
+				Id:          1,
+				Timescale:   90000,
+				HandlerType: c.HandlerType,
+				SampleEntry: c.SampleEntry,
+			}))
+			require.NoError(t, err)
+			assert.Equal(t, c.Expected, init.Tracks[0].Codec)
+		})
+	}
+}
+
+func TestParseInitNALLengthSize(t *testing.T) {
+	cases := map[string]struct {
+		SampleEntry []byte
+		Expected    int
+	}{
+		"avc1":      {SampleEntry: isobmfftest.VisualSampleEntry("avc1", 1280, 720, isobmfftest.Box("avcC", []byte{1, 0x64, 0x00, 0x1f, 0xff})), Expected: 4},
+		"hvc1":      {SampleEntry: isobmfftest.VisualSampleEntry("hvc1", 1280, 720, isobmfftest.Box("hvcC", append(make([]byte, 21), 0xfd, 0))), Expected: 2},
+		"truncated": {SampleEntry: isobmfftest.VisualSampleEntry("hvc1", 1280, 720, isobmfftest.Box("hvcC", []byte{1, 0x01, 0x60, 0, 0, 0, 0xb0, 0, 0, 0, 0, 0, 93})), Expected: 0},
+		"av01":      {SampleEntry: isobmfftest.VisualSampleEntry("av01", 1920, 1080, isobmfftest.Box("av1C", []byte{0x81, 0x08, 0x0c, 0})), Expected: 0},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			init, err := isobmff.ParseInit(isobmfftest.Init(isobmfftest.Track{Id: 1, Timescale: 90000, HandlerType: "vide", SampleEntry: c.SampleEntry}))
+			require.NoError(t, err)
+			assert.Equal(t, c.Expected, init.Tracks[0].NALLengthSize)
+		})
+	}
+}
+
+func TestSampleEntry_CodecRejectsMalformedConfigs(t *testing.T) {
+	_, err := isobmff.ParseInit(isobmfftest.Init(isobmfftest.Track{
+		Id:          1,
+		Timescale:   90000,
+		HandlerType: "vide",
+		SampleEntry: isobmfftest.VisualSampleEntry("avc1", 1280, 720),
+	}))
+	assert.ErrorIs(t, err, isobmff.ErrMissingBox)
+
+	tracks := []isobmfftest.Track{
+		{HandlerType: "vide", SampleEntry: isobmfftest.VisualSampleEntry("hvc1", 1280, 720, isobmfftest.Box("hvcC", []byte{1, 0x01}))},
+		{HandlerType: "soun", SampleEntry: isobmfftest.AudioSampleEntry("mp4a", 2, 48000, isobmfftest.FullBox("esds", 0, 0, []byte{0x04, 0}))},
+		{HandlerType: "vide", SampleEntry: isobmfftest.AudioSampleEntry("mp4a", 2, 48000, esds(0x40, 0x11, 0x90))},
+	}
+
+	for _, track := range tracks {
+		track.Id = 1
+		track.Timescale = 90000
+		_, err := isobmff.ParseInit(isobmfftest.Init(track))
+		assert.ErrorIs(t, err, isobmff.ErrMalformedBox)
+	}
+}
@@ -0,0 +1,337 @@
+package isobmff
+
+import (
+	"fmt"
+)
+
+// tfhd flags
+const (
+	tfhdBaseDataOffset         = 0x000001
+	tfhdSampleDescriptionIndex = 0x000002
+	tfhdDefaultSampleDuration  = 0x000008
+	tfhdDefaultSampleSize      = 0x000010
+	tfhdDefaultSampleFlags     = 0x000020
+)
+
+// trun flags
+const (
+	trunDataOffset            = 0x000001
+	trunFirstSampleFlags      = 0x000004
+	trunSampleDuration        = 0x000100
+	trunSampleSize            = 0x000200
+	trunSampleFlags           = 0x000400
+	trunSampleCompositionTime = 0x000800
+)
+
+// sampleIsNonSyncSample is the sample_is_non_sync_sample bit of the sample flags.
+const sampleIsNonSyncSample = 0x00010000
+
+// Media is a parsed CMAF segment or chunk, one or more movie fragments each followed by its media data.
+type Media struct {
+	Brands    []string
+	Fragments []*Fragment
+}
+
+type Fragment struct {
+	Sequence uint32
+	Tracks   []*TrackFragment
+	// DataSize is the size of the payload of the mdat box following the moof box.
+	DataSize int
+	// Data is the payload of that mdat box.
+	Data []byte
+}
+
+// TrackFragment is a traf box. Sample fields the trun box leaves out carry the tfhd defaults,
+// or zero when the tfhd box has none either and the trex defaults of the initialization section apply.
+// Sample flags tell the latter case apart with HasFlags.
+type TrackFragment struct {
+	TrackId                uint32
+	BaseMediaDecodeTime    uint64
+	HasBaseMediaDecodeTime bool
+	DefaultSampleDuration  uint32
+	DefaultSampleSize      uint32
+	DefaultSampleFlags     uint32
+	HasDefaultSampleFlags  bool
+	Samples                []*Sample
+}
+
+type Sample struct {
+	Duration uint32
+	Size     uint32
+	Flags    uint32
+	// HasFlags tells whether the trun or tfhd box declares the flags of the sample,
+	// as zero flags mark a sync sample and cannot stand for the trex defaults.
+	HasFlags              bool
+	CompositionTimeOffset int32
+}
+
+// IsSync tells whether the sample can be decoded without the samples preceding it,
+// falling back to the default flags when its fragment declares none.
+func (s *Sample) IsSync(defaultFlags uint32) bool {
+	flags := defaultFlags
+	if s.HasFlags {
+		flags = s.Flags
+	}
+	return flags&sampleIsNonSyncSample == 0
+}
+
+// ParseMedia walks the styp, moof and mdat boxes of a segment or part.
+func ParseMedia(data []byte) (*Media, error) {
+	boxes, err := ReadBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	media := &Media{}
+	var fragment *Fragment
+	for index, box := range boxes {
+		switch box.Type {
+		case "styp":
+			r := newReader(box)
+			media.Brands = append(media.Brands, r.fourCC())
+			r.skip(4)
+			for r.remaining() >= 4 {
+				media.Brands = append(media.Brands, r.fourCC())
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+		case "moof":
+			if fragment != nil {
+				return nil, fmt.Errorf("%w: mdat of fragment %d", ErrMissingBox, fragment.Sequence)
+			}
+			dataSize := 0
+			if index+1 < len(boxes) && boxes[index+1].Type == "mdat" {
+				dataSize = len(boxes[index+1].Payload)
+			}
+			fragment, err = parseFragment(box, dataSize)
+			if err != nil {
+				return nil, err
+			}
+		case "mdat":
+			if fragment == nil {
+				return nil, fmt.Errorf("%w: mdat without a preceding moof", ErrMalformedBox)
+			}
+			fragment.DataSize = len(box.Payload)
+			fragment.Data = box.Payload
+			if size := fragment.sampleSize(); size > fragment.DataSize {
+				return nil, fmt.Errorf("%w: samples of fragment %d take %d bytes of %d in mdat", ErrMalformedBox, fragment.Sequence, size, fragment.DataSize)
+			}
+			media.Fragments = append(media.Fragments, fragment)
+			fragment = nil
+		}
+	}
+
+	if fragment != nil {
+		return nil, fmt.Errorf("%w: mdat of fragment %d", ErrMissingBox, fragment.Sequence)
+	}
+	if len(media.Fragments) == 0 {
+		return nil, fmt.Errorf("%w: moof", ErrMissingBox)
+	}
+	return media, nil
+}
+
+// TrackIds returns the ids of the tracks the fragments carry samples of, in order of appearance.
+func (m *Media) TrackIds() []uint32 {
+	var ids []uint32
+	seen := map[uint32]bool{}
+	for _, fragment := range m.Fragments {
+		for _, track := range fragment.Tracks {
+			if !seen[track.TrackId] {
+				seen[track.TrackId] = true
+				ids = append(ids, track.TrackId)
+			}
+		}
+	}
+	return ids
+}
+
+// SampleData returns the data of each sample of the track fragment, assuming the CMAF layout in which the mdat box
+// holds the samples of the track fragments back to back in the order of the moof box. Samples without a size of
+// their own take the default size. The list stops short at the first sample whose data cannot be located.
+func (f *Fragment) SampleData(track *TrackFragment, defaultSize uint32) [][]byte {
+	offset := 0
+	for _, other := range f.Tracks {
+		if other == track {
+			break
+		}
+		for _, sample := range other.Samples {
+			offset += int(sample.Size)
+		}
+	}
+
+	var data [][]byte
+	for _, sample := range track.Samples {
+		size := int(sample.Size)
+		if size == 0 {
+			size = int(defaultSize)
+		}
+		if size == 0 || offset+size > len(f.Data) {
+			break
+		}
+		data = append(data, f.Data[offset:offset+size])
+		offset += size
+	}
+	return data
+}
+
+func (f *Fragment) sampleSize() int {
+	size := 0
+	for _, track := range f.Tracks {
+		for _, sample := range track.Samples {
+			size += int(sample.Size)
+		}
+	}
+	return size
+}
+
+// parseFragment parses a moof box followed by an mdat box with the payload size given.
+func parseFragment(moof Box, dataSize int) (*Fragment, error) {
+	mfhd, err := moof.child("mfhd")
+	if err != nil {
+		return nil, err
+	}
+	r := newReader(*mfhd)
+	r.fullBox()
+	fragment := &Fragment{Sequence: r.uint32()}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	children, err := moof.Children()
+	if err != nil {
+		return nil, err
+	}
+	for _, box := range children {
+		if box.Type != "traf" {
+			continue
+		}
+		track, err := parseTrackFragment(box, dataSize)
+		if err != nil {
+			return nil, fmt.Errorf("%w of fragment %d", err, fragment.Sequence)
+		}
+		fragment.Tracks = append(fragment.Tracks, track)
+	}
+	if len(fragment.Tracks) == 0 {
+		return nil, fmt.Errorf("%w: traf in moof of fragment %d", ErrMissingBox, fragment.Sequence)
+	}
+	return fragment, nil
+}
+
+func parseTrackFragment(traf Box, dataSize int) (*TrackFragment, error) {
+	children, err := traf.Children()
+	if err != nil {
+		return nil, err
+	}
+
+	tfhd := find(children, "tfhd")
+	if tfhd == nil {
+		return nil, fmt.Errorf("%w: tfhd in traf", ErrMissingBox)
+	}
+	r := newReader(*tfhd)
+	_, flags := r.fullBox()
+	track := &TrackFragment{TrackId: r.uint32()}
+	if flags&tfhdBaseDataOffset != 0 {
+		r.skip(8)
+	}
+	if flags&tfhdSampleDescriptionIndex != 0 {
+		r.skip(4)
+	}
+	if flags&tfhdDefaultSampleDuration != 0 {
+		track.DefaultSampleDuration = r.uint32()
+	}
+	if flags&tfhdDefaultSampleSize != 0 {
+		track.DefaultSampleSize = r.uint32()
+	}
+	if flags&tfhdDefaultSampleFlags != 0 {
+		track.DefaultSampleFlags = r.uint32()
+		track.HasDefaultSampleFlags = true
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if tfdt := find(children, "tfdt"); tfdt != nil {
+		r = newReader(*tfdt)
+		if version, _ := r.fullBox(); version == 1 {
+			track.BaseMediaDecodeTime = r.uint64()
+		} else {
+			track.BaseMediaDecodeTime = uint64(r.uint32())
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		track.HasBaseMediaDecodeTime = true
+	}
+
+	for _, box := range children {
+		if box.Type != "trun" {
+			continue
+		}
+		err = parseTrackRun(track, box, dataSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return track, nil
+}
+
+// parseTrackRun appends the samples of a trun box to the track fragment. Samples whose fields the trun box leaves
+// out each take at least a byte of the mdat box, which bounds how many of them it may declare.
+func parseTrackRun(track *TrackFragment, trun Box, dataSize int) error {
+	r := newReader(trun)
+	_, flags := r.fullBox()
+	count := r.uint32()
+	if flags&trunDataOffset != 0 {
+		r.skip(4)
+	}
+	firstSampleFlags, hasFirstSampleFlags := uint32(0), flags&trunFirstSampleFlags != 0
+	if hasFirstSampleFlags {
+		firstSampleFlags = r.uint32()
+	}
+
+	fieldSize := 0
+	for _, field := range []uint32{trunSampleDuration, trunSampleSize, trunSampleFlags, trunSampleCompositionTime} {
+		if flags&field != 0 {
+			fieldSize += 4
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if uint64(count)*uint64(fieldSize) > uint64(r.remaining()) {
+		return fmt.Errorf("%w: trun of track %d declares %d samples in %d bytes", ErrMalformedBox, track.TrackId, count, r.remaining())
+	}
+	if fieldSize == 0 && uint64(count) > uint64(dataSize) {
+		return fmt.Errorf("%w: trun of track %d declares %d samples for %d bytes of mdat", ErrMalformedBox, track.TrackId, count, dataSize)
+	}
+
+	samples := make([]*Sample, 0, count)
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		sample := &Sample{
+			Duration: track.DefaultSampleDuration,
+			Size:     track.DefaultSampleSize,
+			Flags:    track.DefaultSampleFlags,
+			HasFlags: track.HasDefaultSampleFlags,
+		}
+		if flags&trunSampleDuration != 0 {
+			sample.Duration = r.uint32()
+		}
+		if flags&trunSampleSize != 0 {
+			sample.Size = r.uint32()
+		}
+		if flags&trunSampleFlags != 0 {
+			sample.Flags = r.uint32()
+			sample.HasFlags = true
+		} else if i == 0 && hasFirstSampleFlags {
+			sample.Flags = firstSampleFlags
+			sample.HasFlags = true
+		}
+		if flags&trunSampleCompositionTime != 0 {
+			sample.CompositionTimeOffset = int32(r.uint32())
+		}
+		samples = append(samples, sample)
+	}
+	track.Samples = append(track.Samples, samples...)
+	return r.err
+}
@@ -0,0 +1,220 @@
+package isobmff
+
+import (
+	"fmt"
+)
+
+// Init is a parsed CMAF header, the initialization section of a variant or rendition.
+type Init struct {
+	MajorBrand       string
+	CompatibleBrands []string
+	Tracks           []*Track
+}
+
+// Track is a track of an initialization section together with the fragment defaults of its trex box.
+type Track struct {
+	Id          uint32
+	Timescale   uint32
+	HandlerType string
+	// Duration is the duration of the track in timescale units, usually zero for fragmented tracks.
+	Duration              uint64
+	DefaultSampleDuration uint32
+	DefaultSampleSize     uint32
+	DefaultSampleFlags    uint32
+	// SampleEntry is nil when the stsd box lists no sample entry.
+	SampleEntry *SampleEntry
+	// Codec is the RFC 6381 codec of the sample entry, empty when its format is unknown.
+	Codec string
+	// Width and Height of a video track are the coded size of its sample entry, or else its presentation size.
+	Width  uint16
+	Height uint16
+	// VideoRange of a video track is SDR, PQ or HLG as signalled by its sample entry.
+	VideoRange string
+	// NALLengthSize is the size of the length prefix of the NAL units of an H.264 or HEVC track, zero for other formats.
+	NALLengthSize int
+}
+
+// ParseInit walks the ftyp and moov boxes of an initialization section.
+func ParseInit(data []byte) (*Init, error) {
+	boxes, err := ReadBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ftyp := find(boxes, "ftyp")
+	if ftyp == nil {
+		return nil, fmt.Errorf("%w: ftyp", ErrMissingBox)
+	}
+	moov := find(boxes, "moov")
+	if moov == nil {
+		return nil, fmt.Errorf("%w: moov", ErrMissingBox)
+	}
+
+	init := &Init{}
+	r := newReader(*ftyp)
+	init.MajorBrand = r.fourCC()
+	r.skip(4)
+	for r.remaining() >= 4 {
+		init.CompatibleBrands = append(init.CompatibleBrands, r.fourCC())
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	children, err := moov.Children()
+	if err != nil {
+		return nil, err
+	}
+	for _, box := range children {
+		if box.Type != "trak" {
+			continue
+		}
+		track, err := parseTrack(box)
+		if err != nil {
+			return nil, err
+		}
+		init.Tracks = append(init.Tracks, track)
+	}
+	if len(init.Tracks) == 0 {
+		return nil, fmt.Errorf("%w: trak in moov", ErrMissingBox)
+	}
+
+	if mvex := find(children, "mvex"); mvex != nil {
+		err = parseTrackExtends(init, *mvex)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return init, nil
+}
+
+// Track returns the track with the id, or nil when it is unknown.
+func (i *Init) Track(id uint32) *Track {
+	for _, track := range i.Tracks {
+		if track.Id == id {
+			return track
+		}
+	}
+	return nil
+}
+
+func parseTrack(trak Box) (*Track, error) {
+	track := &Track{}
+
+	tkhd, err := trak.child("tkhd")
+	if err != nil {
+		return nil, err
+	}
+	r := newReader(*tkhd)
+	if version, _ := r.fullBox(); version == 1 {
+		r.skip(16)
+		track.Id = r.uint32()
+		r.skip(12)
+	} else {
+		r.skip(8)
+		track.Id = r.uint32()
+		r.skip(8)
+	}
+	r.skip(52)
+	width, height := r.uint32()>>16, r.uint32()>>16
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	mdia, err := trak.child("mdia")
+	if err != nil {
+		return nil, err
+	}
+	mdhd, err := mdia.child("mdhd")
+	if err != nil {
+		return nil, err
+	}
+	r = newReader(*mdhd)
+	if version, _ := r.fullBox(); version == 1 {
+		r.skip(16)
+		track.Timescale = r.uint32()
+		track.Duration = r.uint64()
+	} else {
+		r.skip(8)
+		track.Timescale = r.uint32()
+		track.Duration = uint64(r.uint32())
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if track.Timescale == 0 {
+		return nil, fmt.Errorf("%w: mdhd of track %d has no timescale", ErrMalformedBox, track.Id)
+	}
+
+	hdlr, err := mdia.child("hdlr")
+	if err != nil {
+		return nil, err
+	}
+	r = newReader(*hdlr)
+	r.fullBox()
+	r.skip(4)
+	track.HandlerType = r.fourCC()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	minf, err := mdia.child("minf")
+	if err != nil {
+		return nil, err
+	}
+	stbl, err := minf.child("stbl")
+	if err != nil {
+		return nil, err
+	}
+	track.SampleEntry, err = parseSampleEntry(track, *stbl)
+	if err != nil {
+		return nil, err
+	}
+	if track.SampleEntry != nil {
+		track.Codec, err = track.SampleEntry.Codec()
+		if err != nil {
+			return nil, fmt.Errorf("%w of track %d", err, track.Id)
+		}
+	}
+
+	if track.HandlerType == "vide" {
+		track.Width, track.Height = uint16(width), uint16(height)
+		if track.SampleEntry != nil {
+			err = track.SampleEntry.applyVisual(track)
+			if err != nil {
+				return nil, fmt.Errorf("%w of track %d", err, track.Id)
+			}
+			track.NALLengthSize = track.SampleEntry.nalLengthSize()
+		}
+	}
+	return track, nil
+}
+
+func parseTrackExtends(init *Init, mvex Box) error {
+	children, err := mvex.Children()
+	if err != nil {
+		return err
+	}
+	for _, box := range children {
+		if box.Type != "trex" {
+			continue
+		}
+		r := newReader(box)
+		r.fullBox()
+		id := r.uint32()
+		r.skip(4)
+		duration, size, flags := r.uint32(), r.uint32(), r.uint32()
+		if r.err != nil {
+			return r.err
+		}
+
+		track := init.Track(id)
+		if track == nil {
+			return fmt.Errorf("%w: trex of unknown track %d", ErrMalformedBox, id)
+		}
+		track.DefaultSampleDuration = duration
+		track.DefaultSampleSize = size
+		track.DefaultSampleFlags = flags
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package isobmff_test
+
+import (
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func TestParseInit(t *testing.T) {
+	init, err := isobmff.ParseInit(isobmfftest.Init(
+		isobmfftest.Track{Id: 1, Timescale: 90000, HandlerType: "vide", DefaultSampleDuration: 3000},
+		isobmfftest.Track{Id: 2, Timescale: 48000, HandlerType: "soun"},
+	))
+	require.NoError(t, err)
+
+	assert.Equal(t, "iso6", init.MajorBrand)
+	assert.Equal(t, []string{"iso6", "cmfc"}, init.CompatibleBrands)
+	require.Len(t, init.Tracks, 2)
+	assert.Equal(t, &isobmff.Track{Id: 1, Timescale: 90000, HandlerType: "vide", DefaultSampleDuration: 3000}, init.Tracks[0])
+	assert.Equal(t, uint32(48000), init.Track(2).Timescale)
+	assert.Equal(t, "soun", init.Track(2).HandlerType)
+	assert.Nil(t, init.Track(3))
+}
+
+func TestParseMedia(t *testing.T) {
+	data := isobmfftest.Box("styp", []byte("msdh"), isobmfftest.Uint32(0), []byte("msdhcmfs"))
+	data = append(data, isobmfftest.Fragment{
+		Sequence:            7,
+		TrackId:             1,
+		BaseMediaDecodeTime: 1 << 33,
+		Samples: []isobmff.Sample{
+			{Duration: 3000, Size: 3, Flags: 0x02000000, HasFlags: true},
+			{Duration: 3000, Size: 2, Flags: 0x01010000, HasFlags: true, CompositionTimeOffset: -1500},
+		},
+		Data: []byte("frame"),
+	}.Bytes()...)
+	data = append(data, isobmfftest.Fragment{Sequence: 8, TrackId: 2, Data: []byte("audio")}.Bytes()...)
+
+	media, err := isobmff.ParseMedia(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"msdh", "msdh", "cmfs"}, media.Brands)
+	require.Len(t, media.Fragments, 2)
+	assert.Equal(t, []uint32{1, 2}, media.TrackIds())
+
+	fragment := media.Fragments[0]
+	assert.Equal(t, uint32(7), fragment.Sequence)
+	assert.Equal(t, 5, fragment.DataSize)
+	require.Len(t, fragment.Tracks, 1)
+	track := fragment.Tracks[0]
+	assert.True(t, track.HasBaseMediaDecodeTime)
+	assert.Equal(t, uint64(1<<33), track.BaseMediaDecodeTime)
+	assert.Equal(t, []*isobmff.Sample{
+		{Duration: 3000, Size: 3, Flags: 0x02000000, HasFlags: true},
+		{Duration: 3000, Size: 2, Flags: 0x01010000, HasFlags: true, CompositionTimeOffset: -1500},
+	}, track.Samples)
+	assert.True(t, track.Samples[0].IsSync(0x01010000))
+	assert.False(t, track.Samples[1].IsSync(0))
+	assert.Equal(t, [][]byte{[]byte("fra"), []byte("me")}, fragment.SampleData(track, 0))
+	assert.Equal(t, [][]byte{[]byte("audio")}, media.Fragments[1].SampleData(media.Fragments[1].Tracks[0], 0))
+}
+
+func TestReadBoxes(t *testing.T) {
+	large := append(isobmfftest.Uint32(1), []byte("free")...)
+	large = append(large, isobmfftest.Uint64(20)...)
+	large = append(large, []byte("data")...)
+
+	boxes, err := isobmff.ReadBoxes(append(large, isobmfftest.Box("skip", []byte("xy"))...))
+	require.NoError(t, err)
+	assert.Equal(t, []isobmff.Box{
+		{Type: "free", Payload: []byte("data")},
+		{Type: "skip", Payload: []byte("xy")},
+	}, boxes)
+}
+
+func TestParseMalformed(t *testing.T) {
+	init := isobmfftest.Init(isobmfftest.Track{Id: 1, Timescale: 90000, HandlerType: "vide"})
+	fragment := isobmfftest.Fragment{Sequence: 1, TrackId: 1, Data: []byte("frame")}.Bytes()
+	moof := fragment[:len(fragment)-13]
+	overflow := isobmfftest.Fragment{
+		Sequence: 1,
+		TrackId:  1,
+		Samples:  []isobmff.Sample{{Size: 6}},
+		Data:     []byte("frame"),
+	}.Bytes()
+	truncatedTrun := isobmfftest.Box("moof",
+		isobmfftest.FullBox("mfhd", 0, 0, isobmfftest.Uint32(1)),
+		isobmfftest.Box("traf",
+			isobmfftest.FullBox("tfhd", 0, 0, isobmfftest.Uint32(1)),
+			isobmfftest.FullBox("trun", 0, 0x000300, isobmfftest.Uint32(1000)),
+		),
+	)
+
+	// Samples without fields of their own in the trun box still take up media data.
+	zeroWidthTrun := isobmfftest.Box("moof",
+		isobmfftest.FullBox("mfhd", 0, 0, isobmfftest.Uint32(1)),
+		isobmfftest.Box("traf",
+			isobmfftest.FullBox("tfhd", 0, 0, isobmfftest.Uint32(1)),
+			isobmfftest.FullBox("trun", 0, 0, isobmfftest.Uint32(0xffffffff)),
+		),
+	)
+
+	cases := []struct {
+		Parse    func([]byte) error
+		Data     []byte
+		Expected error
+	}{
+		{Parse: parseInit, Data: init[:len(init)-1], Expected: isobmff.ErrMalformedBox},
+		{Parse: parseInit, Data: init[:24], Expected: isobmff.ErrMissingBox},
+		{Parse: parseInit, Data: []byte("not an init section"), Expected: isobmff.ErrMalformedBox},
+		{Parse: parseInit, Data: isobmfftest.Init(isobmfftest.Track{Id: 1, HandlerType: "vide"}), Expected: isobmff.ErrMalformedBox},
+		{Parse: parseMedia, Data: init, Expected: isobmff.ErrMissingBox},
+		{Parse: parseMedia, Data: moof, Expected: isobmff.ErrMissingBox},
+		{Parse: parseMedia, Data: append(append([]byte{}, moof...), fragment...), Expected: isobmff.ErrMissingBox},
+		{Parse: parseMedia, Data: isobmfftest.Box("mdat", []byte("frame")), Expected: isobmff.ErrMalformedBox},
+		{Parse: parseMedia, Data: overflow, Expected: isobmff.ErrMalformedBox},
+		{Parse: parseMedia, Data: append(truncatedTrun, isobmfftest.Box("mdat")...), Expected: isobmff.ErrMalformedBox},
+		{Parse: parseMedia, Data: append(zeroWidthTrun, isobmfftest.Box("mdat", []byte("frame"))...), Expected: isobmff.ErrMalformedBox},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			assert.ErrorIs(t, c.Parse(c.Data), c.Expected)
+		})
+	}
+}
+
+func parseInit(data []byte) error {
+	_, err := isobmff.ParseInit(data)
+	return err
+}
+
+func parseMedia(data []byte) error {
+	_, err := isobmff.ParseMedia(data)
+	return err
+}
@@ -0,0 +1,153 @@
+// Package isobmfftest builds minimal CMAF headers and chunks for tests.
+package isobmfftest
+
+import (
+	"encoding/binary"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+)
+
+// Box encodes a box of the type around the concatenated payloads.
+func Box(boxType string, payloads ...[]byte) []byte {
+	size := 8
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+
+	box := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(box, uint32(size))
+	copy(box[4:], boxType)
+	for _, payload := range payloads {
+		box = append(box, payload...)
+	}
+	return box
+}
+
+// FullBox encodes a box of the type with a version and flags header.
+func FullBox(boxType string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	header := Uint32(uint32(version)<<24 | flags&0xffffff)
+	return Box(boxType, append([][]byte{header}, payloads...)...)
+}
+
+func Uint16(value uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, value)
+}
+
+func Uint32(value uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, value)
+}
+
+func Uint64(value uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, value)
+}
+
+// VisualSampleEntry encodes a video sample entry of the format with its child boxes.
+func VisualSampleEntry(format string, width, height uint16, children ...[]byte) []byte {
+	fields := [][]byte{
+		make([]byte, 6), Uint16(1),
+		make([]byte, 16), Uint16(width), Uint16(height),
+		Uint32(0x00480000), Uint32(0x00480000), Uint32(0), Uint16(1),
+		make([]byte, 32), Uint16(0x0018), Uint16(0xffff),
+	}
+	return Box(format, append(fields, children...)...)
+}
+
+// AudioSampleEntry encodes an audio sample entry of the format with its child boxes.
+func AudioSampleEntry(format string, channels uint16, sampleRate uint16, children ...[]byte) []byte {
+	fields := [][]byte{
+		make([]byte, 6), Uint16(1),
+		make([]byte, 8), Uint16(channels), Uint16(16), Uint32(0),
+		Uint16(sampleRate), Uint16(0),
+	}
+	return Box(format, append(fields, children...)...)
+}
+
+type Track struct {
+	Id          uint32
+	Timescale   uint32
+	HandlerType string
+	// Width and Height are the presentation size of the track header.
+	Width  uint16
+	Height uint16
+	// SampleEntry is the encoded sample entry box listed by the stsd box of the track.
+	SampleEntry           []byte
+	DefaultSampleDuration uint32
+	DefaultSampleFlags    uint32
+}
+
+// Init encodes an initialization section carrying the tracks.
+func Init(tracks ...Track) []byte {
+	var traks, trexs [][]byte
+	for _, track := range tracks {
+		var entries [][]byte
+		if track.SampleEntry != nil {
+			entries = append(entries, track.SampleEntry)
+		}
+		traks = append(traks, Box("trak",
+			FullBox("tkhd", 0, 3, Uint32(0), Uint32(0), Uint32(track.Id), make([]byte, 60),
+				Uint32(uint32(track.Width)<<16), Uint32(uint32(track.Height)<<16)),
+			Box("mdia",
+				FullBox("mdhd", 0, 0, Uint32(0), Uint32(0), Uint32(track.Timescale), Uint32(0), make([]byte, 4)),
+				FullBox("hdlr", 0, 0, Uint32(0), []byte(track.HandlerType), make([]byte, 13)),
+				Box("minf", Box("stbl",
+					FullBox("stsd", 0, 0, append([][]byte{Uint32(uint32(len(entries)))}, entries...)...),
+				)),
+			),
+		))
+		trexs = append(trexs, FullBox("trex", 0, 0,
+			Uint32(track.Id), Uint32(1), Uint32(track.DefaultSampleDuration), Uint32(0), Uint32(track.DefaultSampleFlags)))
+	}
+
+	moov := append([][]byte{FullBox("mvhd", 0, 0, make([]byte, 96))}, traks...)
+	moov = append(moov, Box("mvex", trexs...))
+	return append(
+		Box("ftyp", []byte("iso6"), Uint32(0), []byte("iso6cmfc")),
+		Box("moov", moov...)...,
+	)
+}
+
+// Fragment is a movie fragment of a single track along with its media data.
+type Fragment struct {
+	Sequence            uint32
+	TrackId             uint32
+	BaseMediaDecodeTime uint64
+	// Samples defaults to a single sample spanning the data. The trun box declares sample flags
+	// when any of the samples has flags, leaving the trex defaults to apply otherwise.
+	Samples []isobmff.Sample
+	Data    []byte
+}
+
+// Bytes encodes the moof and mdat boxes of the fragment.
+func (f Fragment) Bytes() []byte {
+	samples := f.Samples
+	if samples == nil {
+		samples = []isobmff.Sample{{Size: uint32(len(f.Data))}}
+	}
+
+	flags := uint32(0x000b01)
+	for _, sample := range samples {
+		if sample.HasFlags {
+			flags |= 0x000400
+		}
+	}
+
+	run := [][]byte{Uint32(uint32(len(samples))), Uint32(0)}
+	for _, sample := range samples {
+		run = append(run, Uint32(sample.Duration), Uint32(sample.Size))
+		if flags&0x000400 != 0 {
+			run = append(run, Uint32(sample.Flags))
+		}
+		run = append(run, Uint32(uint32(sample.CompositionTimeOffset)))
+	}
+
+	return append(
+		Box("moof",
+			FullBox("mfhd", 0, 0, Uint32(f.Sequence)),
+			Box("traf",
+				FullBox("tfhd", 0, 0x020000, Uint32(f.TrackId)),
+				FullBox("tfdt", 1, 0, Uint64(f.BaseMediaDecodeTime)),
+				FullBox("trun", 0, flags, run...),
+			),
+		),
+		Box("mdat", f.Data)...,
+	)
+}
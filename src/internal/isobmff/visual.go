@@ -0,0 +1,47 @@
+package isobmff
+
+import "encoding/binary"
+
+// Transfer characteristics of ISO/IEC 23091-2 which signal high dynamic range video.
+const (
+	transferPQ  = 16
+	transferHLG = 18
+)
+
+const (
+	VideoRangeSDR = "SDR"
+	VideoRangePQ  = "PQ"
+	VideoRangeHLG = "HLG"
+)
+
+// applyVisual reads the coded size and the video range of a visual sample entry into the track.
+// Video without a colr box counts as SDR, unless its mdcv box carries HDR mastering metadata.
+func (e *SampleEntry) applyVisual(track *Track) error {
+	width, height := binary.BigEndian.Uint16(e.Payload[24:]), binary.BigEndian.Uint16(e.Payload[26:])
+	if width != 0 && height != 0 {
+		track.Width, track.Height = width, height
+	}
+
+	track.VideoRange = VideoRangeSDR
+	if colr := find(e.Children, "colr"); colr != nil {
+		r := newReader(*colr)
+		colourType := r.fourCC()
+		if colourType != "nclx" && colourType != "nclc" {
+			return r.err
+		}
+		r.skip(2)
+		transfer := r.uint16()
+		if r.err != nil {
+			return r.err
+		}
+		switch transfer {
+		case transferPQ:
+			track.VideoRange = VideoRangePQ
+		case transferHLG:
+			track.VideoRange = VideoRangeHLG
+		}
+	} else if find(e.Children, "mdcv") != nil {
+		track.VideoRange = VideoRangePQ
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package isobmff_test
+
+import (
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff"
+	"github.com/sehovizko/mobworx-streamer/src/internal/isobmff/isobmfftest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func colr(transfer uint16) []byte {
+	return isobmfftest.Box("colr", []byte("nclx"), isobmfftest.Uint16(9), isobmfftest.Uint16(transfer), isobmfftest.Uint16(9), []byte{0})
+}
+
+func TestParseInitVisual(t *testing.T) {
+	hvcC := isobmfftest.Box("hvcC", []byte{1, 0x02, 0x20, 0, 0, 0, 0x90, 0, 0, 0, 0, 0, 150})
+
+	cases := []struct {
+		Name       string
+		Track      isobmfftest.Track
+		Width      uint16
+		Height     uint16
+		VideoRange string
+	}{
+		{
+			Name:       "sdr",
+			Track:      isobmfftest.Track{SampleEntry: isobmfftest.VisualSampleEntry("hvc1", 1920, 1080, hvcC, colr(1))},
+			Width:      1920,
+			Height:     1080,
+			VideoRange: isobmff.VideoRangeSDR,
+		},
+		{
+			Name:       "pq",
+			Track:      isobmfftest.Track{SampleEntry: isobmfftest.VisualSampleEntry("hvc1", 3840, 2160, hvcC, colr(16))},
+			Width:      3840,
+			Height:     2160,
+			VideoRange: isobmff.VideoRangePQ,
+		},
+		{
+			Name:       "hlg",
+			Track:      isobmfftest.Track{SampleEntry: isobmfftest.VisualSampleEntry("hvc1", 3840, 2160, hvcC, colr(18))},
+			Width:      3840,
+			Height:     2160,
+			VideoRange: isobmff.VideoRangeHLG,
+		},
+		{
+			Name:       "mdcv",
+			Track:      isobmfftest.Track{SampleEntry: isobmfftest.VisualSampleEntry("hvc1", 3840, 2160, hvcC, isobmfftest.Box("mdcv", make([]byte, 24)))},
+			Width:      3840,
+			Height:     2160,
+			VideoRange: isobmff.VideoRangePQ,
+		},
+		{
+			Name: "tkhd",
+			Track: isobmfftest.Track{
+				Width:       1280,
+				Height:      720,
+				SampleEntry: isobmfftest.VisualSampleEntry("hvc1", 0, 0, hvcC),
+			},
+			Width:      1280,
+			Height:     720,
+			VideoRange: isobmff.VideoRangeSDR,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			c.Track.Id = 1
+			c.Track.Timescale = 90000
+			c.Track.HandlerType = "vide"
+			init, err := isobmff.ParseInit(isobmfftest.Init(c.Track))
+			require.NoError(t, err)
+
+			track := init.Tracks[0]
+			assert.Equal(t, c.Width, track.Width)
+			assert.Equal(t, c.Height, track.Height)
+			assert.Equal(t, c.VideoRange, track.VideoRange)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// AdAsset is an ad registered for splicing into the ad breaks of a master playlist. Its renditions are
+// pre-conditioned to the variants they replace: same codecs, similar bitrate and segments as long as the live ones.
+// The keys name the media in the ad store.
+type AdAsset struct {
+	Id         string         `json:"id"`
+	Renditions []*AdRendition `json:"renditions"`
+	CreatedAt  time.Time      `json:"createdAt"`
+}
+
+type AdRendition struct {
+	Codecs    string       `json:"codecs"`
+	Bandwidth int          `json:"bandwidth"`
+	InitKey   string       `json:"initKey"`
+	Segments  []*AdSegment `json:"segments"`
+}
+
+type AdSegment struct {
+	Key      string  `json:"key"`
+	Duration float64 `json:"duration"`
+}
+
+// AdSplice tells which ad segment replaced the live segment of a variant, and how long the live segment was.
+type AdSplice struct {
+	BreakId      string  `json:"breakId"`
+	AssetId      string  `json:"assetId"`
+	Position     int     `json:"position"`
+	LiveDuration float64 `json:"liveDuration"`
+}
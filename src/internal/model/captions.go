@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// CaptionCue is a cue of live caption text waiting to be sliced into the WebVTT segments of its language.
+// Start and End are wall clock times on the program date time of the video.
+type CaptionCue struct {
+	Language string    `json:"language"`
+	Text     string    `json:"text"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+}
@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+// Interstitial is an ad pod or other asset scheduled to interrupt the primary content of a master playlist at a
+// wall clock time. Players play either the single AssetUri or the Assets of the pod, which they resolve through
+// the asset list of the interstitial.
+type Interstitial struct {
+	Id        string    `json:"id"`
+	StartDate time.Time `json:"startDate"`
+	// Duration is how long the interstitial interrupts the primary content, or zero when it is not known ahead.
+	Duration float64              `json:"duration,omitempty"`
+	AssetUri string               `json:"assetUri,omitempty"`
+	Assets   []*InterstitialAsset `json:"assets,omitempty"`
+	// ResumeOffset is where playback of the primary content resumes, relative to the start of the interstitial.
+	// Players resume after the duration of the interstitial when it is nil.
+	ResumeOffset *float64 `json:"resumeOffset,omitempty"`
+	// Restrict lists the SKIP and JUMP restrictions on seeking past or into the interstitial.
+	Restrict  []string  `json:"restrict,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type InterstitialAsset struct {
+	Uri      string  `json:"uri"`
+	Duration float64 `json:"duration"`
+}
+
+// AssetList is the JSON document the X-ASSET-LIST of an interstitial resolves to.
+type AssetList struct {
+	Assets []*AssetListEntry `json:"ASSETS"`
+}
+
+type AssetListEntry struct {
+	Uri      string  `json:"URI"`
+	Duration float64 `json:"DURATION"`
+}
+
+// AssetList returns the asset list of the pod of the interstitial.
+func (i *Interstitial) AssetList() *AssetList {
+	list := &AssetList{Assets: make([]*AssetListEntry, 0, len(i.Assets))}
+	for _, asset := range i.Assets {
+		list.Assets = append(list.Assets, &AssetListEntry{Uri: asset.Uri, Duration: asset.Duration})
+	}
+	return list
+}
@@ -0,0 +1,74 @@
+package model
+
+import "time"
+
+type MultivariantPlaylist struct {
+	Id         string       `json:"id"`
+	Version    int          `json:"version"`
+	Variants   []*Variant   `json:"variants"`
+	Renditions []*Rendition `json:"renditions"`
+	FenceToken int64        `json:"fenceToken,omitempty"`
+	UpdatedAt  time.Time    `json:"updatedAt"`
+}
+
+type Variant struct {
+	Id        string `json:"id"`
+	CacheKey  string `json:"cacheKey"`
+	Codecs    string `json:"codecs"`
+	Bandwidth int    `json:"bandwidth"`
+	Audio     string `json:"audio,omitempty"`
+	Subtitles string `json:"subtitles,omitempty"`
+	// ClosedCaptions is the group of the CLOSED-CAPTIONS renditions carried in the media of the variant.
+	ClosedCaptions string `json:"closedCaptions,omitempty"`
+	// Resolution, FrameRate and VideoRange are measured on the media unless the publisher declares them.
+	Resolution string  `json:"resolution,omitempty"`
+	FrameRate  float64 `json:"frameRate,omitempty"`
+	VideoRange string  `json:"videoRange,omitempty"`
+}
+
+type Rendition struct {
+	Id         string `json:"id"`
+	CacheKey   string `json:"cacheKey"`
+	Type       string `json:"type"`
+	GroupId    string `json:"groupId"`
+	Name       string `json:"name"`
+	Language   string `json:"language,omitempty"`
+	Codecs     string `json:"codecs,omitempty"`
+	IsDefault  bool   `json:"isDefault,omitempty"`
+	AutoSelect bool   `json:"autoSelect,omitempty"`
+	// InstreamId names the channel or service of a CLOSED-CAPTIONS rendition, which has no media playlist.
+	InstreamId string `json:"instreamId,omitempty"`
+}
+
+func (m *MultivariantPlaylist) Variant(id string) *Variant {
+	for _, variant := range m.Variants {
+		if variant.Id == id {
+			return variant
+		}
+	}
+	return nil
+}
+
+func (m *MultivariantPlaylist) Rendition(id string) *Rendition {
+	for _, rendition := range m.Renditions {
+		if rendition.Id == id {
+			return rendition
+		}
+	}
+	return nil
+}
+
+// MediaCacheKeys returns the cache keys of the media playlists of the variants and renditions.
+func (m *MultivariantPlaylist) MediaCacheKeys() []string {
+	var cacheKeys []string
+	for _, variant := range m.Variants {
+		cacheKeys = append(cacheKeys, variant.CacheKey)
+	}
+	for _, rendition := range m.Renditions {
+		// Closed captions are carried within the variants.
+		if rendition.CacheKey != "" {
+			cacheKeys = append(cacheKeys, rendition.CacheKey)
+		}
+	}
+	return cacheKeys
+}
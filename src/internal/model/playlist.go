@@ -0,0 +1,428 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+type MediaPlaylist struct {
+	Id                 string  `json:"id"`
+	PlaylistId         string  `json:"playlistId"`
+	CacheKey           string  `json:"cacheKey"`
+	InitCacheKey       string  `json:"initCacheKey,omitempty"`
+	MimeType           string  `json:"mimeType,omitempty"`
+	TargetDuration     int     `json:"targetDuration"`
+	TargetPartDuration float64 `json:"targetPartDuration"`
+	Window             Window  `json:"window"`
+	// Tracks are the tracks of the current initialization section.
+	Tracks        []*Track `json:"tracks,omitempty"`
+	MediaSequence int      `json:"mediaSequence"`
+	// DiscontinuitySequence counts the discontinuities which slid out ahead of the first segment.
+	DiscontinuitySequence int `json:"discontinuitySequence,omitempty"`
+	// NextDecodeTime is the decode time at which the media of the reference track uploaded last ends,
+	// in the timescale of that track. It is zero until such media was measured.
+	NextDecodeTime uint64 `json:"nextDecodeTime,omitempty"`
+	// ClosedCaptions are the INSTREAM-IDs of the CEA-608 and CEA-708 captions found in the video so far.
+	ClosedCaptions []string     `json:"closedCaptions,omitempty"`
+	Segments       []*Segment   `json:"segments"`
+	DateRanges     []*DateRange `json:"dateRanges,omitempty"`
+	// RecentlyRemovedDateRanges lists the ids of date ranges which left the playlist, for delta updates.
+	RecentlyRemovedDateRanges []string `json:"recentlyRemovedDateRanges,omitempty"`
+	// FenceToken is the token of the lock the playlist was last written with.
+	FenceToken int64     `json:"fenceToken,omitempty"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// Track is a track of an initialization section, see isobmff.Track.
+type Track struct {
+	Id          uint32 `json:"id"`
+	Timescale   uint32 `json:"timescale"`
+	HandlerType string `json:"handlerType"`
+	Codec       string `json:"codec,omitempty"`
+	// DefaultSampleDuration applies to the samples whose fragments declare no duration.
+	DefaultSampleDuration uint32 `json:"defaultSampleDuration,omitempty"`
+	// DefaultSampleFlags applies to the samples whose fragments declare no flags.
+	DefaultSampleFlags uint32 `json:"defaultSampleFlags,omitempty"`
+	// DefaultSampleSize applies to the samples whose fragments declare no size.
+	DefaultSampleSize uint32 `json:"defaultSampleSize,omitempty"`
+	// NALLengthSize is the size of the length prefix of the NAL units of H.264 and HEVC tracks.
+	NALLengthSize int `json:"nalLengthSize,omitempty"`
+}
+
+// Window bounds a live playlist to its last Segments complete segments and to the last Duration seconds
+// of complete segments, whichever is stricter. A zero bound leaves it out.
+type Window struct {
+	Segments int     `json:"segments,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// IsZero reports whether the window bounds nothing.
+func (w Window) IsZero() bool {
+	return w.Segments <= 0 && w.Duration <= 0
+}
+
+type Segment struct {
+	Id              string    `json:"id"`
+	Sequence        int       `json:"sequence"`
+	Duration        float64   `json:"duration,omitempty"`
+	Discontinuity   bool      `json:"discontinuity,omitempty"`
+	ProgramDateTime time.Time `json:"programDateTime"`
+	// DecodeTime is the decode time the media of the segment starts at, in the timescale of the reference track.
+	// It is zero until the first media of the segment was measured.
+	DecodeTime   uint64 `json:"decodeTime,omitempty"`
+	InitCacheKey string `json:"initCacheKey,omitempty"`
+	CacheKey     string `json:"cacheKey"`
+	Complete     bool   `json:"complete,omitempty"`
+	// Gap marks a complete segment without media, as all of its parts were gaps or the publisher declared it one.
+	Gap   bool    `json:"gap,omitempty"`
+	Parts []*Part `json:"parts,omitempty"`
+	// Ad is set on segments whose live media was replaced by an ad.
+	Ad *AdSplice `json:"ad,omitempty"`
+	// Held keeps the parts of an incomplete segment from the players while an ad is going to replace it.
+	Held bool `json:"held,omitempty"`
+}
+
+type Part struct {
+	Id          string  `json:"id"`
+	Sequence    int     `json:"sequence"`
+	Duration    float64 `json:"duration"`
+	Independent bool    `json:"independent,omitempty"`
+	Gap         bool    `json:"gap,omitempty"`
+	CacheKey    string  `json:"cacheKey"`
+}
+
+// Track returns the track with the id, or nil when it is unknown.
+func (m *MediaPlaylist) Track(id uint32) *Track {
+	for _, track := range m.Tracks {
+		if track.Id == id {
+			return track
+		}
+	}
+	return nil
+}
+
+// Segment returns the segment with the given media sequence number, or nil when it is unknown.
+func (m *MediaPlaylist) Segment(sequence int) *Segment {
+	for _, segment := range m.Segments {
+		if segment.Sequence == sequence {
+			return segment
+		}
+	}
+	return nil
+}
+
+// UpsertSegment stores the segment ordered by its sequence and returns the stored instance.
+// An already known segment keeps its parts.
+func (m *MediaPlaylist) UpsertSegment(segment *Segment) *Segment {
+	if existing := m.Segment(segment.Sequence); existing != nil {
+		if segment.InitCacheKey != "" {
+			existing.InitCacheKey = segment.InitCacheKey
+		}
+		existing.Discontinuity = existing.Discontinuity || segment.Discontinuity
+		return existing
+	}
+
+	m.Segments = append(m.Segments, segment)
+	sort.SliceStable(m.Segments, func(i, j int) bool {
+		return m.Segments[i].Sequence < m.Segments[j].Sequence
+	})
+	return segment
+}
+
+// Part returns the part with the given sequence, or nil when it is unknown.
+func (s *Segment) Part(sequence int) *Part {
+	for _, part := range s.Parts {
+		if part.Sequence == sequence {
+			return part
+		}
+	}
+	return nil
+}
+
+// PublishedParts returns the parts players may follow, which are none while the segment is held.
+func (s *Segment) PublishedParts() []*Part {
+	if s.Held {
+		return nil
+	}
+	return s.Parts
+}
+
+// GapsOnly tells whether the segment has parts and all of them are gaps.
+func (s *Segment) GapsOnly() bool {
+	for _, part := range s.Parts {
+		if !part.Gap {
+			return false
+		}
+	}
+	return len(s.Parts) > 0
+}
+
+// UpsertPart stores the part ordered by its sequence, replacing a part with the same sequence.
+func (s *Segment) UpsertPart(part *Part) {
+	for i, existing := range s.Parts {
+		if existing.Sequence == part.Sequence {
+			s.Parts[i] = part
+			return
+		}
+	}
+
+	s.Parts = append(s.Parts, part)
+	sort.SliceStable(s.Parts, func(i, j int) bool {
+		return s.Parts[i].Sequence < s.Parts[j].Sequence
+	})
+}
+
+// CurrentDuration returns the duration of a complete segment, or the duration of the parts uploaded so far.
+func (s *Segment) CurrentDuration() float64 {
+	if s.Complete {
+		return s.Duration
+	}
+	duration := 0.0
+	for _, part := range s.Parts {
+		duration += part.Duration
+	}
+	return duration
+}
+
+// ProgramDateTimes returns the program date time each segment starts at, carrying the latest one the publisher sent
+// forward by the durations in between, along with the time the media the playlist lists ends at.
+// The times are zero until the publisher sent a program date time.
+func (m *MediaPlaylist) ProgramDateTimes() ([]time.Time, time.Time) {
+	starts := make([]time.Time, len(m.Segments))
+	var next time.Time
+	for index, segment := range m.Segments {
+		if !segment.ProgramDateTime.IsZero() {
+			next = segment.ProgramDateTime
+		}
+		starts[index] = next
+		if !next.IsZero() {
+			next = next.Add(Seconds(segment.CurrentDuration()))
+		}
+	}
+	return starts, next
+}
+
+// Seconds converts a duration in seconds as playlists carry them, rounding to the nanosecond.
+func Seconds(value float64) time.Duration {
+	return time.Duration(math.Round(value * float64(time.Second)))
+}
+
+// Duration returns the total duration of the complete segments.
+func (m *MediaPlaylist) Duration() float64 {
+	duration := 0.0
+	for _, segment := range m.Segments {
+		if segment.Complete {
+			duration += segment.Duration
+		}
+	}
+	return duration
+}
+
+// Slide drops the leading complete segments which left the window, advances the media sequence
+// and returns the dropped segments. A discontinuity of the segment which becomes the first one
+// is counted by the discontinuity sequence from then on. The playlist keeps at least three target durations of segments
+// and the segment at the live edge.
+func (m *MediaPlaylist) Slide(window Window) []*Segment {
+	minimum := float64(3 * m.TargetDuration)
+	count := 0
+	for _, segment := range m.Segments {
+		if segment.Complete {
+			count++
+		}
+	}
+	duration := m.Duration()
+
+	var removed []*Segment
+	for len(m.Segments) > 1 && m.Segments[0].Complete {
+		remaining := duration - m.Segments[0].Duration
+		if remaining < minimum {
+			break
+		}
+		beyondCount := window.Segments > 0 && count > window.Segments
+		beyondDuration := window.Duration > 0 && remaining >= window.Duration
+		if !beyondCount && !beyondDuration {
+			break
+		}
+
+		removed = append(removed, m.Segments[0])
+		m.Segments = m.Segments[1:]
+		if m.Segments[0].Discontinuity {
+			m.DiscontinuitySequence++
+		}
+		count--
+		duration = remaining
+	}
+
+	if len(m.Segments) > 0 {
+		m.MediaSequence = m.Segments[0].Sequence
+	}
+	return removed
+}
+
+// TrimParts drops the parts of the complete segments which end more than window seconds before
+// the live edge and returns the dropped parts.
+func (m *MediaPlaylist) TrimParts(window float64) []*Part {
+	var trimmed []*Part
+	elapsed := 0.0
+	for i := len(m.Segments) - 1; i >= 0; i-- {
+		segment := m.Segments[i]
+		if !segment.Complete {
+			continue
+		}
+		elapsed += segment.Duration
+		if elapsed > window {
+			trimmed = append(trimmed, segment.Parts...)
+			segment.Parts = nil
+		}
+	}
+	return trimmed
+}
+
+type DateRange struct {
+	Id              string    `json:"id"`
+	Class           string    `json:"class,omitempty"`
+	StartDate       time.Time `json:"startDate"`
+	EndDate         time.Time `json:"endDate,omitempty"`
+	Duration        float64   `json:"duration,omitempty"`
+	PlannedDuration float64   `json:"plannedDuration,omitempty"`
+	// Attributes holds the client defined X- attributes with their already quoted or formatted values.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// SCTE35Cmd, SCTE35Out and SCTE35In carry splice_info_sections as hexadecimal sequences.
+	// A date range with an SCTE35Out is an ad break, which ends at its end date or after its duration.
+	SCTE35Cmd string `json:"scte35Cmd,omitempty"`
+	SCTE35Out string `json:"scte35Out,omitempty"`
+	SCTE35In  string `json:"scte35In,omitempty"`
+}
+
+// MaxRecentlyRemovedDateRanges is how many ids of removed date ranges a media playlist remembers.
+const MaxRecentlyRemovedDateRanges = 32
+
+// DateRange returns the date range with the id, or nil when it is unknown.
+func (m *MediaPlaylist) DateRange(id string) *DateRange {
+	for _, dateRange := range m.DateRanges {
+		if dateRange.Id == id {
+			return dateRange
+		}
+	}
+	return nil
+}
+
+// UpsertDateRange stores the date range ordered by its start date. A date range with a known id fills in
+// the attributes it carries, as the end of an ad break is only signalled once the break is over.
+func (m *MediaPlaylist) UpsertDateRange(dateRange *DateRange) {
+	if existing := m.DateRange(dateRange.Id); existing != nil {
+		existing.merge(dateRange)
+		return
+	}
+
+	m.DateRanges = append(m.DateRanges, dateRange)
+	sort.SliceStable(m.DateRanges, func(i, j int) bool {
+		return m.DateRanges[i].StartDate.Before(m.DateRanges[j].StartDate)
+	})
+}
+
+// RemoveDateRange drops the date range with the id and remembers its id for delta updates.
+func (m *MediaPlaylist) RemoveDateRange(id string) {
+	for i, dateRange := range m.DateRanges {
+		if dateRange.Id == id {
+			m.DateRanges = append(m.DateRanges[:i], m.DateRanges[i+1:]...)
+			m.RecentlyRemovedDateRanges = append(m.RecentlyRemovedDateRanges, id)
+			if excess := len(m.RecentlyRemovedDateRanges) - MaxRecentlyRemovedDateRanges; excess > 0 {
+				m.RecentlyRemovedDateRanges = m.RecentlyRemovedDateRanges[excess:]
+			}
+			return
+		}
+	}
+}
+
+// RemoveDateRangesBefore drops the date ranges which are over by the given time, usually the start of the
+// first segment, and remembers their ids for delta updates. Date ranges without an end are over once they start.
+func (m *MediaPlaylist) RemoveDateRangesBefore(start time.Time) {
+	var expired []string
+	for _, dateRange := range m.DateRanges {
+		end := dateRange.End()
+		if end.IsZero() {
+			end = dateRange.StartDate.Add(time.Duration(dateRange.PlannedDuration * float64(time.Second)))
+		}
+		if end.Before(start) {
+			expired = append(expired, dateRange.Id)
+		}
+	}
+	for _, id := range expired {
+		m.RemoveDateRange(id)
+	}
+}
+
+// End returns the end date of the date range, or the end its duration implies, or zero when the end is unknown.
+func (d *DateRange) End() time.Time {
+	if !d.EndDate.IsZero() {
+		return d.EndDate
+	}
+	if d.Duration > 0 {
+		return d.StartDate.Add(time.Duration(d.Duration * float64(time.Second)))
+	}
+	return time.Time{}
+}
+
+func (d *DateRange) merge(update *DateRange) {
+	if d.StartDate.IsZero() {
+		d.StartDate = update.StartDate
+	}
+	if update.Class != "" {
+		d.Class = update.Class
+	}
+	if !update.EndDate.IsZero() {
+		d.EndDate = update.EndDate
+	}
+	if update.Duration > 0 {
+		d.Duration = update.Duration
+	}
+	if update.PlannedDuration > 0 {
+		d.PlannedDuration = update.PlannedDuration
+	}
+	for name, value := range update.Attributes {
+		if d.Attributes == nil {
+			d.Attributes = map[string]string{}
+		}
+		d.Attributes[name] = value
+	}
+	if update.SCTE35Cmd != "" {
+		d.SCTE35Cmd = update.SCTE35Cmd
+	}
+	if update.SCTE35Out != "" {
+		d.SCTE35Out = update.SCTE35Out
+	}
+	if update.SCTE35In != "" {
+		d.SCTE35In = update.SCTE35In
+	}
+}
+
+// RenditionReport is the live edge of a media playlist as advertised to the players of its siblings.
+type RenditionReport struct {
+	CacheKey string `json:"cacheKey"`
+	LastMsn  int    `json:"lastMsn"`
+	LastPart int    `json:"lastPart"`
+}
+
+// RenditionReport returns the live edge of the playlist, or nil while it carries no segment.
+func (m *MediaPlaylist) RenditionReport() *RenditionReport {
+	if len(m.Segments) == 0 {
+		return nil
+	}
+
+	last := m.Segments[len(m.Segments)-1]
+	// The players see no more of a held segment than of a segment not uploaded yet.
+	if last.Held && len(m.Segments) > 1 {
+		last = m.Segments[len(m.Segments)-2]
+	}
+	report := &RenditionReport{
+		CacheKey: m.CacheKey,
+		LastMsn:  last.Sequence,
+		LastPart: -1,
+	}
+	if parts := last.PublishedParts(); len(parts) > 0 {
+		report.LastPart = parts[len(parts)-1].Sequence
+	}
+	return report
+}
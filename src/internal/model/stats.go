@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Part attributes a Discrepancy is about.
+const (
+	DiscrepancyDuration    = "duration"
+	DiscrepancyIndependent = "independent"
+)
+
+// Stream stats counting the gaps of a master playlist.
+const (
+	StatGapParts    = "gapParts"
+	StatGapSegments = "gapSegments"
+)
+
+// Discrepancy is a part attribute which the publisher declared differently from what its media shows.
+type Discrepancy struct {
+	MediaPlaylistId string    `json:"mediaPlaylistId"`
+	SegmentSequence int       `json:"segmentSequence"`
+	PartSequence    int       `json:"partSequence"`
+	Attribute       string    `json:"attribute"`
+	Declared        string    `json:"declared"`
+	Measured        string    `json:"measured"`
+	Corrected       bool      `json:"corrected"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
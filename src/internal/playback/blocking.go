@@ -0,0 +1,131 @@
+package playback
+
+import (
+	"context"
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"strconv"
+	"time"
+)
+
+// blockingTimeout is the number of target durations a blocking request is held at most.
+const blockingTimeout = 3
+
+var (
+	ErrInvalidBlockingRequest = fmt.Errorf("%d: invalid blocking playlist reload", 400)
+	ErrBlockingRequestTimeout = fmt.Errorf("%d: blocking playlist reload timed out", 503)
+)
+
+type BlockingRequest struct {
+	Msn  int
+	Part int
+	// HasPart is false when only _HLS_msn is requested, which is satisfied by the complete segment.
+	HasPart bool
+}
+
+// ParseBlockingRequest reads the _HLS_msn and _HLS_part delivery directives.
+// It returns nil when the request does not ask for a blocking reload.
+func ParseBlockingRequest(query map[string]string) (*BlockingRequest, error) {
+	msn, hasMsn := query["_HLS_msn"]
+	part, hasPart := query["_HLS_part"]
+	if !hasMsn {
+		if hasPart {
+			return nil, fmt.Errorf("%w: _HLS_part requires _HLS_msn", ErrInvalidBlockingRequest)
+		}
+		return nil, nil
+	}
+
+	request := &BlockingRequest{}
+	var err error
+	request.Msn, err = strconv.Atoi(msn)
+	if err != nil || request.Msn < 0 {
+		return nil, fmt.Errorf("%w: _HLS_msn=%s", ErrInvalidBlockingRequest, msn)
+	}
+	if hasPart {
+		request.HasPart = true
+		request.Part, err = strconv.Atoi(part)
+		if err != nil || request.Part < 0 {
+			return nil, fmt.Errorf("%w: _HLS_part=%s", ErrInvalidBlockingRequest, part)
+		}
+	}
+	return request, nil
+}
+
+// SatisfiedBy reports whether the playlist already contains the requested segment or part.
+func (r *BlockingRequest) SatisfiedBy(playlist *model.MediaPlaylist) bool {
+	for i := len(playlist.Segments) - 1; i >= 0; i-- {
+		segment := playlist.Segments[i]
+		parts := segment.PublishedParts()
+		if segment.Sequence > r.Msn {
+			return segment.Complete || len(parts) > 0
+		}
+		if segment.Sequence < r.Msn {
+			return false
+		}
+		if segment.Complete {
+			return true
+		}
+		if !r.HasPart || len(parts) == 0 {
+			return false
+		}
+		return parts[len(parts)-1].Sequence >= r.Part
+	}
+	return false
+}
+
+// tooFarAhead reports whether the request addresses a segment further than two segments
+// beyond the live edge, which the server answers with an error instead of blocking.
+func (r *BlockingRequest) tooFarAhead(playlist *model.MediaPlaylist) bool {
+	last := playlist.MediaSequence - 1
+	if len(playlist.Segments) > 0 {
+		last = playlist.Segments[len(playlist.Segments)-1].Sequence
+	}
+	return r.Msn > last+2
+}
+
+// AwaitMediaPlaylist returns the media playlist stored under the cache key once it satisfies the request.
+// Waiting is driven by the update notifications of the repository and gives up after three target durations.
+func AwaitMediaPlaylist(ctx context.Context, repo *repository.StreamRepository, cacheKey string, request *BlockingRequest) (*model.MediaPlaylist, error) {
+	if request == nil {
+		return repo.GetMediaPlaylist(ctx, cacheKey)
+	}
+
+	subscription := repo.SubscribeMediaPlaylist(ctx, cacheKey)
+	defer subscription.Close()
+	_, err := subscription.Receive(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := repo.GetMediaPlaylist(ctx, cacheKey)
+	if err != nil {
+		return nil, err
+	}
+	if request.SatisfiedBy(playlist) {
+		return playlist, nil
+	}
+	if request.tooFarAhead(playlist) {
+		return nil, fmt.Errorf("%w: _HLS_msn=%d is too far ahead of the live edge", ErrInvalidBlockingRequest, request.Msn)
+	}
+
+	timeout := time.NewTimer(time.Duration(blockingTimeout*playlist.TargetDuration) * time.Second)
+	defer timeout.Stop()
+	updates := subscription.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout.C:
+			return nil, ErrBlockingRequestTimeout
+		case <-updates:
+			playlist, err = repo.GetMediaPlaylist(ctx, cacheKey)
+			if err != nil {
+				return nil, err
+			}
+			if request.SatisfiedBy(playlist) {
+				return playlist, nil
+			}
+		}
+	}
+}
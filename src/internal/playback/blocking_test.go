@@ -0,0 +1,145 @@
+package playback
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testCacheKey = "932ac3aa-b11f-11ed-afa1-0242ac120002/a3e4e680-b11f-11ed-afa1-0242ac120002"
+
+func newTestRepository(t *testing.T) *repository.StreamRepository {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return repository.NewStreamRepository(client)
+}
+
+func generateTestMediaPlaylist(targetDuration int, parts ...int) *model.MediaPlaylist {
+	playlist := &model.MediaPlaylist{
+		Id:                 "a3e4e680-b11f-11ed-afa1-0242ac120002",
+		PlaylistId:         "932ac3aa-b11f-11ed-afa1-0242ac120002",
+		CacheKey:           testCacheKey,
+		TargetDuration:     targetDuration,
+		TargetPartDuration: 1,
+		UpdatedAt:          time.Now(),
+	}
+	for i, count := range parts {
+		segment := &model.Segment{Sequence: 10 + i, Complete: i < len(parts)-1}
+		for p := 0; p < count; p++ {
+			segment.Parts = append(segment.Parts, &model.Part{Sequence: p, CacheKey: "part-" + strconv.Itoa(p)})
+		}
+		playlist.Segments = append(playlist.Segments, segment)
+	}
+	return playlist
+}
+
+func TestParseBlockingRequest(t *testing.T) {
+	cases := []struct {
+		Query    map[string]string
+		Expected *BlockingRequest
+		Err      error
+	}{
+		{Query: map[string]string{}},
+		{Query: map[string]string{"_HLS_msn": "12"}, Expected: &BlockingRequest{Msn: 12}},
+		{Query: map[string]string{"_HLS_msn": "12", "_HLS_part": "3"}, Expected: &BlockingRequest{Msn: 12, Part: 3, HasPart: true}},
+		{Query: map[string]string{"_HLS_part": "3"}, Err: ErrInvalidBlockingRequest},
+		{Query: map[string]string{"_HLS_msn": "-1"}, Err: ErrInvalidBlockingRequest},
+		{Query: map[string]string{"_HLS_msn": "12", "_HLS_part": "x"}, Err: ErrInvalidBlockingRequest},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			got, err := ParseBlockingRequest(c.Query)
+			assert.ErrorIs(t, err, c.Err)
+			assert.Equal(t, c.Expected, got)
+		})
+	}
+}
+
+func TestBlockingRequest_SatisfiedBy(t *testing.T) {
+	playlist := generateTestMediaPlaylist(4, 4, 2)
+
+	cases := []struct {
+		Request  *BlockingRequest
+		Expected bool
+	}{
+		{Request: &BlockingRequest{Msn: 10}, Expected: true},
+		{Request: &BlockingRequest{Msn: 11}, Expected: false},
+		{Request: &BlockingRequest{Msn: 11, Part: 1, HasPart: true}, Expected: true},
+		{Request: &BlockingRequest{Msn: 11, Part: 2, HasPart: true}, Expected: false},
+		{Request: &BlockingRequest{Msn: 10, Part: 7, HasPart: true}, Expected: true},
+		{Request: &BlockingRequest{Msn: 12, Part: 0, HasPart: true}, Expected: false},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			assert.Equal(t, c.Expected, c.Request.SatisfiedBy(playlist))
+		})
+	}
+
+	// The parts of a held segment do not satisfy a request before it completes.
+	held := generateTestMediaPlaylist(4, 4, 2)
+	held.Segments[1].Held = true
+	assert.False(t, (&BlockingRequest{Msn: 11, Part: 1, HasPart: true}).SatisfiedBy(held))
+	assert.False(t, (&BlockingRequest{Msn: 10, Part: 7, HasPart: true}).SatisfiedBy(held))
+}
+
+func TestAwaitMediaPlaylist(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	require.NoError(t, repo.SetMediaPlaylist(ctx, generateTestMediaPlaylist(4, 4, 2)))
+
+	done := make(chan *model.MediaPlaylist)
+	go func() {
+		playlist, err := AwaitMediaPlaylist(ctx, repo, testCacheKey, &BlockingRequest{Msn: 11, Part: 2, HasPart: true})
+		assert.NoError(t, err)
+		done <- playlist
+	}()
+
+	// Unrelated updates must not release the request.
+	require.NoError(t, repo.SetMediaPlaylist(ctx, generateTestMediaPlaylist(4, 4, 2)))
+	select {
+	case <-done:
+		t.Fatal("blocking request released before the part was ingested")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, repo.SetMediaPlaylist(ctx, generateTestMediaPlaylist(4, 4, 3)))
+	select {
+	case playlist := <-done:
+		assert.Len(t, playlist.Segments[1].Parts, 3)
+	case <-time.After(time.Second):
+		t.Fatal("blocking request was not released by the update notification")
+	}
+}
+
+func TestAwaitMediaPlaylistErrors(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	require.NoError(t, repo.SetMediaPlaylist(ctx, generateTestMediaPlaylist(0, 4, 2)))
+
+	cases := []struct {
+		CacheKey string
+		Request  *BlockingRequest
+		Expected error
+	}{
+		{CacheKey: testCacheKey, Request: &BlockingRequest{Msn: 11, Part: 5, HasPart: true}, Expected: ErrBlockingRequestTimeout},
+		{CacheKey: testCacheKey, Request: &BlockingRequest{Msn: 14}, Expected: ErrInvalidBlockingRequest},
+		{CacheKey: "unknown", Request: &BlockingRequest{Msn: 11}, Expected: repository.ErrMediaPlaylistNotFound},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			_, err := AwaitMediaPlaylist(ctx, repo, c.CacheKey, c.Request)
+			assert.ErrorIs(t, err, c.Expected)
+		})
+	}
+}
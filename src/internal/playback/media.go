@@ -0,0 +1,82 @@
+package playback
+
+import (
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"strings"
+)
+
+var ErrUnknownMedia = fmt.Errorf("%d: unknown media", 404)
+
+type MediaKind string
+
+const (
+	MediaKindInit    MediaKind = "init"
+	MediaKindSegment MediaKind = "segment"
+	MediaKindPart    MediaKind = "part"
+)
+
+// MediaRequest addresses media data by the URIs the media playlist renderer hands out.
+type MediaRequest struct {
+	Kind MediaKind
+	Id   string
+	Msn  int
+	Part int
+}
+
+func ParseMediaRequest(file string) (*MediaRequest, error) {
+	if id, found := strings.CutSuffix(file, ".mp4"); found && id != "" {
+		return &MediaRequest{Kind: MediaKindInit, Id: id}, nil
+	}
+
+	request := &MediaRequest{}
+	if _, err := fmt.Sscanf(file, "part-%d.%d.m4s", &request.Msn, &request.Part); err == nil {
+		request.Kind = MediaKindPart
+		return request, nil
+	}
+	// Subtitle playlists of plain WebVTT files name their segments with the .vtt extension.
+	for _, format := range []string{"segment-%d.m4s", "segment-%d.vtt"} {
+		if _, err := fmt.Sscanf(file, format, &request.Msn); err == nil {
+			request.Kind = MediaKindSegment
+			return request, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownMedia, file)
+}
+
+// BlockingRequest is the reload a part or segment request waits for, so preload hints can be served
+// as soon as the publisher uploads them.
+func (r *MediaRequest) BlockingRequest() *BlockingRequest {
+	switch r.Kind {
+	case MediaKindPart:
+		return &BlockingRequest{Msn: r.Msn, Part: r.Part, HasPart: true}
+	case MediaKindSegment:
+		return &BlockingRequest{Msn: r.Msn}
+	}
+	return nil
+}
+
+// CacheKey resolves the key the requested media data is stored under.
+func (r *MediaRequest) CacheKey(playlist *model.MediaPlaylist) (string, error) {
+	if r.Kind == MediaKindInit {
+		return playlist.PlaylistId + "/" + r.Id, nil
+	}
+
+	segment := playlist.Segment(r.Msn)
+	if segment == nil {
+		return "", ErrUnknownMedia
+	}
+	if r.Kind == MediaKindSegment {
+		if !segment.Complete || segment.Gap {
+			return "", ErrUnknownMedia
+		}
+		return segment.CacheKey, nil
+	}
+
+	for _, part := range segment.PublishedParts() {
+		if part.Sequence == r.Part && !part.Gap {
+			return part.CacheKey, nil
+		}
+	}
+	return "", ErrUnknownMedia
+}
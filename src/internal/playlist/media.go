@@ -0,0 +1,353 @@
+package playlist
+
+import (
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// PartWindow is the number of target durations from the live edge for which parts are advertised.
+	PartWindow = 3
+	// skipWindow is the number of target durations from the live edge a delta update has to keep.
+	skipWindow = 6
+)
+
+type Skip string
+
+const (
+	SkipNone                  Skip = ""
+	SkipSegments              Skip = "YES"
+	SkipSegmentsAndDateRanges Skip = "v2"
+)
+
+var ErrInvalidSkip = fmt.Errorf("%d: invalid _HLS_skip directive", 400)
+
+func ParseSkip(value string) (Skip, error) {
+	switch skip := Skip(value); skip {
+	case SkipNone, SkipSegments, SkipSegmentsAndDateRanges:
+		return skip, nil
+	}
+	return SkipNone, fmt.Errorf("%w: %s", ErrInvalidSkip, value)
+}
+
+type Media struct {
+	Playlist *model.MediaPlaylist
+	// Skip requests a delta update which leaves out the segments older than the skip boundary.
+	Skip Skip
+	// RenditionReports are the live edges of the sibling variants and renditions of the master playlist.
+	RenditionReports []*model.RenditionReport
+	// VOD renders the finished playlist for replay, without any of the low-latency tags.
+	VOD bool
+}
+
+func NewMedia(playlist *model.MediaPlaylist) *Media {
+	return &Media{
+		Playlist: playlist,
+	}
+}
+
+func (m *Media) String() string {
+	p := m.Playlist
+	b := &strings.Builder{}
+
+	skipped := m.skippedSegments()
+	version := Version
+	if m.hasGaps(skipped) {
+		version = 8
+	}
+	if skipped > 0 {
+		version = 9
+		if m.Skip == SkipSegmentsAndDateRanges {
+			version = 10
+		}
+	}
+
+	fmt.Fprintln(b, "#EXTM3U")
+	fmt.Fprintf(b, "#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(b, "#EXT-X-TARGETDURATION:%d\n", p.TargetDuration)
+	if m.VOD {
+		fmt.Fprintln(b, "#EXT-X-PLAYLIST-TYPE:VOD")
+	} else {
+		control := fmt.Sprintf("CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=%s,CAN-SKIP-DATERANGES=YES",
+			formatDuration(float64(skipWindow*p.TargetDuration)))
+		// Renditions without parts, such as subtitles, carry no part related tags.
+		if p.TargetPartDuration > 0 {
+			control += ",PART-HOLD-BACK=" + formatDuration(PartWindow*p.TargetPartDuration)
+		}
+		fmt.Fprintf(b, "#EXT-X-SERVER-CONTROL:%s\n", control)
+		if p.TargetPartDuration > 0 {
+			fmt.Fprintf(b, "#EXT-X-PART-INF:PART-TARGET=%s\n", formatDuration(p.TargetPartDuration))
+		}
+	}
+	fmt.Fprintf(b, "#EXT-X-MEDIA-SEQUENCE:%d\n", m.mediaSequence())
+	if p.DiscontinuitySequence > 0 {
+		fmt.Fprintf(b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.DiscontinuitySequence)
+	}
+
+	if skipped > 0 {
+		skip := fmt.Sprintf("SKIPPED-SEGMENTS=%d", skipped)
+		if m.Skip == SkipSegmentsAndDateRanges && len(p.RecentlyRemovedDateRanges) > 0 {
+			skip += fmt.Sprintf(",RECENTLY-REMOVED-DATERANGES=\"%s\"", strings.Join(p.RecentlyRemovedDateRanges, "\t"))
+		}
+		fmt.Fprintf(b, "#EXT-X-SKIP:%s\n", skip)
+	}
+
+	for _, dateRange := range m.dateRanges(skipped) {
+		writeDateRange(b, dateRange)
+	}
+
+	cues := m.cues()
+	partsFrom := m.partsFrom()
+	initCacheKey := ""
+	for i := skipped; i < len(p.Segments); i++ {
+		segment := p.Segments[i]
+		// The discontinuity of the first segment is counted by the discontinuity sequence instead.
+		if segment.Discontinuity && i > 0 {
+			fmt.Fprintln(b, "#EXT-X-DISCONTINUITY")
+		}
+		if segment.InitCacheKey != "" && segment.InitCacheKey != initCacheKey {
+			initCacheKey = segment.InitCacheKey
+			fmt.Fprintf(b, "#EXT-X-MAP:URI=\"%s\"\n", InitURI(initCacheKey))
+		}
+		if !segment.ProgramDateTime.IsZero() {
+			fmt.Fprintf(b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", formatProgramDateTime(segment.ProgramDateTime))
+		}
+		for _, cue := range cues[i] {
+			fmt.Fprintln(b, cue)
+		}
+		if i >= partsFrom {
+			for _, part := range segment.PublishedParts() {
+				writePart(b, segment, part)
+			}
+		}
+		if segment.Complete {
+			if segment.Gap {
+				fmt.Fprintln(b, "#EXT-X-GAP")
+			}
+			fmt.Fprintf(b, "#EXTINF:%s,\n", formatDuration(segment.Duration))
+			fmt.Fprintln(b, SegmentURI(segment.Sequence, p.MimeType))
+		}
+	}
+
+	if m.VOD {
+		fmt.Fprintln(b, "#EXT-X-ENDLIST")
+		return b.String()
+	}
+
+	if sequence, part, ok := m.nextPart(); ok && p.TargetPartDuration > 0 {
+		fmt.Fprintf(b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", PartURI(sequence, part))
+	}
+
+	for _, report := range m.renditionReports() {
+		attributes := []string{
+			fmt.Sprintf("URI=\"../%s\"", MediaPlaylistURI(report.CacheKey)),
+			fmt.Sprintf("LAST-MSN=%d", report.LastMsn),
+		}
+		if report.LastPart >= 0 {
+			attributes = append(attributes, fmt.Sprintf("LAST-PART=%d", report.LastPart))
+		}
+		fmt.Fprintf(b, "#EXT-X-RENDITION-REPORT:%s\n", strings.Join(attributes, ","))
+	}
+
+	return b.String()
+}
+
+func writePart(b *strings.Builder, segment *model.Segment, part *model.Part) {
+	attributes := []string{
+		"DURATION=" + formatDuration(part.Duration),
+		fmt.Sprintf("URI=\"%s\"", PartURI(segment.Sequence, part.Sequence)),
+	}
+	if part.Independent {
+		attributes = append(attributes, "INDEPENDENT=YES")
+	}
+	if part.Gap {
+		attributes = append(attributes, "GAP=YES")
+	}
+	fmt.Fprintf(b, "#EXT-X-PART:%s\n", strings.Join(attributes, ","))
+}
+
+func writeDateRange(b *strings.Builder, dateRange *model.DateRange) {
+	attributes := []string{
+		fmt.Sprintf("ID=\"%s\"", dateRange.Id),
+	}
+	if dateRange.Class != "" {
+		attributes = append(attributes, fmt.Sprintf("CLASS=\"%s\"", dateRange.Class))
+	}
+	attributes = append(attributes, fmt.Sprintf("START-DATE=\"%s\"", formatProgramDateTime(dateRange.StartDate)))
+	if !dateRange.EndDate.IsZero() {
+		attributes = append(attributes, fmt.Sprintf("END-DATE=\"%s\"", formatProgramDateTime(dateRange.EndDate)))
+	}
+	if dateRange.Duration > 0 {
+		attributes = append(attributes, "DURATION="+formatDuration(dateRange.Duration))
+	}
+	if dateRange.PlannedDuration > 0 {
+		attributes = append(attributes, "PLANNED-DURATION="+formatDuration(dateRange.PlannedDuration))
+	}
+
+	names := make([]string, 0, len(dateRange.Attributes))
+	for name := range dateRange.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		attributes = append(attributes, name+"="+dateRange.Attributes[name])
+	}
+	if dateRange.SCTE35Cmd != "" {
+		attributes = append(attributes, "SCTE35-CMD="+dateRange.SCTE35Cmd)
+	}
+	if dateRange.SCTE35Out != "" {
+		attributes = append(attributes, "SCTE35-OUT="+dateRange.SCTE35Out)
+	}
+	if dateRange.SCTE35In != "" {
+		attributes = append(attributes, "SCTE35-IN="+dateRange.SCTE35In)
+	}
+	fmt.Fprintf(b, "#EXT-X-DATERANGE:%s\n", strings.Join(attributes, ","))
+}
+
+// hasGaps tells whether any of the listed segments is a gap, which takes protocol version 8.
+func (m *Media) hasGaps(skipped int) bool {
+	for _, segment := range m.Playlist.Segments[skipped:] {
+		if segment.Complete && segment.Gap {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Media) mediaSequence() int {
+	if len(m.Playlist.Segments) == 0 {
+		return m.Playlist.MediaSequence
+	}
+	return m.Playlist.Segments[0].Sequence
+}
+
+// partsFrom returns the index of the first segment whose parts are still advertised.
+func (m *Media) partsFrom() int {
+	if m.VOD {
+		return len(m.Playlist.Segments)
+	}
+
+	segments := m.Playlist.Segments
+	window := float64(PartWindow * m.Playlist.TargetDuration)
+	elapsed := 0.0
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i].Complete {
+			elapsed += segments[i].Duration
+		}
+		if elapsed > window {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// skippedSegments returns the number of leading segments a delta update leaves out.
+// Only segments starting before the skip boundary, skipWindow target durations from the live edge, are skipped.
+func (m *Media) skippedSegments() int {
+	if m.Skip == SkipNone || m.VOD {
+		return 0
+	}
+
+	segments := m.Playlist.Segments
+	window := float64(skipWindow * m.Playlist.TargetDuration)
+	elapsed := 0.0
+	for i := len(segments) - 1; i >= 0; i-- {
+		elapsed += segments[i].CurrentDuration()
+		if elapsed > window {
+			return i
+		}
+	}
+	return 0
+}
+
+// dateRanges returns the date ranges to render. A v2 delta update leaves out those starting before
+// the first segment it carries, since the client already received them with an earlier reload.
+func (m *Media) dateRanges(skipped int) []*model.DateRange {
+	if skipped == 0 || m.Skip != SkipSegmentsAndDateRanges {
+		return m.Playlist.DateRanges
+	}
+
+	boundary := m.Playlist.Segments[skipped].ProgramDateTime
+	var dateRanges []*model.DateRange
+	for _, dateRange := range m.Playlist.DateRanges {
+		if !dateRange.StartDate.Before(boundary) {
+			dateRanges = append(dateRanges, dateRange)
+		}
+	}
+	return dateRanges
+}
+
+// cues returns the EXT-X-CUE-OUT and EXT-X-CUE-IN tags of the ad breaks among the date ranges by the index of the
+// segment which spans their start and end on the program date time, for players which do not read SCTE35-OUT.
+func (m *Media) cues() map[int][]string {
+	starts, _ := m.Playlist.ProgramDateTimes()
+	cues := map[int][]string{}
+	// A tag goes to the last segment starting by its time, unless the time lies beyond the media of that segment.
+	place := func(at time.Time, tag string) {
+		for i := len(starts) - 1; i >= 0; i-- {
+			if starts[i].IsZero() || at.Before(starts[i]) {
+				continue
+			}
+			if at.Before(starts[i].Add(model.Seconds(m.Playlist.Segments[i].CurrentDuration()))) {
+				cues[i] = append(cues[i], tag)
+			}
+			return
+		}
+	}
+
+	for _, dateRange := range m.Playlist.DateRanges {
+		if dateRange.SCTE35Out == "" {
+			continue
+		}
+		duration := dateRange.Duration
+		if end := dateRange.End(); !end.IsZero() {
+			duration = end.Sub(dateRange.StartDate).Seconds()
+		} else if duration == 0 {
+			duration = dateRange.PlannedDuration
+		}
+		if duration > 0 {
+			place(dateRange.StartDate, "#EXT-X-CUE-OUT:"+formatDuration(duration))
+		} else {
+			place(dateRange.StartDate, "#EXT-X-CUE-OUT")
+		}
+		if end := dateRange.End(); !end.IsZero() {
+			place(end, "#EXT-X-CUE-IN")
+		}
+	}
+	return cues
+}
+
+// renditionReports orders the reports of the siblings by their cache key, leaving out the playlist itself.
+func (m *Media) renditionReports() []*model.RenditionReport {
+	var reports []*model.RenditionReport
+	for _, report := range m.RenditionReports {
+		if report.CacheKey != m.Playlist.CacheKey {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].CacheKey < reports[j].CacheKey
+	})
+	return reports
+}
+
+// nextPart returns the address of the part the publisher is expected to upload next.
+// There is none to hint at while the last segment is held, as an ad without parts is going to replace it.
+func (m *Media) nextPart() (int, int, bool) {
+	segments := m.Playlist.Segments
+	if len(segments) == 0 || segments[len(segments)-1].Held {
+		return 0, 0, false
+	}
+
+	last := segments[len(segments)-1]
+	if last.Complete {
+		return last.Sequence + 1, 0, true
+	}
+	if len(last.Parts) == 0 {
+		return last.Sequence, 0, true
+	}
+	return last.Sequence, last.Parts[len(last.Parts)-1].Sequence + 1, true
+}
@@ -0,0 +1,289 @@
+package playlist
+
+import (
+	"flag"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func assertGolden(t *testing.T, name string, got string) {
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		require.NoError(t, os.WriteFile(golden, []byte(got), 0644))
+	}
+
+	expected, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), got)
+}
+
+func generateTestSegment(sequence int, parts int, complete bool) *model.Segment {
+	segment := &model.Segment{
+		Id:              "segment-" + strconv.Itoa(sequence),
+		Sequence:        sequence,
+		ProgramDateTime: time.Unix(1676898433, 0).Add(time.Duration(sequence) * 4 * time.Second),
+		InitCacheKey:    "932ac3aa-b11f-11ed-afa1-0242ac120002/c9258c1e-b120-11ed-afa1-0242ac120002",
+		CacheKey:        "932ac3aa-b11f-11ed-afa1-0242ac120002/segment-" + strconv.Itoa(sequence),
+		Complete:        complete,
+	}
+	for i := 0; i < parts; i++ {
+		segment.Parts = append(segment.Parts, &model.Part{
+			Id:          "part-" + strconv.Itoa(i),
+			Sequence:    i,
+			Duration:    1.001,
+			Independent: i == 0,
+		})
+	}
+	if complete {
+		segment.Duration = 1.001 * float64(parts)
+	}
+	return segment
+}
+
+func generateTestMediaPlaylist(segments ...*model.Segment) *model.MediaPlaylist {
+	return &model.MediaPlaylist{
+		Id:                 "a3e4e680-b11f-11ed-afa1-0242ac120002",
+		PlaylistId:         "932ac3aa-b11f-11ed-afa1-0242ac120002",
+		CacheKey:           "932ac3aa-b11f-11ed-afa1-0242ac120002/a3e4e680-b11f-11ed-afa1-0242ac120002",
+		InitCacheKey:       "932ac3aa-b11f-11ed-afa1-0242ac120002/c9258c1e-b120-11ed-afa1-0242ac120002",
+		TargetDuration:     4,
+		TargetPartDuration: 1.001,
+		Segments:           segments,
+	}
+}
+
+func TestMedia_String(t *testing.T) {
+	discontinuity := generateTestSegment(12, 4, true)
+	discontinuity.Discontinuity = true
+	discontinuity.InitCacheKey = "932ac3aa-b11f-11ed-afa1-0242ac120002/0e7b4bb6-b121-11ed-afa1-0242ac120002"
+
+	gap := generateTestSegment(13, 2, false)
+	gap.Parts[1].Gap = true
+
+	dropout := generateTestSegment(12, 4, true)
+	dropout.Gap = true
+	for _, part := range dropout.Parts {
+		part.Gap = true
+	}
+
+	// The discontinuity of the first segment left along with the segments before it.
+	rejoined := generateTestSegment(21, 4, true)
+	rejoined.Discontinuity = true
+	restarted := generateTestMediaPlaylist(rejoined, generateTestSegment(22, 4, true))
+	restarted.DiscontinuitySequence = 2
+
+	var history []*model.Segment
+	for sequence := 0; sequence < 9; sequence++ {
+		history = append(history, generateTestSegment(sequence, 4, true))
+	}
+	history = append(history, generateTestSegment(9, 2, false))
+
+	delta := generateTestMediaPlaylist(history...)
+	delta.DateRanges = []*model.DateRange{
+		{
+			Id:        "splice-1",
+			Class:     "com.mobworx.ad",
+			StartDate: history[1].ProgramDateTime,
+			Duration:  8,
+		},
+		{
+			Id:              "splice-2",
+			StartDate:       history[7].ProgramDateTime,
+			PlannedDuration: 12,
+			Attributes:      map[string]string{"X-COM-MOBWORX-BREAK": `"mid-roll"`},
+		},
+	}
+	delta.RecentlyRemovedDateRanges = []string{"splice-0", "splice-00"}
+
+	adBreak := generateTestMediaPlaylist(
+		generateTestSegment(10, 4, true),
+		generateTestSegment(11, 4, true),
+		generateTestSegment(12, 4, true),
+		generateTestSegment(13, 2, false),
+	)
+	adBreak.DateRanges = []*model.DateRange{
+		{
+			Id:              "splice-7",
+			StartDate:       adBreak.Segments[0].ProgramDateTime.Add(time.Second),
+			EndDate:         adBreak.Segments[2].ProgramDateTime,
+			PlannedDuration: 8,
+			SCTE35Out:       "0xFC3025",
+			SCTE35In:        "0xFC3026",
+		},
+		{
+			Id:              "splice-8",
+			StartDate:       adBreak.Segments[3].ProgramDateTime,
+			PlannedDuration: 30,
+			SCTE35Out:       "0xFC3027",
+		},
+		{
+			Id:        "splice-9",
+			StartDate: adBreak.Segments[1].ProgramDateTime,
+			SCTE35Cmd: "0xFC3028",
+		},
+	}
+
+	reports := []*model.RenditionReport{
+		{CacheKey: "932ac3aa-b11f-11ed-afa1-0242ac120002/d02288ec-b11f-11ed-afa1-0242ac120002", LastMsn: 12, LastPart: 1},
+		{CacheKey: "932ac3aa-b11f-11ed-afa1-0242ac120002/a3e4e680-b11f-11ed-afa1-0242ac120002", LastMsn: 12, LastPart: 1},
+		{CacheKey: "932ac3aa-b11f-11ed-afa1-0242ac120002/5e0c7a7c-b122-11ed-afa1-0242ac120002", LastMsn: 11, LastPart: -1},
+	}
+
+	subtitles := generateTestMediaPlaylist(
+		generateTestSegment(10, 0, true),
+		generateTestSegment(11, 0, true),
+	)
+	subtitles.MimeType = WebVTTContentType
+	subtitles.InitCacheKey = ""
+	subtitles.TargetPartDuration = 0
+	for _, segment := range subtitles.Segments {
+		segment.InitCacheKey = ""
+		segment.Duration = 4
+	}
+
+	cases := []struct {
+		Name     string
+		Playlist *model.MediaPlaylist
+		Skip     Skip
+		Reports  []*model.RenditionReport
+		VOD      bool
+	}{
+		{
+			Name:     "media-empty",
+			Playlist: generateTestMediaPlaylist(),
+		},
+		{
+			Name: "media-live-edge",
+			Playlist: generateTestMediaPlaylist(
+				generateTestSegment(10, 4, true),
+				generateTestSegment(11, 4, true),
+				generateTestSegment(12, 2, false),
+			),
+		},
+		{
+			Name: "media-segment-boundary",
+			Playlist: generateTestMediaPlaylist(
+				generateTestSegment(10, 4, true),
+				generateTestSegment(11, 4, true),
+			),
+		},
+		{
+			Name: "media-part-window",
+			Playlist: generateTestMediaPlaylist(
+				generateTestSegment(7, 4, true),
+				generateTestSegment(8, 4, true),
+				generateTestSegment(9, 4, true),
+				generateTestSegment(10, 4, true),
+				generateTestSegment(11, 4, true),
+				generateTestSegment(12, 1, false),
+			),
+		},
+		{
+			Name: "media-discontinuity-gap",
+			Playlist: generateTestMediaPlaylist(
+				generateTestSegment(11, 4, true),
+				discontinuity,
+				gap,
+			),
+		},
+		{
+			Name:     "media-discontinuity-sequence",
+			Playlist: restarted,
+		},
+		{
+			Name: "media-gap-segment",
+			Playlist: generateTestMediaPlaylist(
+				generateTestSegment(11, 4, true),
+				dropout,
+				generateTestSegment(13, 1, false),
+			),
+		},
+		{
+			Name: "media-rendition-reports",
+			Playlist: generateTestMediaPlaylist(
+				generateTestSegment(11, 4, true),
+				generateTestSegment(12, 2, false),
+			),
+			Reports: reports,
+		},
+		{
+			Name:     "media-full-history",
+			Playlist: delta,
+		},
+		{
+			Name:     "media-delta-skip",
+			Playlist: delta,
+			Skip:     SkipSegments,
+		},
+		{
+			Name:     "media-delta-skip-v2",
+			Playlist: delta,
+			Skip:     SkipSegmentsAndDateRanges,
+		},
+		{
+			Name:     "media-ad-break",
+			Playlist: adBreak,
+		},
+		{
+			Name: "media-delta-short-history",
+			Playlist: generateTestMediaPlaylist(
+				generateTestSegment(10, 4, true),
+				generateTestSegment(11, 2, false),
+			),
+			Skip: SkipSegments,
+		},
+		{
+			Name:     "media-subtitles",
+			Playlist: subtitles,
+		},
+		{
+			Name: "media-vod",
+			Playlist: generateTestMediaPlaylist(
+				generateTestSegment(10, 4, true),
+				discontinuity,
+				generateTestSegment(13, 4, true),
+			),
+			Reports: reports,
+			VOD:     true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			media := NewMedia(c.Playlist)
+			media.Skip = c.Skip
+			media.RenditionReports = c.Reports
+			media.VOD = c.VOD
+			assertGolden(t, c.Name, media.String())
+		})
+	}
+}
+
+func TestParseSkip(t *testing.T) {
+	cases := []struct {
+		Value    string
+		Expected Skip
+		Err      error
+	}{
+		{Value: "", Expected: SkipNone},
+		{Value: "YES", Expected: SkipSegments},
+		{Value: "v2", Expected: SkipSegmentsAndDateRanges},
+		{Value: "NO", Expected: SkipNone, Err: ErrInvalidSkip},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			got, err := ParseSkip(c.Value)
+			assert.ErrorIs(t, err, c.Err)
+			assert.Equal(t, c.Expected, got)
+		})
+	}
+}
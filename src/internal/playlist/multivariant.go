@@ -0,0 +1,159 @@
+package playlist
+
+import (
+	"fmt"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"path"
+	"sort"
+	"strings"
+)
+
+// closedCaptions is the rendition type of CEA-608 and CEA-708 captions.
+const closedCaptions = "CLOSED-CAPTIONS"
+
+type Multivariant struct {
+	Playlist *model.MultivariantPlaylist
+}
+
+func NewMultivariant(playlist *model.MultivariantPlaylist) *Multivariant {
+	return &Multivariant{
+		Playlist: playlist,
+	}
+}
+
+// MediaPlaylistURI names the media playlist of a variant or rendition relative to the multivariant playlist.
+func MediaPlaylistURI(cacheKey string) string {
+	return path.Base(cacheKey) + "/playlist.m3u8"
+}
+
+func (m *Multivariant) String() string {
+	b := &strings.Builder{}
+
+	fmt.Fprintln(b, "#EXTM3U")
+	fmt.Fprintf(b, "#EXT-X-VERSION:%d\n", Version)
+	fmt.Fprintln(b, "#EXT-X-INDEPENDENT-SEGMENTS")
+
+	for _, rendition := range m.renditions() {
+		attributes := []string{
+			"TYPE=" + rendition.Type,
+			fmt.Sprintf("GROUP-ID=\"%s\"", rendition.GroupId),
+			fmt.Sprintf("NAME=\"%s\"", rendition.Name),
+		}
+		if rendition.Language != "" {
+			attributes = append(attributes, fmt.Sprintf("LANGUAGE=\"%s\"", rendition.Language))
+		}
+		attributes = append(attributes,
+			"DEFAULT="+formatBool(rendition.IsDefault),
+			"AUTOSELECT="+formatBool(rendition.AutoSelect || rendition.IsDefault),
+		)
+		// Closed captions are carried within the video and have no media playlist of their own.
+		if rendition.Type == closedCaptions {
+			attributes = append(attributes, fmt.Sprintf("INSTREAM-ID=\"%s\"", rendition.InstreamId))
+		} else {
+			attributes = append(attributes, fmt.Sprintf("URI=\"%s\"", MediaPlaylistURI(rendition.CacheKey)))
+		}
+		fmt.Fprintf(b, "#EXT-X-MEDIA:%s\n", strings.Join(attributes, ","))
+	}
+
+	captioned := m.captioned()
+	for _, variant := range m.variants() {
+		attributes := []string{
+			fmt.Sprintf("BANDWIDTH=%d", variant.Bandwidth),
+		}
+		if codecs := formatCodecs(m.codecs(variant)); codecs != "" {
+			attributes = append(attributes, fmt.Sprintf("CODECS=\"%s\"", codecs))
+		}
+		if variant.Resolution != "" {
+			attributes = append(attributes, "RESOLUTION="+variant.Resolution)
+		}
+		if variant.FrameRate > 0 {
+			attributes = append(attributes, fmt.Sprintf("FRAME-RATE=%.3f", variant.FrameRate))
+		}
+		if variant.VideoRange != "" {
+			attributes = append(attributes, "VIDEO-RANGE="+variant.VideoRange)
+		}
+		if variant.Audio != "" {
+			attributes = append(attributes, fmt.Sprintf("AUDIO=\"%s\"", variant.Audio))
+		}
+		if variant.Subtitles != "" {
+			attributes = append(attributes, fmt.Sprintf("SUBTITLES=\"%s\"", variant.Subtitles))
+		}
+		if variant.ClosedCaptions != "" {
+			attributes = append(attributes, fmt.Sprintf("CLOSED-CAPTIONS=\"%s\"", variant.ClosedCaptions))
+		} else if !captioned {
+			attributes = append(attributes, "CLOSED-CAPTIONS=NONE")
+		}
+		fmt.Fprintf(b, "#EXT-X-STREAM-INF:%s\n", strings.Join(attributes, ","))
+		fmt.Fprintln(b, MediaPlaylistURI(variant.CacheKey))
+	}
+
+	return b.String()
+}
+
+// renditions orders the renditions by group, keeping the registration order within a group.
+func (m *Multivariant) renditions() []*model.Rendition {
+	renditions := append([]*model.Rendition{}, m.Playlist.Renditions...)
+	sort.SliceStable(renditions, func(i, j int) bool {
+		if renditions[i].Type != renditions[j].Type {
+			return renditions[i].Type < renditions[j].Type
+		}
+		return renditions[i].GroupId < renditions[j].GroupId
+	})
+	return renditions
+}
+
+// variants orders the variants by ascending bandwidth.
+func (m *Multivariant) variants() []*model.Variant {
+	variants := append([]*model.Variant{}, m.Playlist.Variants...)
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].Bandwidth < variants[j].Bandwidth
+	})
+	return variants
+}
+
+// captioned tells whether any variant carries closed captions. Variants without captions only declare
+// CLOSED-CAPTIONS=NONE when none does, as the value has to be the same across all variants.
+func (m *Multivariant) captioned() bool {
+	for _, variant := range m.Playlist.Variants {
+		if variant.ClosedCaptions != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// codecs lists the codecs of the variant along with those of the renditions of its audio and subtitles
+// groups which the variant does not list already.
+func (m *Multivariant) codecs(variant *model.Variant) string {
+	codecs := variant.Codecs
+	for _, rendition := range m.Playlist.Renditions {
+		if rendition.GroupId == "" || (rendition.GroupId != variant.Audio && rendition.GroupId != variant.Subtitles) {
+			continue
+		}
+		for _, codec := range strings.Split(rendition.Codecs, ",") {
+			if codec = strings.TrimSpace(codec); codec != "" && !hasCodec(codecs, codec) {
+				codecs += "," + codec
+			}
+		}
+	}
+	return codecs
+}
+
+func hasCodec(codecs string, codec string) bool {
+	for _, listed := range strings.Split(codecs, ",") {
+		if strings.EqualFold(strings.TrimSpace(listed), codec) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatCodecs(codecs string) string {
+	var formatted []string
+	for _, codec := range strings.Split(codecs, ",") {
+		if codec = strings.TrimSpace(codec); codec != "" {
+			formatted = append(formatted, codec)
+		}
+	}
+	return strings.Join(formatted, ",")
+}
@@ -0,0 +1,207 @@
+package playlist
+
+import (
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"testing"
+)
+
+func TestMultivariant_String(t *testing.T) {
+	playlistId := "932ac3aa-b11f-11ed-afa1-0242ac120002"
+
+	cases := []struct {
+		Name     string
+		Playlist *model.MultivariantPlaylist
+	}{
+		{
+			Name: "multivariant-audio-groups",
+			Playlist: &model.MultivariantPlaylist{
+				Id:      playlistId,
+				Version: 2,
+				Variants: []*model.Variant{
+					{
+						Id:        "a3e4e680-b11f-11ed-afa1-0242ac120002",
+						CacheKey:  playlistId + "/a3e4e680-b11f-11ed-afa1-0242ac120002",
+						Codecs:    "avc1.4dc01f, mp4a.40.2",
+						Bandwidth: 2500000,
+						Audio:     "dc5daa10-b11f-11ed-afa1-0242ac120002",
+
+						Resolution: "1280x720",
+						FrameRate:  29.97,
+						VideoRange: "SDR",
+					},
+					{
+						Id:        "5e0c7a7c-b122-11ed-afa1-0242ac120002",
+						CacheKey:  playlistId + "/5e0c7a7c-b122-11ed-afa1-0242ac120002",
+						Codecs:    "avc1.4dc00d, mp4a.40.2",
+						Bandwidth: 800000,
+						Audio:     "dc5daa10-b11f-11ed-afa1-0242ac120002",
+					},
+				},
+				Renditions: []*model.Rendition{
+					{
+						Id:         "d02288ec-b11f-11ed-afa1-0242ac120002",
+						CacheKey:   playlistId + "/d02288ec-b11f-11ed-afa1-0242ac120002",
+						Type:       "AUDIO",
+						GroupId:    "dc5daa10-b11f-11ed-afa1-0242ac120002",
+						Name:       "audio-en",
+						Language:   "en",
+						IsDefault:  true,
+						AutoSelect: true,
+					},
+					{
+						Id:       "7a3f5d1e-b122-11ed-afa1-0242ac120002",
+						CacheKey: playlistId + "/7a3f5d1e-b122-11ed-afa1-0242ac120002",
+						Type:     "AUDIO",
+						GroupId:  "dc5daa10-b11f-11ed-afa1-0242ac120002",
+						Name:     "audio-de",
+						Language: "de",
+					},
+				},
+			},
+		},
+		{
+			Name: "multivariant-audio-codecs",
+			Playlist: &model.MultivariantPlaylist{
+				Id:      playlistId,
+				Version: 1,
+				Variants: []*model.Variant{
+					{
+						Id:        "a3e4e680-b11f-11ed-afa1-0242ac120002",
+						CacheKey:  playlistId + "/a3e4e680-b11f-11ed-afa1-0242ac120002",
+						Codecs:    "avc1.4dc01f",
+						Bandwidth: 2500000,
+						Audio:     "dc5daa10-b11f-11ed-afa1-0242ac120002",
+					},
+					{
+						Id:        "5e0c7a7c-b122-11ed-afa1-0242ac120002",
+						CacheKey:  playlistId + "/5e0c7a7c-b122-11ed-afa1-0242ac120002",
+						Codecs:    "avc1.4dc00d, MP4A.40.2",
+						Bandwidth: 800000,
+						Audio:     "dc5daa10-b11f-11ed-afa1-0242ac120002",
+					},
+				},
+				Renditions: []*model.Rendition{
+					{
+						Id:         "d02288ec-b11f-11ed-afa1-0242ac120002",
+						CacheKey:   playlistId + "/d02288ec-b11f-11ed-afa1-0242ac120002",
+						Type:       "AUDIO",
+						GroupId:    "dc5daa10-b11f-11ed-afa1-0242ac120002",
+						Name:       "audio-en",
+						Language:   "en",
+						Codecs:     "mp4a.40.2",
+						IsDefault:  true,
+						AutoSelect: true,
+					},
+					{
+						Id:       "7a3f5d1e-b122-11ed-afa1-0242ac120002",
+						CacheKey: playlistId + "/7a3f5d1e-b122-11ed-afa1-0242ac120002",
+						Type:     "AUDIO",
+						GroupId:  "dc5daa10-b11f-11ed-afa1-0242ac120002",
+						Name:     "audio-de",
+						Language: "de",
+						Codecs:   "mp4a.40.5",
+					},
+				},
+			},
+		},
+		{
+			Name: "multivariant-muxed",
+			Playlist: &model.MultivariantPlaylist{
+				Id:      playlistId,
+				Version: 1,
+				Variants: []*model.Variant{
+					{
+						Id:        "a3e4e680-b11f-11ed-afa1-0242ac120002",
+						CacheKey:  playlistId + "/a3e4e680-b11f-11ed-afa1-0242ac120002",
+						Codecs:    "avc1.4dc00d,mp4a.40.2",
+						Bandwidth: 2048,
+					},
+				},
+			},
+		},
+		{
+			Name: "multivariant-subtitles",
+			Playlist: &model.MultivariantPlaylist{
+				Id:      playlistId,
+				Version: 1,
+				Variants: []*model.Variant{
+					{
+						Id:        "a3e4e680-b11f-11ed-afa1-0242ac120002",
+						CacheKey:  playlistId + "/a3e4e680-b11f-11ed-afa1-0242ac120002",
+						Codecs:    "avc1.4dc00d,mp4a.40.2",
+						Bandwidth: 2048,
+						Subtitles: "4b1c06e2-b125-11ed-afa1-0242ac120002",
+					},
+				},
+				Renditions: []*model.Rendition{
+					{
+						Id:         "51d7f0a4-b125-11ed-afa1-0242ac120002",
+						CacheKey:   playlistId + "/51d7f0a4-b125-11ed-afa1-0242ac120002",
+						Type:       "SUBTITLES",
+						GroupId:    "4b1c06e2-b125-11ed-afa1-0242ac120002",
+						Name:       "subtitles-en",
+						Language:   "en",
+						IsDefault:  true,
+						AutoSelect: true,
+					},
+					{
+						Id:       "5a0e5cb8-b125-11ed-afa1-0242ac120002",
+						CacheKey: playlistId + "/5a0e5cb8-b125-11ed-afa1-0242ac120002",
+						Type:     "SUBTITLES",
+						GroupId:  "4b1c06e2-b125-11ed-afa1-0242ac120002",
+						Name:     "subtitles-de",
+						Language: "de",
+						Codecs:   "wvtt",
+					},
+				},
+			},
+		},
+		{
+			Name: "multivariant-closed-captions",
+			Playlist: &model.MultivariantPlaylist{
+				Id:      playlistId,
+				Version: 1,
+				Variants: []*model.Variant{
+					{
+						Id:             "a3e4e680-b11f-11ed-afa1-0242ac120002",
+						CacheKey:       playlistId + "/a3e4e680-b11f-11ed-afa1-0242ac120002",
+						Codecs:         "avc1.4dc01f,mp4a.40.2",
+						Bandwidth:      2500000,
+						ClosedCaptions: "e3b3c2a0-b126-11ed-afa1-0242ac120002",
+					},
+					{
+						Id:        "5e0c7a7c-b122-11ed-afa1-0242ac120002",
+						CacheKey:  playlistId + "/5e0c7a7c-b122-11ed-afa1-0242ac120002",
+						Codecs:    "avc1.4dc00d,mp4a.40.2",
+						Bandwidth: 800000,
+					},
+				},
+				Renditions: []*model.Rendition{
+					{
+						Id:         "eb2f3a6c-b126-11ed-afa1-0242ac120002",
+						Type:       "CLOSED-CAPTIONS",
+						GroupId:    "e3b3c2a0-b126-11ed-afa1-0242ac120002",
+						Name:       "CC1",
+						Language:   "en",
+						IsDefault:  true,
+						InstreamId: "CC1",
+					},
+					{
+						Id:         "f1a4d5b2-b126-11ed-afa1-0242ac120002",
+						Type:       "CLOSED-CAPTIONS",
+						GroupId:    "e3b3c2a0-b126-11ed-afa1-0242ac120002",
+						Name:       "SERVICE1",
+						AutoSelect: true,
+						InstreamId: "SERVICE1",
+					},
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			assertGolden(t, c.Name, NewMultivariant(c.Playlist).String())
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package playlist
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+const (
+	Version     = 6
+	ContentType = "application/vnd.apple.mpegurl"
+	// WebVTTContentType is the media type of subtitle playlists whose segments are plain WebVTT files.
+	WebVTTContentType = "text/vtt"
+
+	programDateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// InitURI names the media initialization section relative to the media playlist.
+func InitURI(initCacheKey string) string {
+	return path.Base(initCacheKey) + ".mp4"
+}
+
+// SegmentURI names a complete segment of a media playlist of the given media type relative to the playlist.
+func SegmentURI(sequence int, mimeType string) string {
+	if mimeType == WebVTTContentType {
+		return fmt.Sprintf("segment-%d.vtt", sequence)
+	}
+	return fmt.Sprintf("segment-%d.m4s", sequence)
+}
+
+// PartURI names a part relative to the media playlist, addressed by its media sequence and part index.
+func PartURI(sequence, part int) string {
+	return fmt.Sprintf("part-%d.%d.m4s", sequence, part)
+}
+
+func formatDuration(duration float64) string {
+	return fmt.Sprintf("%.3f", duration)
+}
+
+func formatProgramDateTime(programDateTime time.Time) string {
+	return programDateTime.UTC().Format(programDateTimeLayout)
+}
+
+func formatBool(value bool) string {
+	if value {
+		return "YES"
+	}
+	return "NO"
+}
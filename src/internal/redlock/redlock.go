@@ -0,0 +1,264 @@
+package redlock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTTL        = 10 * time.Second
+	DefaultRetryCount = 64
+	DefaultRetryDelay = 50 * time.Millisecond
+
+	// minTTL keeps a lock alive long enough to be used even when the context is about to expire.
+	minTTL = 100 * time.Millisecond
+	// releaseTimeout bounds the cleanup after a failed acquisition.
+	releaseTimeout = time.Second
+	// driftFactor accounts for the clock drift between the Redis nodes, see https://redis.io/docs/manual/patterns/distributed-locks/.
+	driftFactor = 0.01
+
+	// fenceTTL is how long the fencing tokens of a key outlive its last acquisition.
+	fenceTTL = 24 * time.Hour
+
+	lockKeyPrefix  = "lock:"
+	fenceKeyPrefix = "lock:fence:"
+)
+
+var (
+	ErrLockNotAcquired = fmt.Errorf("%d: lock not acquired", 409)
+	ErrLockLost        = fmt.Errorf("%d: lock lost", 409)
+)
+
+var (
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+	extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	fenceScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current or tonumber(current) < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+else
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 1`)
+)
+
+// Redlock implements the Redlock algorithm over independent Redis nodes.
+// A lock is held when a majority of the nodes granted it.
+type Redlock struct {
+	Clients    []*redis.Client
+	RetryCount int
+	RetryDelay time.Duration
+}
+
+type Lock struct {
+	redlock *Redlock
+	key     string
+	value   string
+	token   int64
+	until   time.Time
+}
+
+func New(clients ...*redis.Client) *Redlock {
+	return &Redlock{
+		Clients:    clients,
+		RetryCount: DefaultRetryCount,
+		RetryDelay: DefaultRetryDelay,
+	}
+}
+
+// NewFromAddresses connects to the comma separated Redis node addresses.
+func NewFromAddresses(addresses string) *Redlock {
+	var clients []*redis.Client
+	for _, address := range strings.Split(addresses, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			clients = append(clients, redis.NewClient(&redis.Options{Addr: address}))
+		}
+	}
+	return New(clients...)
+}
+
+func (r *Redlock) quorum() int {
+	return len(r.Clients)/2 + 1
+}
+
+// TTLFromContext ties the lifetime of a lock to the deadline of the context, so a lock taken
+// by a Lambda invocation expires together with it. Contexts without deadline get DefaultTTL.
+func TTLFromContext(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return DefaultTTL
+	}
+	if ttl := time.Until(deadline); ttl > minTTL {
+		return ttl
+	}
+	return minTTL
+}
+
+// Acquire locks the key on a majority of the nodes, retrying until RetryCount attempts are spent
+// or the context is done. The TTL of the lock follows TTLFromContext.
+func (r *Redlock) Acquire(ctx context.Context, key string) (*Lock, error) {
+	value, err := randomValue()
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < r.RetryCount; attempt++ {
+		lock, err := r.tryAcquire(ctx, key, value, TTLFromContext(ctx))
+		if err == nil {
+			return lock, nil
+		}
+
+		delay := r.RetryDelay + time.Duration(mathrand.Int63n(int64(r.RetryDelay)+1))
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", ErrLockNotAcquired, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+	return nil, ErrLockNotAcquired
+}
+
+func (r *Redlock) tryAcquire(ctx context.Context, key, value string, ttl time.Duration) (*Lock, error) {
+	start := time.Now()
+	granted := 0
+	for _, client := range r.Clients {
+		ok, err := client.SetNX(ctx, lockKeyPrefix+key, value, ttl).Result()
+		if err == nil && ok {
+			granted++
+		}
+	}
+
+	if granted >= r.quorum() {
+		token, err := r.nextToken(ctx, key)
+		validity := ttl - time.Since(start) - time.Duration(driftFactor*float64(ttl)) - 2*time.Millisecond
+		if err == nil && validity > 0 {
+			return &Lock{
+				redlock: r,
+				key:     key,
+				value:   value,
+				token:   token,
+				until:   start.Add(validity),
+			}, nil
+		}
+	}
+
+	// The partially granted lock is released even when the context is done already.
+	releaseCtx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	r.release(releaseCtx, key, value)
+	return nil, ErrLockNotAcquired
+}
+
+// nextToken issues the fencing token of a new holder of the lock. The token follows the highest token any reachable
+// node knows of and is written back to each of them, so it grows whichever majority granted the lock, as any two
+// majorities share a node. Tokens do not fall behind the clock either, which keeps them growing once the tokens
+// stored on the nodes expired.
+func (r *Redlock) nextToken(ctx context.Context, key string) (int64, error) {
+	token := time.Now().UnixMicro()
+	read := 0
+	for _, client := range r.Clients {
+		fence, err := client.Get(ctx, fenceKeyPrefix+key).Int64()
+		if errors.Is(err, redis.Nil) {
+			read++
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		read++
+		if fence >= token {
+			token = fence + 1
+		}
+	}
+	if read < r.quorum() {
+		return 0, ErrLockNotAcquired
+	}
+
+	written := 0
+	for _, client := range r.Clients {
+		err := fenceScript.Run(ctx, client, []string{fenceKeyPrefix + key}, token, fenceTTL.Milliseconds()).Err()
+		if err == nil {
+			written++
+		}
+	}
+	if written < r.quorum() {
+		return 0, ErrLockNotAcquired
+	}
+	return token, nil
+}
+
+func (r *Redlock) release(ctx context.Context, key, value string) int {
+	released := 0
+	for _, client := range r.Clients {
+		n, err := releaseScript.Run(ctx, client, []string{lockKeyPrefix + key}, value).Int()
+		if err == nil && n > 0 {
+			released++
+		}
+	}
+	return released
+}
+
+// Token is the fencing token of the lock. Tokens grow with every acquisition of the same key,
+// so writers can reject the updates of a holder whose lock already expired, see nextToken.
+func (l *Lock) Token() int64 {
+	return l.token
+}
+
+// Valid reports whether the lock is still held according to the local clock.
+func (l *Lock) Valid() bool {
+	return time.Now().Before(l.until)
+}
+
+// Extend renews the lock with the TTL of the context on a majority of the nodes which still hold it.
+func (l *Lock) Extend(ctx context.Context) error {
+	ttl := TTLFromContext(ctx)
+	start := time.Now()
+	extended := 0
+	for _, client := range l.redlock.Clients {
+		n, err := extendScript.Run(ctx, client, []string{lockKeyPrefix + l.key}, l.value, ttl.Milliseconds()).Int()
+		if err == nil && n > 0 {
+			extended++
+		}
+	}
+
+	if extended < l.redlock.quorum() {
+		return ErrLockLost
+	}
+	l.until = start.Add(ttl - time.Since(start) - time.Duration(driftFactor*float64(ttl)) - 2*time.Millisecond)
+	return nil
+}
+
+// Release unlocks the key on every node that still holds this lock.
+func (l *Lock) Release(ctx context.Context) error {
+	if l.redlock.release(ctx, l.key, l.value) < l.redlock.quorum() {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func randomValue() (string, error) {
+	buffer := make([]byte, 16)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36), nil
+}
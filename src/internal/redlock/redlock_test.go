@@ -0,0 +1,158 @@
+package redlock
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestRedlock(t *testing.T, nodes int) (*Redlock, []*miniredis.Miniredis) {
+	var servers []*miniredis.Miniredis
+	var clients []*redis.Client
+	for i := 0; i < nodes; i++ {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+		t.Cleanup(func() { _ = client.Close() })
+		servers = append(servers, server)
+		clients = append(clients, client)
+	}
+
+	redlock := New(clients...)
+	redlock.RetryCount = 3
+	redlock.RetryDelay = time.Millisecond
+	return redlock, servers
+}
+
+func TestRedlock_Acquire(t *testing.T) {
+	ctx := context.Background()
+	redlock, servers := newTestRedlock(t, 3)
+
+	lock, err := redlock.Acquire(ctx, "playlist/variant")
+	require.NoError(t, err)
+	assert.True(t, lock.Valid())
+	for _, server := range servers {
+		assert.True(t, server.Exists("lock:playlist/variant"))
+	}
+
+	_, err = redlock.Acquire(ctx, "playlist/variant")
+	assert.ErrorIs(t, err, ErrLockNotAcquired)
+
+	other, err := redlock.Acquire(ctx, "playlist/rendition")
+	require.NoError(t, err)
+	require.NoError(t, other.Release(ctx))
+
+	require.NoError(t, lock.Release(ctx))
+	for _, server := range servers {
+		assert.False(t, server.Exists("lock:playlist/variant"))
+	}
+
+	next, err := redlock.Acquire(ctx, "playlist/variant")
+	require.NoError(t, err)
+	assert.Greater(t, next.Token(), lock.Token())
+	require.NoError(t, next.Release(ctx))
+}
+
+func TestRedlock_TokenGrowsAcrossMajorities(t *testing.T) {
+	ctx := context.Background()
+	redlock, servers := newTestRedlock(t, 3)
+	future := time.Now().Add(time.Hour).UnixMicro()
+	require.NoError(t, servers[0].Set("lock:fence:playlist/variant", strconv.FormatInt(future, 10)))
+
+	// The first holder is granted the lock by the first two nodes, the second one by the last two.
+	require.NoError(t, servers[2].Set("lock:playlist/variant", "other"))
+	first, err := redlock.Acquire(ctx, "playlist/variant")
+	require.NoError(t, err)
+	require.NoError(t, first.Release(ctx))
+	servers[2].Del("lock:playlist/variant")
+
+	require.NoError(t, servers[0].Set("lock:playlist/variant", "other"))
+	second, err := redlock.Acquire(ctx, "playlist/variant")
+	require.NoError(t, err)
+	require.NoError(t, second.Release(ctx))
+
+	assert.Greater(t, first.Token(), future)
+	assert.Greater(t, second.Token(), first.Token())
+	for _, server := range servers {
+		assert.Positive(t, server.TTL("lock:fence:playlist/variant"))
+		assert.LessOrEqual(t, server.TTL("lock:fence:playlist/variant"), fenceTTL)
+	}
+}
+
+func TestRedlock_Quorum(t *testing.T) {
+	ctx := context.Background()
+	redlock, servers := newTestRedlock(t, 3)
+
+	servers[0].Close()
+	lock, err := redlock.Acquire(ctx, "playlist/variant")
+	require.NoError(t, err)
+	require.NoError(t, lock.Release(ctx))
+
+	servers[1].Close()
+	_, err = redlock.Acquire(ctx, "playlist/variant")
+	assert.ErrorIs(t, err, ErrLockNotAcquired)
+	assert.False(t, servers[2].Exists("lock:playlist/variant"))
+}
+
+func TestLock_Expires(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	redlock, servers := newTestRedlock(t, 3)
+
+	lock, err := redlock.Acquire(ctx, "playlist/variant")
+	require.NoError(t, err)
+	for _, server := range servers {
+		ttl := server.TTL("lock:playlist/variant")
+		assert.Greater(t, ttl, 4*time.Second)
+		assert.LessOrEqual(t, ttl, 5*time.Second)
+	}
+
+	for _, server := range servers {
+		server.FastForward(5 * time.Second)
+	}
+	assert.ErrorIs(t, lock.Extend(ctx), ErrLockLost)
+	assert.ErrorIs(t, lock.Release(ctx), ErrLockLost)
+}
+
+func TestLock_Extend(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	redlock, servers := newTestRedlock(t, 3)
+
+	lock, err := redlock.Acquire(ctx, "playlist/variant")
+	require.NoError(t, err)
+	for _, server := range servers {
+		server.FastForward(4 * time.Second)
+	}
+	require.NoError(t, lock.Extend(ctx))
+	assert.True(t, lock.Valid())
+	for _, server := range servers {
+		assert.Greater(t, server.TTL("lock:playlist/variant"), 4*time.Second)
+	}
+
+	// A lock taken over by another holder on a majority of the nodes is not extended.
+	require.NoError(t, servers[0].Set("lock:playlist/variant", "other"))
+	require.NoError(t, servers[1].Set("lock:playlist/variant", "other"))
+	assert.ErrorIs(t, lock.Extend(ctx), ErrLockLost)
+	got, err := servers[0].Get("lock:playlist/variant")
+	require.NoError(t, err)
+	assert.Equal(t, "other", got)
+}
+
+func TestTTLFromContext(t *testing.T) {
+	assert.Equal(t, DefaultTTL, TTLFromContext(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	ttl := TTLFromContext(ctx)
+	assert.Greater(t, ttl, 2*time.Second)
+	assert.LessOrEqual(t, ttl, 3*time.Second)
+
+	expired, cancelExpired := context.WithTimeout(context.Background(), 0)
+	defer cancelExpired()
+	assert.Equal(t, minTTL, TTLFromContext(expired))
+}
@@ -0,0 +1,472 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/model"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	mediaPlaylistKeyPrefix        = "mediaplaylist:"
+	multivariantPlaylistKeyPrefix = "multivariantplaylist:"
+	renditionReportsKeyPrefix     = "renditionreports:"
+	livePlaylistsKey              = "liveplaylists"
+	discrepanciesKeyPrefix        = "discrepancies:"
+	streamStatsKeyPrefix          = "streamstats:"
+	captionCuesKeyPrefix          = "captioncues:"
+	interstitialsKeyPrefix        = "interstitials:"
+	adAssetsKeyPrefix             = "adassets:"
+)
+
+// MaxDiscrepancies is how many of the latest discrepancies are kept per master playlist.
+const MaxDiscrepancies = 100
+
+// StreamTTL is how long the state kept per master playlist outlives its last write,
+// so nothing of a stream which stopped stays behind for good.
+const StreamTTL = 24 * time.Hour
+
+var (
+	ErrMediaNotFound                = fmt.Errorf("%d: media not found", 404)
+	ErrMediaPlaylistNotFound        = fmt.Errorf("%d: media playlist not found", 404)
+	ErrMultivariantPlaylistNotFound = fmt.Errorf("%d: multivariant playlist not found", 404)
+	ErrInterstitialNotFound         = fmt.Errorf("%d: interstitial not found", 404)
+	ErrInterstitialExists           = fmt.Errorf("%d: interstitial already scheduled", 409)
+	ErrAdAssetNotFound              = fmt.Errorf("%d: ad asset not found", 404)
+	ErrFenced                       = fmt.Errorf("%d: playlist was written with a later fencing token", 409)
+)
+
+type StreamRepository struct {
+	Client *redis.Client
+}
+
+func NewStreamRepository(client *redis.Client) *StreamRepository {
+	return &StreamRepository{
+		Client: client,
+	}
+}
+
+func (r StreamRepository) SetMedia(ctx context.Context, key string, data []byte) error {
+	return r.Client.Set(ctx, key, data, 0).Err()
+}
+
+func (r StreamRepository) GetMedia(ctx context.Context, key string) ([]byte, error) {
+	data, err := r.Client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMediaNotFound
+	}
+	return data, err
+}
+
+// HasMedia reports whether media is stored under the key.
+func (r StreamRepository) HasMedia(ctx context.Context, key string) (bool, error) {
+	count, err := r.Client.Exists(ctx, key).Result()
+	return count > 0, err
+}
+
+// ExpireMedia lets the media stored under each key expire after its time to live.
+func (r StreamRepository) ExpireMedia(ctx context.Context, expirations map[string]time.Duration) error {
+	if len(expirations) == 0 {
+		return nil
+	}
+
+	_, err := r.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, ttl := range expirations {
+			pipe.Expire(ctx, key, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r StreamRepository) GetMediaPlaylist(ctx context.Context, cacheKey string) (*model.MediaPlaylist, error) {
+	data, err := r.Client.Get(ctx, mediaPlaylistKeyPrefix+cacheKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMediaPlaylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	playlist := &model.MediaPlaylist{}
+	err = json.Unmarshal(data, playlist)
+	if err != nil {
+		return nil, err
+	}
+	return playlist, nil
+}
+
+// SetMediaPlaylist stores the playlist unless a later lock holder stored it already, see setFenced.
+func (r StreamRepository) SetMediaPlaylist(ctx context.Context, playlist *model.MediaPlaylist) error {
+	data, err := json.Marshal(playlist)
+	if err != nil {
+		return err
+	}
+	var report []byte
+	if rr := playlist.RenditionReport(); rr != nil {
+		report, err = json.Marshal(rr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.setFenced(ctx, mediaPlaylistKeyPrefix+playlist.CacheKey, playlist.FenceToken, func(pipe redis.Pipeliner) {
+		pipe.Set(ctx, mediaPlaylistKeyPrefix+playlist.CacheKey, data, 0)
+		if report != nil {
+			pipe.HSet(ctx, renditionReportsKeyPrefix+playlist.PlaylistId, playlist.CacheKey, report)
+		}
+		pipe.Publish(ctx, mediaPlaylistKeyPrefix+playlist.CacheKey, playlist.UpdatedAt.UnixMilli())
+	})
+}
+
+// setFenced runs the writes in a transaction which only commits while the playlist stored under the key carries
+// no later fencing token than the one given. The key is watched, so a write slipping in between the check and
+// the transaction fails it as well.
+func (r StreamRepository) setFenced(ctx context.Context, key string, fenceToken int64, write func(pipe redis.Pipeliner)) error {
+	err := r.Client.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if err == nil {
+			stored := struct {
+				FenceToken int64 `json:"fenceToken"`
+			}{}
+			err = json.Unmarshal(data, &stored)
+			if err != nil {
+				return err
+			}
+			if stored.FenceToken > fenceToken {
+				return ErrFenced
+			}
+		} else if !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			write(pipe)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return ErrFenced
+	}
+	return err
+}
+
+// GetRenditionReports returns the live edge of every media playlist stored under the master playlist id.
+func (r StreamRepository) GetRenditionReports(ctx context.Context, playlistId string) ([]*model.RenditionReport, error) {
+	values, err := r.Client.HGetAll(ctx, renditionReportsKeyPrefix+playlistId).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*model.RenditionReport, 0, len(values))
+	for _, value := range values {
+		report := &model.RenditionReport{}
+		err = json.Unmarshal([]byte(value), report)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// SubscribeMediaPlaylist notifies about every update of the media playlist stored under the cache key.
+func (r StreamRepository) SubscribeMediaPlaylist(ctx context.Context, cacheKey string) *redis.PubSub {
+	return r.Client.Subscribe(ctx, mediaPlaylistKeyPrefix+cacheKey)
+}
+
+func (r StreamRepository) GetMultivariantPlaylist(ctx context.Context, playlistId string) (*model.MultivariantPlaylist, error) {
+	data, err := r.Client.Get(ctx, multivariantPlaylistKeyPrefix+playlistId).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMultivariantPlaylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	playlist := &model.MultivariantPlaylist{}
+	err = json.Unmarshal(data, playlist)
+	if err != nil {
+		return nil, err
+	}
+	return playlist, nil
+}
+
+// SetMultivariantPlaylist stores the playlist unless a later lock holder stored it already, see setFenced.
+func (r StreamRepository) SetMultivariantPlaylist(ctx context.Context, playlist *model.MultivariantPlaylist) error {
+	data, err := json.Marshal(playlist)
+	if err != nil {
+		return err
+	}
+	return r.setFenced(ctx, multivariantPlaylistKeyPrefix+playlist.Id, playlist.FenceToken, func(pipe redis.Pipeliner) {
+		pipe.Set(ctx, multivariantPlaylistKeyPrefix+playlist.Id, data, 0)
+		pipe.SAdd(ctx, livePlaylistsKey, playlist.Id)
+	})
+}
+
+// ExpirePlaylists lets a master playlist, its media playlists and their rendition reports expire after the time to live.
+func (r StreamRepository) ExpirePlaylists(ctx context.Context, playlistId string, cacheKeys []string, ttl time.Duration) error {
+	_, err := r.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Expire(ctx, multivariantPlaylistKeyPrefix+playlistId, ttl)
+		pipe.Expire(ctx, renditionReportsKeyPrefix+playlistId, ttl)
+		for _, cacheKey := range cacheKeys {
+			pipe.Expire(ctx, mediaPlaylistKeyPrefix+cacheKey, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// GetLivePlaylistIds returns the ids of the master playlists which were not archived yet.
+func (r StreamRepository) GetLivePlaylistIds(ctx context.Context) ([]string, error) {
+	return r.Client.SMembers(ctx, livePlaylistsKey).Result()
+}
+
+func (r StreamRepository) IsLivePlaylist(ctx context.Context, playlistId string) (bool, error) {
+	return r.Client.SIsMember(ctx, livePlaylistsKey, playlistId).Result()
+}
+
+func (r StreamRepository) RemoveLivePlaylist(ctx context.Context, playlistId string) error {
+	return r.Client.SRem(ctx, livePlaylistsKey, playlistId).Err()
+}
+
+// AddDiscrepancies records the discrepancies found on the media of the publisher of a master playlist.
+// Only the latest ones are kept, while the stream stats count all of them per attribute.
+func (r StreamRepository) AddDiscrepancies(ctx context.Context, playlistId string, discrepancies []*model.Discrepancy) error {
+	if len(discrepancies) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, 0, len(discrepancies))
+	for _, discrepancy := range discrepancies {
+		data, err := json.Marshal(discrepancy)
+		if err != nil {
+			return err
+		}
+		values = append(values, data)
+	}
+
+	_, err := r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, discrepanciesKeyPrefix+playlistId, values...)
+		pipe.LTrim(ctx, discrepanciesKeyPrefix+playlistId, 0, MaxDiscrepancies-1)
+		pipe.Expire(ctx, discrepanciesKeyPrefix+playlistId, StreamTTL)
+		for _, discrepancy := range discrepancies {
+			pipe.HIncrBy(ctx, streamStatsKeyPrefix+playlistId, discrepancy.Attribute+"Discrepancies", 1)
+		}
+		pipe.Expire(ctx, streamStatsKeyPrefix+playlistId, StreamTTL)
+		return nil
+	})
+	return err
+}
+
+// GetDiscrepancies returns the latest discrepancies of a master playlist, newest first.
+func (r StreamRepository) GetDiscrepancies(ctx context.Context, playlistId string) ([]*model.Discrepancy, error) {
+	values, err := r.Client.LRange(ctx, discrepanciesKeyPrefix+playlistId, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	discrepancies := make([]*model.Discrepancy, 0, len(values))
+	for _, value := range values {
+		discrepancy := &model.Discrepancy{}
+		err = json.Unmarshal([]byte(value), discrepancy)
+		if err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, discrepancy)
+	}
+	return discrepancies, nil
+}
+
+// IncrStreamStats adds the increments to the counters of a master playlist by name.
+func (r StreamRepository) IncrStreamStats(ctx context.Context, playlistId string, increments map[string]int64) error {
+	if len(increments) == 0 {
+		return nil
+	}
+
+	_, err := r.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for name, increment := range increments {
+			pipe.HIncrBy(ctx, streamStatsKeyPrefix+playlistId, name, increment)
+		}
+		pipe.Expire(ctx, streamStatsKeyPrefix+playlistId, StreamTTL)
+		return nil
+	})
+	return err
+}
+
+// GetStreamStats returns the counters of a master playlist by name.
+func (r StreamRepository) GetStreamStats(ctx context.Context, playlistId string) (map[string]int64, error) {
+	values, err := r.Client.HGetAll(ctx, streamStatsKeyPrefix+playlistId).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]int64, len(values))
+	for name, value := range values {
+		stats[name], err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// AddCaptionCues queues live caption cues of a master playlist until they are sliced into subtitle segments.
+// Cues are ordered by their end, and a cue sent twice is only queued once.
+func (r StreamRepository) AddCaptionCues(ctx context.Context, playlistId string, cues []*model.CaptionCue) error {
+	if len(cues) == 0 {
+		return nil
+	}
+
+	members := make([]redis.Z, 0, len(cues))
+	for _, cue := range cues {
+		data, err := json.Marshal(cue)
+		if err != nil {
+			return err
+		}
+		members = append(members, redis.Z{Score: float64(cue.End.UnixMilli()), Member: data})
+	}
+	_, err := r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, captionCuesKeyPrefix+playlistId, members...)
+		pipe.Expire(ctx, captionCuesKeyPrefix+playlistId, StreamTTL)
+		return nil
+	})
+	return err
+}
+
+// GetCaptionCues returns the queued caption cues of a master playlist in the order they end.
+func (r StreamRepository) GetCaptionCues(ctx context.Context, playlistId string) ([]*model.CaptionCue, error) {
+	values, err := r.Client.ZRange(ctx, captionCuesKeyPrefix+playlistId, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cues := make([]*model.CaptionCue, 0, len(values))
+	for _, value := range values {
+		cue := &model.CaptionCue{}
+		err = json.Unmarshal([]byte(value), cue)
+		if err != nil {
+			return nil, err
+		}
+		cues = append(cues, cue)
+	}
+	return cues, nil
+}
+
+// TrimCaptionCues drops the queued caption cues of a master playlist which end by the given time.
+func (r StreamRepository) TrimCaptionCues(ctx context.Context, playlistId string, end time.Time) error {
+	return r.Client.ZRemRangeByScore(ctx, captionCuesKeyPrefix+playlistId, "-inf", strconv.FormatInt(end.UnixMilli(), 10)).Err()
+}
+
+// AddInterstitial schedules an interstitial of a master playlist under its id, unless an interstitial with that id
+// was scheduled before.
+func (r StreamRepository) AddInterstitial(ctx context.Context, playlistId string, interstitial *model.Interstitial) error {
+	data, err := json.Marshal(interstitial)
+	if err != nil {
+		return err
+	}
+	added, err := r.Client.HSetNX(ctx, interstitialsKeyPrefix+playlistId, interstitial.Id, data).Result()
+	if err != nil {
+		return err
+	}
+	if !added {
+		return ErrInterstitialExists
+	}
+	return r.Client.Expire(ctx, interstitialsKeyPrefix+playlistId, StreamTTL).Err()
+}
+
+func (r StreamRepository) GetInterstitial(ctx context.Context, playlistId string, id string) (*model.Interstitial, error) {
+	data, err := r.Client.HGet(ctx, interstitialsKeyPrefix+playlistId, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInterstitialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	interstitial := &model.Interstitial{}
+	err = json.Unmarshal(data, interstitial)
+	if err != nil {
+		return nil, err
+	}
+	return interstitial, nil
+}
+
+// GetInterstitials returns the scheduled interstitials of a master playlist in the order they start.
+func (r StreamRepository) GetInterstitials(ctx context.Context, playlistId string) ([]*model.Interstitial, error) {
+	values, err := r.Client.HVals(ctx, interstitialsKeyPrefix+playlistId).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	interstitials := make([]*model.Interstitial, 0, len(values))
+	for _, value := range values {
+		interstitial := &model.Interstitial{}
+		err = json.Unmarshal([]byte(value), interstitial)
+		if err != nil {
+			return nil, err
+		}
+		interstitials = append(interstitials, interstitial)
+	}
+	sort.Slice(interstitials, func(i, j int) bool {
+		return interstitials[i].StartDate.Before(interstitials[j].StartDate)
+	})
+	return interstitials, nil
+}
+
+func (r StreamRepository) DeleteInterstitial(ctx context.Context, playlistId string, id string) error {
+	return r.Client.HDel(ctx, interstitialsKeyPrefix+playlistId, id).Err()
+}
+
+// SetAdAsset registers an ad asset for the ad breaks of a master playlist, replacing one with the same id.
+func (r StreamRepository) SetAdAsset(ctx context.Context, playlistId string, asset *model.AdAsset) error {
+	data, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+	_, err = r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, adAssetsKeyPrefix+playlistId, asset.Id, data)
+		pipe.Expire(ctx, adAssetsKeyPrefix+playlistId, StreamTTL)
+		return nil
+	})
+	return err
+}
+
+// GetAdAssets returns the ad assets of a master playlist in the order they were registered.
+func (r StreamRepository) GetAdAssets(ctx context.Context, playlistId string) ([]*model.AdAsset, error) {
+	values, err := r.Client.HVals(ctx, adAssetsKeyPrefix+playlistId).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	assets := make([]*model.AdAsset, 0, len(values))
+	for _, value := range values {
+		asset := &model.AdAsset{}
+		err = json.Unmarshal([]byte(value), asset)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].CreatedAt.Before(assets[j].CreatedAt)
+	})
+	return assets, nil
+}
+
+func (r StreamRepository) DeleteAdAsset(ctx context.Context, playlistId string, id string) error {
+	deleted, err := r.Client.HDel(ctx, adAssetsKeyPrefix+playlistId, id).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrAdAssetNotFound
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package scte35
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+var (
+	ErrMalformedSection = fmt.Errorf("%d: malformed SCTE-35 splice_info_section", 400)
+	ErrEncryptedSection = fmt.Errorf("%d: encrypted SCTE-35 splice_info_section", 400)
+)
+
+// Clock is the frequency of the PTS values and durations of splice_info_sections.
+const Clock = 90000
+
+// tableId identifies splice_info_sections.
+const tableId = 0xfc
+
+// splice_command_type values.
+const (
+	CommandSpliceNull           = 0x00
+	CommandSpliceSchedule       = 0x04
+	CommandSpliceInsert         = 0x05
+	CommandTimeSignal           = 0x06
+	CommandBandwidthReservation = 0x07
+	CommandPrivate              = 0xff
+)
+
+// segmentationDescriptorTag identifies the segmentation_descriptors of the CUEI identifier.
+const (
+	segmentationDescriptorTag = 0x02
+	cueIdentifier             = 0x43554549
+)
+
+// Segmentation types which open and close a break. Each closing type is the opening type plus one.
+var breakStarts = map[byte]bool{
+	0x22: true, // Break Start
+	0x30: true, // Provider Advertisement Start
+	0x32: true, // Distributor Advertisement Start
+	0x34: true, // Provider Placement Opportunity Start
+	0x36: true, // Distributor Placement Opportunity Start
+	0x38: true, // Provider Overlay Placement Opportunity Start
+	0x3a: true, // Distributor Overlay Placement Opportunity Start
+	0x44: true, // Provider Ad Block Start
+	0x46: true, // Distributor Ad Block Start
+}
+
+// SpliceInfo is a parsed splice_info_section. Times are PTS values in the Clock, with the pts_adjustment
+// of the section applied and wrapped to 33 bits like the timestamps they refer to.
+type SpliceInfo struct {
+	Command byte
+
+	// EventId, Cancel and OutOfNetwork describe the splice_insert command.
+	EventId      uint32
+	Cancel       bool
+	OutOfNetwork bool
+	// Immediate tells a splice_insert to splice at the next opportunity instead of at the PTS.
+	Immediate bool
+	// PTS is the splice time of a splice_insert or time_signal, unless HasPTS is false.
+	PTS    uint64
+	HasPTS bool
+	// BreakDuration is the duration of the break a splice_insert opens, unless HasBreakDuration is false.
+	BreakDuration    uint64
+	HasBreakDuration bool
+	AutoReturn       bool
+
+	Segmentations []*Segmentation
+}
+
+// Segmentation is a segmentation_descriptor of a splice_info_section.
+type Segmentation struct {
+	EventId uint32
+	Cancel  bool
+	TypeId  byte
+	// Duration is the duration of the segment, unless HasDuration is false.
+	Duration    uint64
+	HasDuration bool
+}
+
+// BreakStart tells whether the segmentation type opens a break.
+func (s *Segmentation) BreakStart() bool {
+	return breakStarts[s.TypeId]
+}
+
+// BreakEnd tells whether the segmentation type closes a break.
+func (s *Segmentation) BreakEnd() bool {
+	return breakStarts[s.TypeId-1]
+}
+
+// Parse reads a splice_info_section and verifies its CRC.
+func Parse(data []byte) (*SpliceInfo, error) {
+	if len(data) < 3 || data[0] != tableId {
+		return nil, fmt.Errorf("%w: missing table id", ErrMalformedSection)
+	}
+	sectionLength := int(binary.BigEndian.Uint16(data[1:]) & 0x0fff)
+	if 3+sectionLength != len(data) || sectionLength < 15 {
+		return nil, fmt.Errorf("%w: section length %d of %d bytes", ErrMalformedSection, sectionLength, len(data))
+	}
+	if crc32(data) != 0 {
+		return nil, fmt.Errorf("%w: CRC mismatch", ErrMalformedSection)
+	}
+	if data[4]&0x80 != 0 {
+		return nil, ErrEncryptedSection
+	}
+
+	ptsAdjustment := uint64(data[4]&0x01)<<32 | uint64(binary.BigEndian.Uint32(data[5:]))
+	commandLength := int(binary.BigEndian.Uint16(data[11:]) & 0x0fff)
+	info := &SpliceInfo{Command: data[13]}
+	rest := data[14 : len(data)-4]
+	if commandLength == 0x0fff {
+		// Legacy sections leave the command length unspecified, which only works for the commands read here.
+		commandLength = -1
+	} else if commandLength > len(rest) {
+		return nil, fmt.Errorf("%w: command length %d", ErrMalformedSection, commandLength)
+	}
+
+	r := &reader{data: rest}
+	switch info.Command {
+	case CommandSpliceInsert:
+		info.readSpliceInsert(r)
+	case CommandTimeSignal:
+		info.PTS, info.HasPTS = r.spliceTime()
+	default:
+		if commandLength < 0 {
+			return nil, fmt.Errorf("%w: command 0x%02x of unspecified length", ErrMalformedSection, info.Command)
+		}
+		r.skip(commandLength)
+	}
+	if r.err || (commandLength >= 0 && r.offset != commandLength) {
+		return nil, fmt.Errorf("%w: splice command 0x%02x", ErrMalformedSection, info.Command)
+	}
+
+	descriptorsLength := r.uint(16)
+	descriptors := &reader{data: r.bytes(int(descriptorsLength))}
+	if r.err {
+		return nil, fmt.Errorf("%w: descriptor loop length %d", ErrMalformedSection, descriptorsLength)
+	}
+	for len(descriptors.data) > descriptors.offset {
+		tag, length := byte(descriptors.uint(8)), int(descriptors.uint(8))
+		descriptor := descriptors.bytes(length)
+		if descriptors.err {
+			return nil, fmt.Errorf("%w: descriptor 0x%02x", ErrMalformedSection, tag)
+		}
+		if tag != segmentationDescriptorTag {
+			continue
+		}
+		segmentation, ok := readSegmentation(descriptor)
+		if !ok {
+			return nil, fmt.Errorf("%w: segmentation_descriptor", ErrMalformedSection)
+		}
+		if segmentation != nil {
+			info.Segmentations = append(info.Segmentations, segmentation)
+		}
+	}
+
+	if info.HasPTS {
+		info.PTS = (info.PTS + ptsAdjustment) & (1<<33 - 1)
+	}
+	return info, nil
+}
+
+func (info *SpliceInfo) readSpliceInsert(r *reader) {
+	info.EventId = uint32(r.uint(32))
+	info.Cancel = r.flag()
+	r.skipBits(7)
+	if info.Cancel {
+		return
+	}
+
+	info.OutOfNetwork = r.flag()
+	programSplice := r.flag()
+	hasDuration := r.flag()
+	info.Immediate = r.flag()
+	r.skipBits(4)
+	if programSplice && !info.Immediate {
+		info.PTS, info.HasPTS = r.spliceTime()
+	}
+	if !programSplice {
+		// Component splices take the splice time of their first component.
+		components := int(r.uint(8))
+		for i := 0; i < components; i++ {
+			r.skip(1)
+			if !info.Immediate {
+				pts, ok := r.spliceTime()
+				if i == 0 {
+					info.PTS, info.HasPTS = pts, ok
+				}
+			}
+		}
+	}
+	if hasDuration {
+		info.AutoReturn = r.flag()
+		r.skipBits(6)
+		info.BreakDuration, info.HasBreakDuration = r.uint(33), true
+	}
+	// unique_program_id, avail_num and avails_expected
+	r.skip(4)
+}
+
+// readSegmentation reads a segmentation_descriptor, or returns nil for the private descriptors of other identifiers
+// than CUEI. It reports false when the descriptor is truncated.
+func readSegmentation(descriptor []byte) (*Segmentation, bool) {
+	r := &reader{data: descriptor}
+	if identifier := r.uint(32); identifier != cueIdentifier {
+		return nil, !r.err
+	}
+
+	segmentation := &Segmentation{EventId: uint32(r.uint(32))}
+	segmentation.Cancel = r.flag()
+	r.skipBits(7)
+	if segmentation.Cancel {
+		return segmentation, !r.err
+	}
+
+	programSegmentation := r.flag()
+	hasDuration := r.flag()
+	r.skipBits(6)
+	if !programSegmentation {
+		r.skip(6 * int(r.uint(8)))
+	}
+	if hasDuration {
+		segmentation.Duration, segmentation.HasDuration = r.uint(40), true
+	}
+	r.skipBits(8)
+	r.skip(int(r.uint(8)))
+	segmentation.TypeId = byte(r.uint(8))
+	return segmentation, !r.err
+}
+
+// reader reads the big endian bit fields of a section and flags reads beyond its end.
+type reader struct {
+	data   []byte
+	offset int
+	bit    int
+	err    bool
+}
+
+func (r *reader) uint(bits int) uint64 {
+	value := uint64(0)
+	for ; bits > 0; bits-- {
+		if r.offset >= len(r.data) {
+			r.err = true
+			return 0
+		}
+		value = value<<1 | uint64(r.data[r.offset]>>(7-r.bit)&1)
+		r.bit++
+		if r.bit == 8 {
+			r.bit = 0
+			r.offset++
+		}
+	}
+	return value
+}
+
+func (r *reader) flag() bool {
+	return r.uint(1) == 1
+}
+
+func (r *reader) skipBits(bits int) {
+	r.uint(bits)
+}
+
+func (r *reader) skip(bytes int) {
+	if r.offset+bytes > len(r.data) {
+		r.err = true
+		r.offset = len(r.data)
+		return
+	}
+	r.offset += bytes
+}
+
+func (r *reader) bytes(count int) []byte {
+	start := r.offset
+	r.skip(count)
+	if r.err {
+		return nil
+	}
+	return r.data[start:r.offset]
+}
+
+// spliceTime reads a splice_time and reports whether it specifies a time.
+func (r *reader) spliceTime() (uint64, bool) {
+	if !r.flag() {
+		r.skipBits(7)
+		return 0, false
+	}
+	r.skipBits(6)
+	return r.uint(33), true
+}
+
+// crc32 computes the CRC-32/MPEG-2 of the data, which is zero for data ending in its own CRC.
+func crc32(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,85 @@
+package scte35
+
+import (
+	"encoding/base64"
+	"github.com/sehovizko/mobworx-streamer/src/internal/scte35/scte35test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Section  string
+		Expected *SpliceInfo
+	}{
+		{
+			Name:    "splice-insert",
+			Section: "/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo=",
+			Expected: &SpliceInfo{
+				Command:          CommandSpliceInsert,
+				EventId:          0x4800008f,
+				OutOfNetwork:     true,
+				PTS:              0x07369c02e,
+				HasPTS:           true,
+				BreakDuration:    0x0052ccf5,
+				HasBreakDuration: true,
+				AutoReturn:       true,
+			},
+		},
+		{
+			Name:    "time-signal",
+			Section: "/DA0AAAAAAAA///wBQb+cr0AUAAeAhxDVUVJSAAAjn/PAAGlmbAICAAAAAAsoKGKNAIAmsnRfg==",
+			Expected: &SpliceInfo{
+				Command: CommandTimeSignal,
+				PTS:     0x072bd0050,
+				HasPTS:  true,
+				Segmentations: []*Segmentation{
+					{EventId: 0x4800008e, TypeId: 0x34, Duration: 0x0001a599b0, HasDuration: true},
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			data, err := base64.StdEncoding.DecodeString(c.Section)
+			require.NoError(t, err)
+			info, err := Parse(data)
+			require.NoError(t, err)
+			assert.Equal(t, c.Expected, info)
+		})
+	}
+}
+
+func TestParseBuiltSections(t *testing.T) {
+	info, err := Parse(scte35test.SpliceInsert(7, false, 1<<33-1, 0))
+	require.NoError(t, err)
+	assert.Equal(t, &SpliceInfo{Command: CommandSpliceInsert, EventId: 7, PTS: 1<<33 - 1, HasPTS: true}, info)
+
+	info, err = Parse(scte35test.TimeSignal(900000, 9, 0x35, 0))
+	require.NoError(t, err)
+	require.Len(t, info.Segmentations, 1)
+	assert.True(t, info.Segmentations[0].BreakEnd())
+	assert.False(t, info.Segmentations[0].BreakStart())
+}
+
+func TestParseRejectsMalformedSections(t *testing.T) {
+	valid := scte35test.SpliceInsert(7, true, 900000, 2700000)
+	corrupt := append([]byte{}, valid...)
+	corrupt[20] ^= 0x01
+
+	cases := map[string][]byte{
+		"empty":     nil,
+		"table":     append([]byte{0xfd}, valid[1:]...),
+		"truncated": valid[:len(valid)-1],
+		"crc":       corrupt,
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(data)
+			assert.ErrorIs(t, err, ErrMalformedSection)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Package scte35test builds splice_info_sections for tests.
+package scte35test
+
+import "encoding/binary"
+
+// SpliceInsert encodes a program splice_insert at the PTS. A break of the duration in 90 kHz ticks is announced
+// when out is set and the duration is not zero.
+func SpliceInsert(eventId uint32, out bool, pts uint64, duration uint64) []byte {
+	command := binary.BigEndian.AppendUint32(nil, eventId)
+	flags := byte(0x4f)
+	if out {
+		flags |= 0x80
+	}
+	if duration > 0 {
+		flags |= 0x20
+	}
+	command = append(command, 0x7f, flags)
+	command = append(command, spliceTime(pts)...)
+	if duration > 0 {
+		command = append(command, 0xfe|byte(duration>>32&1))
+		command = binary.BigEndian.AppendUint32(command, uint32(duration))
+	}
+	command = append(command, 0, 1, 0, 0)
+	return section(0x05, command, nil)
+}
+
+// TimeSignal encodes a time_signal at the PTS with a program segmentation_descriptor of the type,
+// lasting the duration in 90 kHz ticks unless it is zero.
+func TimeSignal(pts uint64, eventId uint32, typeId byte, duration uint64) []byte {
+	descriptor := []byte{'C', 'U', 'E', 'I'}
+	descriptor = binary.BigEndian.AppendUint32(descriptor, eventId)
+	flags := byte(0xbf)
+	if duration > 0 {
+		flags |= 0x40
+	}
+	descriptor = append(descriptor, 0x7f, flags)
+	if duration > 0 {
+		descriptor = append(descriptor, byte(duration>>32))
+		descriptor = binary.BigEndian.AppendUint32(descriptor, uint32(duration))
+	}
+	descriptor = append(descriptor, 0, 0, typeId, 0, 0)
+	return section(0x06, spliceTime(pts), append([]byte{0x02, byte(len(descriptor))}, descriptor...))
+}
+
+func spliceTime(pts uint64) []byte {
+	return binary.BigEndian.AppendUint32([]byte{0xfe | byte(pts>>32&1)}, uint32(pts))
+}
+
+func section(commandType byte, command []byte, descriptors []byte) []byte {
+	body := []byte{0, 0, 0, 0, 0, 0, 0xff, 0xff}
+	body = binary.BigEndian.AppendUint16(body, 0xf000|uint16(len(command)))
+	body = append(body, commandType)
+	body = append(body, command...)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(descriptors)))
+	body = append(body, descriptors...)
+
+	data := []byte{0xfc}
+	data = binary.BigEndian.AppendUint16(data, 0x3000|uint16(len(body)+4))
+	data = append(data, body...)
+	return binary.BigEndian.AppendUint32(data, crc32(data))
+}
+
+func crc32(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
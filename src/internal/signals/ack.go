@@ -0,0 +1,85 @@
+package signals
+
+import (
+	"github.com/google/uuid"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"time"
+)
+
+type DataAck struct {
+	Action    DataAction               `json:"action"`
+	Version   int                      `json:"version"`
+	Id        uuid.UUID                `json:"id"`
+	Timestamp helpers.Timestamp        `json:"timestamp"`
+	NumBytes  int                      `json:"numbytes"`
+	Latency   int64                    `json:"latency"`
+	Payload   *DataGeneralShapePayload `json:"payload"`
+}
+
+var dataActionToAck = map[DataAction]DataAction{
+	DataActionUpdatePart:         DataActionAckPart,
+	DataActionUpdateRendition:    DataActionAckRendition,
+	DataActionUpdateSegment:      DataActionAckSegment,
+	DataActionUpdateVariant:      DataActionAckVariant,
+	DataActionUpdateDemuxPart:    DataActionAckDemuxPart,
+	DataActionUpdateDemuxSegment: DataActionAckDemuxSegment,
+	DataActionUpdateCaptions:     DataActionAckCaptions,
+	DataActionUpdateDateRange:    DataActionAckDateRange,
+	DataActionTerminate:          DataActionTerminated,
+}
+
+// NewAck acknowledges the message without echoing any of the uploaded media data back to the publisher.
+func NewAck(message *DataGeneralShape, uploadLatency int64) *DataAck {
+	action, ok := dataActionToAck[message.Action]
+	if !ok {
+		action = DataActionUnknown
+	}
+
+	return &DataAck{
+		Action:    action,
+		Version:   message.Version,
+		Id:        uuid.New(),
+		Timestamp: helpers.Timestamp{Time: time.Now()},
+		NumBytes:  message.NumBytes,
+		Latency:   uploadLatency,
+		Payload:   withoutData(message.Payload),
+	}
+}
+
+func withoutData(payload *DataGeneralShapePayload) *DataGeneralShapePayload {
+	if payload == nil {
+		return nil
+	}
+
+	stripped := *payload
+	stripped.Segment = segmentWithoutData(payload.Segment)
+	stripped.AudioSegment = segmentWithoutData(payload.AudioSegment)
+	stripped.Part = partWithoutData(payload.Part)
+	stripped.AudioPart = partWithoutData(payload.AudioPart)
+	return &stripped
+}
+
+func segmentWithoutData(segment *DataGeneralShapePayloadSegment) *DataGeneralShapePayloadSegment {
+	if segment == nil {
+		return nil
+	}
+
+	stripped := *segment
+	stripped.Data = ""
+	if segment.Map != nil {
+		mis := *segment.Map
+		mis.Data = ""
+		stripped.Map = &mis
+	}
+	return &stripped
+}
+
+func partWithoutData(part *DataGeneralShapePayloadPart) *DataGeneralShapePayloadPart {
+	if part == nil {
+		return nil
+	}
+
+	stripped := *part
+	stripped.Data = ""
+	return &stripped
+}
@@ -24,6 +24,13 @@ type DataGeneralShapePayload struct {
 	Rendition *DataGeneralShapePayloadRendition `json:"rendition,omitempty"`
 	Segment   *DataGeneralShapePayloadSegment   `json:"segment"`
 	Part      *DataGeneralShapePayloadPart      `json:"part,omitempty"`
+	// AudioSegment and AudioPart carry the audio rendition of demuxed messages, whose Segment and Part carry the variant.
+	AudioSegment *DataGeneralShapePayloadSegment `json:"audioSegment,omitempty"`
+	AudioPart    *DataGeneralShapePayloadPart    `json:"audioPart,omitempty"`
+	// Captions carry the live caption cues of updateCaptions messages.
+	Captions []*DataGeneralShapePayloadCaption `json:"captions,omitempty"`
+	// DateRange carries the ad marker or metadata of updateDateRange messages.
+	DateRange *DataGeneralShapePayloadDateRange `json:"dateRange,omitempty"`
 }
 
 type DataGeneralShapePayloadPlaylist struct {
@@ -36,9 +43,16 @@ type DataGeneralShapePayloadVariant struct {
 	Codecs             string    `json:"codecs,omitempty"`
 	Bandwidth          int       `json:"bandwidth,omitempty"`
 	Audio              string    `json:"audio,omitempty"`
+	Subtitles          string    `json:"subtitles,omitempty"`
+	ClosedCaptions     string    `json:"closedCaptions,omitempty"`
+	Resolution         string    `json:"resolution,omitempty"`
+	FrameRate          float64   `json:"frameRate,omitempty"`
+	VideoRange         string    `json:"videoRange,omitempty"`
 	Version            int       `json:"version,omitempty"`
 	TargetDuration     int       `json:"targetDuration,omitempty"`
 	TargetPartDuration float64   `json:"targetPartDuration,omitempty"`
+	WindowSegments     int       `json:"windowSegments,omitempty"`
+	WindowDuration     float64   `json:"windowDuration,omitempty"`
 	CacheKey           string    `json:"cacheKey,omitempty"`
 	InitCacheKey       string    `json:"initCacheKey,omitempty"`
 }
@@ -49,10 +63,14 @@ type DataGeneralShapePayloadRendition struct {
 	GroupId            uuid.UUID         `json:"groupId"`
 	Name               string            `json:"name"`
 	Language           string            `json:"language"`
+	Codecs             string            `json:"codecs,omitempty"`
+	InstreamId         string            `json:"instreamId,omitempty"`
 	IsDefault          bool              `json:"isDefault"`
 	AutoSelect         bool              `json:"autoSelect"`
 	TargetDuration     int               `json:"targetDuration"`
 	TargetPartDuration float64           `json:"targetPartDuration"`
+	WindowSegments     int               `json:"windowSegments,omitempty"`
+	WindowDuration     float64           `json:"windowDuration,omitempty"`
 	CacheKey           string            `json:"cacheKey,omitempty"`
 	InitCacheKey       string            `json:"initCacheKey,omitempty"`
 }
@@ -62,6 +80,7 @@ type DataGeneralShapePayloadSegment struct {
 	Sequence        int                         `json:"sequence,omitempty"`
 	Duration        float64                     `json:"duration,omitempty"`
 	Discontinuity   bool                        `json:"discontinuity,omitempty"`
+	Gap             bool                        `json:"gap,omitempty"`
 	ProgramDateTime helpers.Timestamp           `json:"programDateTime,omitempty"`
 	Map             *MediaInitializationSection `json:"map,omitempty"`
 	Data            string                      `json:"data,omitempty"`
@@ -83,25 +102,89 @@ type DataGeneralShapePayloadPart struct {
 	CacheKey    string    `json:"cacheKey,omitempty"`
 }
 
+// DataGeneralShapePayloadCaption is a cue of live caption text. Start and End are wall clock times,
+// which place the cue on the program date time of the video segments.
+type DataGeneralShapePayloadCaption struct {
+	Text     string            `json:"text"`
+	Start    helpers.Timestamp `json:"start"`
+	End      helpers.Timestamp `json:"end"`
+	Language string            `json:"language"`
+}
+
+// DataGeneralShapePayloadDateRange carries either a base64 encoded SCTE-35 splice_info_section or plain date range
+// metadata. Without a start date the date range starts at the splice time of the section, or at the live edge.
+// Attributes are the client defined X- attributes, whose values are quoted unless they are hexadecimal sequences.
+type DataGeneralShapePayloadDateRange struct {
+	Id              string            `json:"id,omitempty"`
+	Class           string            `json:"class,omitempty"`
+	StartDate       helpers.Timestamp `json:"startDate"`
+	EndDate         helpers.Timestamp `json:"endDate"`
+	Duration        float64           `json:"duration,omitempty"`
+	PlannedDuration float64           `json:"plannedDuration,omitempty"`
+	Attributes      map[string]string `json:"attributes,omitempty"`
+	SCTE35          string            `json:"scte35,omitempty"`
+}
+
 type DataAction string
 
 const (
-	DataActionUpdatePart      DataAction = "updatePart"
-	DataActionUpdateRendition DataAction = "updateRendition"
-	DataActionUpdateSegment   DataAction = "updateSegment"
-	DataActionUpdateVariant   DataAction = "updateVariant"
+	DataActionUpdatePart         DataAction = "updatePart"
+	DataActionUpdateRendition    DataAction = "updateRendition"
+	DataActionUpdateSegment      DataAction = "updateSegment"
+	DataActionUpdateVariant      DataAction = "updateVariant"
+	DataActionUpdateDemuxPart    DataAction = "updateDemuxPart"
+	DataActionUpdateDemuxSegment DataAction = "updateDemuxSegment"
+	DataActionUpdateCaptions     DataAction = "updateCaptions"
+	DataActionUpdateDateRange    DataAction = "updateDateRange"
+	DataActionAckPart            DataAction = "ackPart"
+	DataActionAckRendition       DataAction = "ackRendition"
+	DataActionAckSegment         DataAction = "ackSegment"
+	DataActionAckVariant         DataAction = "ackVariant"
+	DataActionAckDemuxPart       DataAction = "ackDemuxPart"
+	DataActionAckDemuxSegment    DataAction = "ackDemuxSegment"
+	DataActionAckCaptions        DataAction = "ackCaptions"
+	DataActionAckDateRange       DataAction = "ackDateRange"
+	DataActionTerminate          DataAction = "terminate"
+	DataActionTerminated         DataAction = "terminated"
+	DataActionUnknown            DataAction = "unknown"
 )
 
 type DataRenditionType string
 
 const (
+	DataRenditionTypeVideo          DataRenditionType = "VIDEO"
 	DataRenditionTypeAudio          DataRenditionType = "AUDIO"
 	DataRenditionTypeSubtitles      DataRenditionType = "SUBTITLES"
 	DataRenditionTypeClosedCaptions DataRenditionType = "CLOSED-CAPTIONS"
 )
 
+type MimeType string
+
+const (
+	MimeTypeVideo       MimeType = "video/mp4"
+	MimeTypeAudio       MimeType = "audio/mp4"
+	MimeTypeApplication MimeType = "application/mp4"
+	MimeTypeWebVTT      MimeType = "text/vtt"
+)
+
+var renditionTypeToMimeType = map[DataRenditionType]MimeType{
+	DataRenditionTypeVideo: MimeTypeVideo,
+	DataRenditionTypeAudio: MimeTypeAudio,
+}
+
+// GetMimeType returns the content type of the fMP4 media of a rendition type.
+func GetMimeType(renditionType DataRenditionType) string {
+	if value, ok := renditionTypeToMimeType[renditionType]; ok {
+		return string(value)
+	}
+	return string(MimeTypeApplication)
+}
+
 var (
 	ErrNoTimestampFound = fmt.Errorf("%d: no timestamp found", 400)
+	ErrNoPayload        = fmt.Errorf("%d: message carries no payload", 400)
+	ErrNoPlaylist       = fmt.Errorf("%d: payload carries no playlist", 400)
+	ErrNoVariantSegment = fmt.Errorf("%d: payload of a variant carries no segment", 400)
 )
 
 func NewDataMessage(message string, encoded bool) (*DataGeneralShape, error) {
@@ -122,9 +205,18 @@ func NewDataMessageFromBuffer(buffer []byte) (*DataGeneralShape, error) {
 		return nil, err
 	}
 
+	if dgs.Payload == nil {
+		return nil, ErrNoPayload
+	}
+	if dgs.Payload.Playlist == nil {
+		return nil, ErrNoPlaylist
+	}
 	masterPlaylistId := dgs.Payload.Playlist.Id.String()
 
 	if dgs.Payload.Variant != nil {
+		if dgs.Payload.Segment == nil {
+			return nil, ErrNoVariantSegment
+		}
 		dgs.Payload.Variant.CacheKey = masterPlaylistId + "/" + dgs.Payload.Variant.Id.String()
 		if dgs.Payload.Segment.Map != nil {
 			dgs.Payload.Variant.InitCacheKey = masterPlaylistId + "/" + dgs.Payload.Segment.Map.Id.String()
@@ -132,18 +224,27 @@ func NewDataMessageFromBuffer(buffer []byte) (*DataGeneralShape, error) {
 	}
 
 	if dgs.Payload.Rendition != nil {
+		// The rendition of a demuxed message has a segment of its own.
+		renditionSegment := dgs.Payload.Segment
+		if dgs.Payload.AudioSegment != nil {
+			renditionSegment = dgs.Payload.AudioSegment
+		}
 		dgs.Payload.Rendition.CacheKey = masterPlaylistId + "/" + dgs.Payload.Rendition.Id.String()
-		if dgs.Payload.Segment.Map != nil {
-			dgs.Payload.Rendition.InitCacheKey = masterPlaylistId + "/" + dgs.Payload.Segment.Map.Id.String()
+		if renditionSegment != nil && renditionSegment.Map != nil {
+			dgs.Payload.Rendition.InitCacheKey = masterPlaylistId + "/" + renditionSegment.Map.Id.String()
 		}
 	}
 
-	if dgs.Payload.Segment != nil {
-		dgs.Payload.Segment.CacheKey = masterPlaylistId + "/" + dgs.Payload.Segment.Id.String()
+	for _, segment := range []*DataGeneralShapePayloadSegment{dgs.Payload.Segment, dgs.Payload.AudioSegment} {
+		if segment != nil {
+			segment.CacheKey = masterPlaylistId + "/" + segment.Id.String()
+		}
 	}
 
-	if dgs.Payload.Part != nil {
-		dgs.Payload.Part.CacheKey = masterPlaylistId + "/" + dgs.Payload.Part.Id.String()
+	for _, part := range []*DataGeneralShapePayloadPart{dgs.Payload.Part, dgs.Payload.AudioPart} {
+		if part != nil {
+			part.CacheKey = masterPlaylistId + "/" + part.Id.String()
+		}
 	}
 
 	return dgs, nil
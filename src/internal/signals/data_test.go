@@ -90,6 +90,69 @@ func TestNewDataMessageFromBuffer(t *testing.T) {
 	}
 }
 
+func TestNewDataMessageFromBufferRejectsIncompletePayloads(t *testing.T) {
+	cases := []struct {
+		Value string
+		Err   error
+	}{
+		{Value: `{"action": "terminate"}`, Err: ErrNoPayload},
+		{Value: `{"action": "terminate", "payload": null}`, Err: ErrNoPayload},
+		{Value: `{"action": "terminate", "payload": {}}`, Err: ErrNoPlaylist},
+		{
+			Value: `{"action": "updateVariant", "payload": {"variant": {"id": "a3e4e680-b11f-11ed-afa1-0242ac120002"}}}`,
+			Err:   ErrNoPlaylist,
+		},
+		{
+			Value: `
+{
+	"action": "updateVariant",
+	"payload": {
+		"playlist": {"id": "932ac3aa-b11f-11ed-afa1-0242ac120002"},
+		"variant": {"id": "a3e4e680-b11f-11ed-afa1-0242ac120002"}
+	}
+}`,
+			Err: ErrNoVariantSegment,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run("Case/"+strconv.Itoa(i+1), func(t *testing.T) {
+			_, err := NewDataMessageFromBuffer([]byte(c.Value))
+			assert.ErrorIs(t, err, c.Err)
+		})
+	}
+}
+
+func TestNewDataMessageFromBufferCaptions(t *testing.T) {
+	got, err := NewDataMessageFromBuffer([]byte(`
+{
+	"action": "updateCaptions",
+	"payload": {
+		"playlist": {"id": "932ac3aa-b11f-11ed-afa1-0242ac120002"},
+		"captions": [{"text": "Hello", "start": 1676898433, "end": 1676898435, "language": "en"}]
+	}
+}`))
+	require.NoError(t, err)
+	require.Len(t, got.Payload.Captions, 1)
+	assert.True(t, time.Unix(1676898433, 0).Equal(got.Payload.Captions[0].Start.Time))
+	assert.True(t, time.Unix(1676898435, 0).Equal(got.Payload.Captions[0].End.Time))
+}
+
+func TestNewDataMessageFromBufferDateRange(t *testing.T) {
+	got, err := NewDataMessageFromBuffer([]byte(`
+{
+	"action": "updateDateRange",
+	"payload": {
+		"playlist": {"id": "932ac3aa-b11f-11ed-afa1-0242ac120002"},
+		"dateRange": {"id": "splice-1", "startDate": 1676898433, "endDate": 1676898463}
+	}
+}`))
+	require.NoError(t, err)
+	require.NotNil(t, got.Payload.DateRange)
+	assert.True(t, time.Unix(1676898433, 0).Equal(got.Payload.DateRange.StartDate.Time))
+	assert.True(t, time.Unix(1676898463, 0).Equal(got.Payload.DateRange.EndDate.Time))
+}
+
 func TestNewDataMessage(t *testing.T) {
 	cases := []struct {
 		Value    string
@@ -136,3 +199,38 @@ func TestDataGeneralShape_UploadLatencyFromNow(t *testing.T) {
 	require.NoError(t, err)
 	assert.Greater(t, now, time.Duration(10206304))
 }
+
+func TestNewDataMessageFromBufferDemuxed(t *testing.T) {
+	got, err := NewDataMessageFromBuffer([]byte(`
+{
+	"action": "updateDemuxSegment",
+	"payload": {
+		"playlist": {"id": "932ac3aa-b11f-11ed-afa1-0242ac120002"},
+		"variant": {"id": "a3e4e680-b11f-11ed-afa1-0242ac120002"},
+		"rendition": {"id": "d02288ec-b11f-11ed-afa1-0242ac120002", "type": "AUDIO"},
+		"segment": {
+			"id": "a8652304-b120-11ed-afa1-0242ac120002",
+			"map": {"id": "c9258c1e-b120-11ed-afa1-0242ac120002"}
+		},
+		"audioSegment": {
+			"id": "4c3e2a8e-b123-11ed-afa1-0242ac120002",
+			"map": {"id": "5a1c1f3a-b123-11ed-afa1-0242ac120002"}
+		},
+		"part": {"id": "d9c836d4-b120-11ed-afa1-0242ac120002"},
+		"audioPart": {"id": "6b0e4a52-b123-11ed-afa1-0242ac120002"}
+	}
+}`))
+	require.NoError(t, err)
+
+	payload := got.Payload
+	assert.Equal(t, DataActionUpdateDemuxSegment, got.Action)
+	assert.Equal(t, "932ac3aa-b11f-11ed-afa1-0242ac120002/c9258c1e-b120-11ed-afa1-0242ac120002", payload.Variant.InitCacheKey)
+	assert.Equal(t, "932ac3aa-b11f-11ed-afa1-0242ac120002/5a1c1f3a-b123-11ed-afa1-0242ac120002", payload.Rendition.InitCacheKey)
+	assert.Equal(t, "932ac3aa-b11f-11ed-afa1-0242ac120002/a8652304-b120-11ed-afa1-0242ac120002", payload.Segment.CacheKey)
+	assert.Equal(t, "932ac3aa-b11f-11ed-afa1-0242ac120002/4c3e2a8e-b123-11ed-afa1-0242ac120002", payload.AudioSegment.CacheKey)
+	assert.Equal(t, "932ac3aa-b11f-11ed-afa1-0242ac120002/d9c836d4-b120-11ed-afa1-0242ac120002", payload.Part.CacheKey)
+	assert.Equal(t, "932ac3aa-b11f-11ed-afa1-0242ac120002/6b0e4a52-b123-11ed-afa1-0242ac120002", payload.AudioPart.CacheKey)
+
+	ack := NewAck(got, 0)
+	assert.Equal(t, DataActionAckDemuxSegment, ack.Action)
+}
@@ -0,0 +1,211 @@
+package webvtt
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrMalformedWebVTT = fmt.Errorf("%d: malformed WebVTT", 400)
+
+// MpegTsClock is the frequency of the MPEG-2 timestamps of X-TIMESTAMP-MAP.
+const MpegTsClock = 90000
+
+const (
+	signature         = "WEBVTT"
+	byteOrderMark     = "\uFEFF"
+	timestampMapField = "X-TIMESTAMP-MAP="
+	cueTimingArrow    = "-->"
+)
+
+// File is a parsed WebVTT segment.
+type File struct {
+	// TimestampMap is the X-TIMESTAMP-MAP header of an HLS subtitle segment, or nil when it has none.
+	TimestampMap *TimestampMap
+	Cues         []*Cue
+}
+
+// TimestampMap maps the cue timeline of a segment onto the media timeline, whose MPEG-2 timestamp
+// MpegTs corresponds to the cue time Local.
+type TimestampMap struct {
+	MpegTs uint64
+	Local  time.Duration
+}
+
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	// Text is the payload of the cue, which may carry WebVTT markup.
+	Text string
+}
+
+// Is tells whether the data starts with the WebVTT file signature.
+func Is(data []byte) bool {
+	data = bytes.TrimPrefix(data, []byte(byteOrderMark))
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return false
+	}
+	rest := data[len(signature):]
+	return len(rest) == 0 || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n' || rest[0] == '\r'
+}
+
+// Parse reads the header and the cues of a WebVTT file. Cue settings, regions and styles are skipped.
+func Parse(data []byte) (*File, error) {
+	if !Is(data) {
+		return nil, fmt.Errorf("%w: missing %s signature", ErrMalformedWebVTT, signature)
+	}
+
+	text := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+	blocks := strings.Split(text, "\n\n")
+	file := &File{}
+
+	for _, line := range strings.Split(blocks[0], "\n")[1:] {
+		if value, found := strings.CutPrefix(line, timestampMapField); found {
+			timestampMap, err := parseTimestampMap(value)
+			if err != nil {
+				return nil, err
+			}
+			file.TimestampMap = timestampMap
+		}
+	}
+
+	for _, block := range blocks[1:] {
+		lines := strings.Split(block, "\n")
+		for index, line := range lines {
+			if !strings.Contains(line, cueTimingArrow) {
+				continue
+			}
+			cue, err := parseCueTiming(line)
+			if err != nil {
+				return nil, err
+			}
+			cue.Text = strings.TrimRight(strings.Join(lines[index+1:], "\n"), "\n")
+			file.Cues = append(file.Cues, cue)
+			break
+		}
+	}
+	return file, nil
+}
+
+// MediaTime returns the position of a cue time on the media timeline in seconds, applying the timestamp map.
+func (f *File) MediaTime(cueTime time.Duration) float64 {
+	if f.TimestampMap == nil {
+		return cueTime.Seconds()
+	}
+	return float64(f.TimestampMap.MpegTs)/MpegTsClock + (cueTime - f.TimestampMap.Local).Seconds()
+}
+
+// Bytes writes the file with its timestamp map, if any, and its cues in order.
+func (f *File) Bytes() []byte {
+	b := &bytes.Buffer{}
+	b.WriteString(signature + "\n")
+	if f.TimestampMap != nil {
+		fmt.Fprintf(b, "%sMPEGTS:%d,LOCAL:%s\n", timestampMapField, f.TimestampMap.MpegTs, formatTimestamp(f.TimestampMap.Local))
+	}
+	for _, cue := range f.Cues {
+		fmt.Fprintf(b, "\n%s %s %s\n", formatTimestamp(cue.Start), cueTimingArrow, formatTimestamp(cue.End))
+		if cue.Text != "" {
+			b.WriteString(cue.Text + "\n")
+		}
+	}
+	return b.Bytes()
+}
+
+// EscapeText turns plain text into a cue payload: markup characters are escaped, and the blank lines
+// and arrows which would end the cue or be taken for a cue timing are left out.
+func EscapeText(text string) string {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	text = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseTimestampMap(value string) (*TimestampMap, error) {
+	timestampMap := &TimestampMap{}
+	var hasMpegTs, hasLocal bool
+	for _, field := range strings.Split(value, ",") {
+		name, fieldValue, _ := strings.Cut(strings.TrimSpace(field), ":")
+		switch name {
+		case "MPEGTS":
+			mpegTs, err := strconv.ParseUint(fieldValue, 10, 64)
+			if err != nil || mpegTs >= 1<<33 {
+				return nil, fmt.Errorf("%w: X-TIMESTAMP-MAP MPEGTS %q", ErrMalformedWebVTT, fieldValue)
+			}
+			timestampMap.MpegTs, hasMpegTs = mpegTs, true
+		case "LOCAL":
+			local, err := parseTimestamp(fieldValue)
+			if err != nil {
+				return nil, err
+			}
+			timestampMap.Local, hasLocal = local, true
+		}
+	}
+	if !hasMpegTs || !hasLocal {
+		return nil, fmt.Errorf("%w: X-TIMESTAMP-MAP %q needs MPEGTS and LOCAL", ErrMalformedWebVTT, value)
+	}
+	return timestampMap, nil
+}
+
+func parseCueTiming(line string) (*Cue, error) {
+	start, rest, _ := strings.Cut(line, cueTimingArrow)
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: cue timing %q", ErrMalformedWebVTT, line)
+	}
+
+	cue := &Cue{}
+	var err error
+	cue.Start, err = parseTimestamp(strings.TrimSpace(start))
+	if err != nil {
+		return nil, err
+	}
+	cue.End, err = parseTimestamp(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	if cue.End < cue.Start {
+		return nil, fmt.Errorf("%w: cue ends before it starts in %q", ErrMalformedWebVTT, line)
+	}
+	return cue, nil
+}
+
+// parseTimestamp reads a WebVTT timestamp of the form [hh:]mm:ss.ttt.
+func parseTimestamp(value string) (time.Duration, error) {
+	clock, millis, found := strings.Cut(value, ".")
+	parts := strings.Split(clock, ":")
+	if !found || len(millis) != 3 || len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("%w: timestamp %q", ErrMalformedWebVTT, value)
+	}
+
+	var timestamp time.Duration
+	units := []time.Duration{time.Hour, time.Minute, time.Second}[3-len(parts):]
+	for i, part := range append(parts, millis) {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 || (i > 0 && i < len(parts) && number > 59) {
+			return 0, fmt.Errorf("%w: timestamp %q", ErrMalformedWebVTT, value)
+		}
+		if i == len(parts) {
+			timestamp += time.Duration(number) * time.Millisecond
+		} else {
+			timestamp += time.Duration(number) * units[i]
+		}
+	}
+	return timestamp, nil
+}
+
+// formatTimestamp writes a WebVTT timestamp of the form hh:mm:ss.ttt, rounded to the millisecond.
+func formatTimestamp(timestamp time.Duration) string {
+	millis := timestamp.Round(time.Millisecond).Milliseconds()
+	if millis < 0 {
+		millis = 0
+	}
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", millis/3600000, millis/60000%60, millis/1000%60, millis%1000)
+}
@@ -0,0 +1,74 @@
+package webvtt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	data := "\uFEFFWEBVTT\r\nX-TIMESTAMP-MAP=LOCAL:00:00:00.000,MPEGTS:900000\r\n\r\n" +
+		"NOTE timings follow\r\n\r\n" +
+		"1\r\n00:00:01.500 --> 00:00:03.000 align:start\r\nHello\r\n\r\n" +
+		"01:02.250 --> 01:04.000\r\n<v Speaker>World --> again\r\n"
+
+	file, err := Parse([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, &TimestampMap{MpegTs: 900000, Local: 0}, file.TimestampMap)
+	assert.Equal(t, []*Cue{
+		{Start: 1500 * time.Millisecond, End: 3 * time.Second, Text: "Hello"},
+		{Start: time.Minute + 2250*time.Millisecond, End: time.Minute + 4*time.Second, Text: "<v Speaker>World --> again"},
+	}, file.Cues)
+	assert.Equal(t, 11.5, file.MediaTime(file.Cues[0].Start))
+}
+
+func TestParseWithoutTimestampMap(t *testing.T) {
+	file, err := Parse([]byte("WEBVTT - live captions\n\n00:00:04.000 --> 00:00:05.000\nHi\n"))
+	require.NoError(t, err)
+	assert.Nil(t, file.TimestampMap)
+	assert.Equal(t, 4.0, file.MediaTime(file.Cues[0].Start))
+}
+
+func TestBytes(t *testing.T) {
+	file := &File{
+		TimestampMap: &TimestampMap{MpegTs: 8589844592},
+		Cues: []*Cue{
+			{Start: 0, End: 1500 * time.Millisecond, Text: "Hello"},
+			{Start: time.Hour + 2*time.Second, End: time.Hour + 3*time.Second + 499600*time.Microsecond},
+		},
+	}
+
+	data := file.Bytes()
+	assert.Equal(t, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:8589844592,LOCAL:00:00:00.000\n\n"+
+		"00:00:00.000 --> 00:00:01.500\nHello\n\n"+
+		"01:00:02.000 --> 01:00:03.500\n", string(data))
+
+	parsed, err := Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, file.TimestampMap, parsed.TimestampMap)
+	assert.Equal(t, "Hello", parsed.Cues[0].Text)
+}
+
+func TestEscapeText(t *testing.T) {
+	assert.Equal(t, "Tom &amp; Jerry\n&lt;b&gt; --&gt; here", EscapeText("Tom & Jerry\r\n\r\n<b> --> here\n"))
+}
+
+func TestParseRejectsMalformedFiles(t *testing.T) {
+	cases := map[string]string{
+		"signature":     "WEBVTTX\n\n",
+		"empty":         "",
+		"mpegts":        "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:abc,LOCAL:00:00.000\n\n",
+		"local missing": "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:0\n\n",
+		"timestamp":     "WEBVTT\n\n00:00:01,000 --> 00:00:02.000\n",
+		"minutes":       "WEBVTT\n\n00:61:01.000 --> 01:00:02.000\n",
+		"order":         "WEBVTT\n\n00:00:02.000 --> 00:00:01.000\n",
+		"end missing":   "WEBVTT\n\n00:00:02.000 -->\n",
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(data))
+			assert.ErrorIs(t, err, ErrMalformedWebVTT)
+		})
+	}
+}
@@ -12,13 +12,19 @@ export class StreamerStack extends Stack {
     super(scope, id, props);
     const { vpc } = new VpcNestedStack(this, "VPC");
 
-    new EndpointNestedStack(this, "EndpointNestedStack", {
-      vpc,
-      api: this.api,
-    });
+    const { redisCluster, adBucket } = new EndpointNestedStack(
+      this,
+      "EndpointNestedStack",
+      {
+        vpc,
+        api: this.api,
+      }
+    );
     new StreamingNestedStack(this, "StreamingNestedStack", {
       vpc,
       api: this.api,
+      redisAddress: redisCluster.attrRedisEndpointAddress,
+      adBucket,
     });
   }
 }
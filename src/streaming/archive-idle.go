@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/archive"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	redisClient *redis.Client
+	locker      *redlock.Redlock
+	utils       helpers.Utils
+	// timeout is the time without any update after which a live stream counts as finished.
+	timeout = 5 * time.Minute
+)
+
+func HandleArchiveIdle(ctx aws.Context, event events.CloudWatchEvent) error {
+	archived, err := archive.NewArchiver(repository.NewStreamRepository(redisClient), locker, utils).ArchiveIdle(ctx, timeout)
+	for _, playlistId := range archived {
+		log.Println("archived timed out stream ", playlistId)
+	}
+	return err
+}
+
+func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	locker = redlock.New(redisClient)
+	if addresses := os.Getenv("REDLOCK_ADDRESSES"); addresses != "" {
+		locker = redlock.NewFromAddresses(addresses)
+	}
+	if seconds, err := strconv.Atoi(os.Getenv("STREAM_TIMEOUT")); err == nil {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	utils = helpers.NewUtils(session.Must(session.NewSession()), "", "")
+	lambda.Start(HandleArchiveIdle)
+}
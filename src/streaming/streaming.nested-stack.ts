@@ -2,25 +2,126 @@ import { join } from "path";
 import { HttpApi, HttpMethod } from "@aws-cdk/aws-apigatewayv2-alpha";
 import { HttpLambdaIntegration } from "@aws-cdk/aws-apigatewayv2-integrations-alpha";
 import { GoFunction } from "@aws-cdk/aws-lambda-go-alpha";
-import { NestedStack } from "aws-cdk-lib";
+import { Duration, NestedStack } from "aws-cdk-lib";
 import { Vpc } from "aws-cdk-lib/aws-ec2";
+import { Rule, Schedule } from "aws-cdk-lib/aws-events";
+import { LambdaFunction } from "aws-cdk-lib/aws-events-targets";
+import {
+  BlockPublicAccess,
+  Bucket,
+  IBucket,
+  ObjectOwnership,
+} from "aws-cdk-lib/aws-s3";
 import { NestedStackProps } from "aws-cdk-lib/core/lib/nested-stack";
 import { Construct } from "constructs";
 
 export interface StreamingNestedStackProps extends NestedStackProps {
   vpc: Vpc;
   api: HttpApi;
+  redisAddress: string;
+  adBucket: IBucket;
 }
 
 export class StreamingNestedStack extends NestedStack {
+  // finished streams are archived with public-read objects for replay
+  archiveBucket = new Bucket(this, "ArchiveBucket", {
+    objectOwnership: ObjectOwnership.BUCKET_OWNER_PREFERRED,
+    blockPublicAccess: new BlockPublicAccess({
+      blockPublicAcls: false,
+      ignorePublicAcls: false,
+      blockPublicPolicy: true,
+      restrictPublicBuckets: true,
+    }),
+  });
+
   updatePartLambda = new GoFunction(this, "UpdatePart", {
     entry: join(__dirname, "update-part.go"),
     vpc: this.props.vpc,
+    timeout: Duration.seconds(10),
+    environment: {
+      REDIS_ADDRESS: this.props.redisAddress,
+      // records part timing discrepancies without rewriting what publishers declare
+      CORRECT_PART_TIMING: "false",
+    },
+  });
+
+  updateSegmentLambda = new GoFunction(this, "UpdateSegment", {
+    entry: join(__dirname, "update-segment.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.seconds(10),
+    environment: {
+      REDIS_ADDRESS: this.props.redisAddress,
+      // keeps a minute of media per variant and rendition on the cache node
+      LIVE_WINDOW_DURATION: "60",
+      // waits this many seconds for live captions of a segment before publishing its subtitles
+      CAPTION_DELAY: "6",
+      // splices the ad assets stored here into the ad breaks of variant playlists
+      AD_BUCKET: this.props.adBucket.bucketName,
+    },
+  });
+
+  updateCaptionsLambda = new GoFunction(this, "UpdateCaptions", {
+    entry: join(__dirname, "update-captions.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.seconds(10),
+    environment: {
+      REDIS_ADDRESS: this.props.redisAddress,
+      LIVE_WINDOW_DURATION: "60",
+      CAPTION_DELAY: "6",
+    },
+  });
+
+  updateDateRangeLambda = new GoFunction(this, "UpdateDateRange", {
+    entry: join(__dirname, "update-daterange.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.seconds(10),
+    environment: {
+      REDIS_ADDRESS: this.props.redisAddress,
+    },
+  });
+
+  updateVariantLambda = new GoFunction(this, "UpdateVariant", {
+    entry: join(__dirname, "update-variant.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.seconds(10),
+    environment: {
+      REDIS_ADDRESS: this.props.redisAddress,
+    },
   });
 
   updateRenditionLambda = new GoFunction(this, "UpdateRendition", {
     entry: join(__dirname, "update-rendition.go"),
     vpc: this.props.vpc,
+    timeout: Duration.seconds(10),
+    environment: {
+      REDIS_ADDRESS: this.props.redisAddress,
+    },
+  });
+
+  terminateLambda = new GoFunction(this, "Terminate", {
+    entry: join(__dirname, "terminate.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.minutes(5),
+    environment: {
+      REDIS_ADDRESS: this.props.redisAddress,
+      S3_USER_BUCKET: this.archiveBucket.bucketName,
+    },
+  });
+
+  archiveIdleLambda = new GoFunction(this, "ArchiveIdle", {
+    entry: join(__dirname, "archive-idle.go"),
+    vpc: this.props.vpc,
+    timeout: Duration.minutes(5),
+    environment: {
+      REDIS_ADDRESS: this.props.redisAddress,
+      S3_USER_BUCKET: this.archiveBucket.bucketName,
+      STREAM_TIMEOUT: "300",
+    },
+  });
+
+  archiveIdleRule = new Rule(this, "ArchiveIdleRule", {
+    schedule: Schedule.rate(Duration.minutes(1)),
+    targets: [new LambdaFunction(this.archiveIdleLambda)],
   });
 
   constructor(
@@ -30,6 +131,13 @@ export class StreamingNestedStack extends NestedStack {
   ) {
     super(scope, id, props);
 
+    this.props.adBucket.grantRead(this.updateSegmentLambda);
+
+    [this.terminateLambda, this.archiveIdleLambda].forEach((archiver) => {
+      this.archiveBucket.grantPut(archiver);
+      this.archiveBucket.grantPutAcl(archiver);
+    });
+
     [
       {
         path: "/live/update/part",
@@ -39,6 +147,38 @@ export class StreamingNestedStack extends NestedStack {
           this.updatePartLambda
         ),
       },
+      {
+        path: "/live/update/segment",
+        methods: [HttpMethod.POST],
+        integration: new HttpLambdaIntegration(
+          "updateSegmentHttp",
+          this.updateSegmentLambda
+        ),
+      },
+      {
+        path: "/live/update/captions",
+        methods: [HttpMethod.POST],
+        integration: new HttpLambdaIntegration(
+          "updateCaptionsHttp",
+          this.updateCaptionsLambda
+        ),
+      },
+      {
+        path: "/live/update/daterange",
+        methods: [HttpMethod.POST],
+        integration: new HttpLambdaIntegration(
+          "updateDateRangeHttp",
+          this.updateDateRangeLambda
+        ),
+      },
+      {
+        path: "/live/update/variant",
+        methods: [HttpMethod.POST],
+        integration: new HttpLambdaIntegration(
+          "updateVariantHttp",
+          this.updateVariantLambda
+        ),
+      },
       {
         path: "/live/update/rendition",
         methods: [HttpMethod.POST],
@@ -47,6 +187,14 @@ export class StreamingNestedStack extends NestedStack {
           this.updateRenditionLambda
         ),
       },
+      {
+        path: "/live/terminate",
+        methods: [HttpMethod.POST],
+        integration: new HttpLambdaIntegration(
+          "terminateHttp",
+          this.terminateLambda
+        ),
+      },
     ].forEach((route) => this.props.api.addRoutes(route));
   }
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/archive"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"log"
+	"os"
+)
+
+var (
+	redisClient *redis.Client
+	locker      *redlock.Redlock
+	awsSession  *session.Session
+)
+
+func HandleTerminate(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("connection domain name: %s, stage: %s", event.RequestContext.DomainName, event.RequestContext.Stage)
+	message, err := signals.NewDataMessage(event.Body, event.IsBase64Encoded)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
+	}
+
+	uploadLatency, err := message.UploadLatencyFromNow()
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
+	}
+
+	utils := helpers.NewUtils(awsSession, event.RequestContext.DomainName, event.RequestContext.Stage)
+	playlistId := message.Payload.Playlist.Id.String()
+	err = archive.NewArchiver(repository.NewStreamRepository(redisClient), locker, utils).Archive(ctx, playlistId)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
+	}
+	log.Println("archived stream ", playlistId)
+
+	body, err := json.Marshal(signals.NewAck(message, uploadLatency))
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(body),
+	}, nil
+}
+
+func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	locker = redlock.New(redisClient)
+	if addresses := os.Getenv("REDLOCK_ADDRESSES"); addresses != "" {
+		locker = redlock.NewFromAddresses(addresses)
+	}
+	awsSession = session.Must(session.NewSession())
+	lambda.Start(HandleTerminate)
+}
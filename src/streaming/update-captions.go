@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/ingest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	redisClient  *redis.Client
+	locker       *redlock.Redlock
+	window       = ingest.DefaultWindow
+	captionDelay = ingest.DefaultCaptionDelay
+)
+
+func HandleUpdateCaptions(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("connection domain name: %s, stage: %s", event.RequestContext.DomainName, event.RequestContext.Stage)
+	message, err := signals.NewDataMessage(event.Body, event.IsBase64Encoded)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
+	}
+
+	uploadLatency, err := message.UploadLatencyFromNow()
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
+	}
+	log.Println("upload time is ", uploadLatency)
+
+	ingester := ingest.NewIngester(repository.NewStreamRepository(redisClient), locker)
+	ingester.Window = window
+	ingester.CaptionDelay = captionDelay
+	err = ingester.UpdateCaptions(ctx, message)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
+	}
+
+	body, err := json.Marshal(signals.NewAck(message, uploadLatency))
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(body),
+	}, nil
+}
+
+func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	locker = redlock.New(redisClient)
+	if addresses := os.Getenv("REDLOCK_ADDRESSES"); addresses != "" {
+		locker = redlock.NewFromAddresses(addresses)
+	}
+	if segments, err := strconv.Atoi(os.Getenv("LIVE_WINDOW_SEGMENTS")); err == nil {
+		window.Segments = segments
+	}
+	if duration, err := strconv.ParseFloat(os.Getenv("LIVE_WINDOW_DURATION"), 64); err == nil {
+		window.Duration = duration
+	}
+	if delay, err := strconv.ParseFloat(os.Getenv("CAPTION_DELAY"), 64); err == nil {
+		captionDelay = time.Duration(delay * float64(time.Second))
+	}
+	lambda.Start(HandleUpdateCaptions)
+}
@@ -1,43 +1,85 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/ingest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
 	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
 	"log"
+	"os"
+	"strconv"
 )
 
-func HandleUploadPart(_ aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+var (
+	redisClient  *redis.Client
+	locker       *redlock.Redlock
+	correctParts bool
+)
+
+func HandleUploadPart(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("connection domain name: %s, stage: %s", event.RequestContext.DomainName, event.RequestContext.Stage)
 	message, err := signals.NewDataMessage(event.Body, event.IsBase64Encoded)
 	if err != nil {
-		return events.APIGatewayProxyResponse{}, err
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
 	}
 
 	uploadLatency, err := message.UploadLatencyFromNow()
 	if err != nil {
-		return events.APIGatewayProxyResponse{}, err
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
 	}
 	log.Println("upload time is ", uploadLatency)
 
-	if !message.Payload.Part.Gap {
-		// TODO: call redis client to cache the data with Part cache key
+	ingester := ingest.NewIngester(repository.NewStreamRepository(redisClient), locker)
+	ingester.CorrectParts = correctParts
+	update := ingester.UpdatePart
+	if message.Action == signals.DataActionUpdateDemuxPart {
+		update = ingester.UpdateDemuxPart
 	}
-
-	if message.Payload.Segment.Map != nil {
-		// TODO: call redis client to cache the data with Variant or Rendition initial cache key
+	err = update(ctx, message)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
 	}
 
-	// TODO: use redlock to lock by the Variant or Rendition cache key
-	// TODO: call updatePart()
-	// TODO: unlock redlock by the Variant or Rendition cache key
-
-	// TODO: ack() to the client
+	body, err := json.Marshal(signals.NewAck(message, uploadLatency))
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(err),
+			Body:       err.Error(),
+		}, nil
+	}
 
-	return events.APIGatewayProxyResponse{}, nil
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(body),
+	}, nil
 }
 
 func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	locker = redlock.New(redisClient)
+	if addresses := os.Getenv("REDLOCK_ADDRESSES"); addresses != "" {
+		locker = redlock.NewFromAddresses(addresses)
+	}
+	correctParts, _ = strconv.ParseBool(os.Getenv("CORRECT_PART_TIMING"))
 	lambda.Start(HandleUploadPart)
 }
@@ -1,43 +1,79 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/redis/go-redis/v9"
+	"github.com/sehovizko/mobworx-streamer/src/internal/helpers"
+	"github.com/sehovizko/mobworx-streamer/src/internal/ingest"
+	"github.com/sehovizko/mobworx-streamer/src/internal/redlock"
+	"github.com/sehovizko/mobworx-streamer/src/internal/repository"
+	"github.com/sehovizko/mobworx-streamer/src/internal/signals"
+	"log"
+	"os"
 )
 
-type RenditionType string
-
-const (
-	VideoRenditionType RenditionType = "video"
-	AudioRenditionType RenditionType = "audio"
+var (
+	redisClient *redis.Client
+	locker      *redlock.Redlock
 )
 
-type MimeType string
-
-const (
-	Video       MimeType = "video/mp4"
-	Audio       MimeType = "audio/mp4"
-	Application MimeType = "application/mp4"
-)
+func HandleUpdateRendition(ctx aws.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("connection domain name: %s, stage: %s", event.RequestContext.DomainName, event.RequestContext.Stage)
+	message, err := signals.NewDataMessage(event.Body, event.IsBase64Encoded)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
 
-var renditionToMimeType = map[RenditionType]MimeType{
-	VideoRenditionType: Video,
-	AudioRenditionType: Audio,
-}
+	uploadLatency, err := message.UploadLatencyFromNow()
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	log.Println("upload time is ", uploadLatency)
 
-func GetMimeType(renditionType RenditionType) string {
-	if value, ok := renditionToMimeType[renditionType]; ok {
-		return string(value)
+	err = ingest.NewIngester(repository.NewStreamRepository(redisClient), locker).UpdateRendition(ctx, message)
+	var changeErr *ingest.IncompatibleChangeError
+	if errors.As(err, &changeErr) {
+		body, err := json.Marshal(changeErr)
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: helpers.StatusCodeOf(changeErr),
+			Headers: map[string]string{
+				"Content-Type": "application/json",
+			},
+			Body: string(body),
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
 	}
-	return string(Application)
-}
 
-func HandleUpdateRendition(_ aws.Context, event events.APIGatewayProxyRequest) error {
+	body, err := json.Marshal(signals.NewAck(message, uploadLatency))
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
 
-	return nil
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(body),
+	}, nil
 }
 
 func main() {
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: os.Getenv("REDIS_ADDRESS"),
+	})
+	locker = redlock.New(redisClient)
+	if addresses := os.Getenv("REDLOCK_ADDRESSES"); addresses != "" {
+		locker = redlock.NewFromAddresses(addresses)
+	}
 	lambda.Start(HandleUpdateRendition)
 }